@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookie  = "cfdnsupdater_session"
+	stateCookie    = "cfdnsupdater_oidc_state"
+	sessionLength  = 8 * time.Hour
+	oidcStateValid = 10 * time.Minute
+)
+
+// authenticator protects HTTP endpoints with a static bearer token, basic
+// authentication, TLS client certificates and/or an OIDC login. Any one of
+// them is enough. If none is configured, requests pass straight through.
+type authenticator struct {
+	token string
+	// user and password enable basic authentication if set
+	user, password string
+	// clientCerts accepts requests with a client certificate, which the
+	// TLS server has already verified against the client CA
+	clientCerts bool
+	oidc        *oidcAuth
+}
+
+// enabled reports whether any authentication is configured.
+func (a *authenticator) enabled() bool {
+	return a != nil && (a.token != "" || a.password != "" || a.clientCerts || a.oidc != nil)
+}
+
+// wrap returns h guarded by the configured authentication.
+func (a *authenticator) wrap(h http.Handler) http.Handler {
+	if !a.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+				subtle.ConstantTimeCompare([]byte(bearer), []byte(a.token)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if a.password != "" {
+			// compare both before deciding, so timing doesn't reveal which
+			// was wrong
+			if user, password, ok := r.BasicAuth(); ok &&
+				subtle.ConstantTimeCompare([]byte(user), []byte(a.user))&
+					subtle.ConstantTimeCompare([]byte(password), []byte(a.password)) == 1 {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if a.oidc != nil {
+			if a.oidc.validSession(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			a.oidc.login(w, r)
+			return
+		}
+		if a.token != "" {
+			w.Header().Add("WWW-Authenticate", "Bearer")
+		}
+		if a.password != "" {
+			w.Header().Add("WWW-Authenticate", `Basic realm="cfdnsupdater"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// oidcAuth logs browsers in through an OpenID Connect provider and keeps
+// them logged in with an HMAC-signed session cookie.
+type oidcAuth struct {
+	oauth2        oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	groupsClaim   string
+	allowedGroups []string
+	// secret signs session cookies; it is generated at startup, so
+	// sessions don't survive a restart
+	secret []byte
+}
+
+func newOIDCAuth(ctx context.Context, issuer, clientID, clientSecret, redirectURL, groupsClaim string, allowedGroups []string) (*oidcAuth, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &oidcAuth{
+		oauth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email", "groups"},
+		},
+		verifier:      provider.Verifier(&oidc.Config{ClientID: clientID}),
+		groupsClaim:   groupsClaim,
+		allowedGroups: allowedGroups,
+		secret:        secret,
+	}, nil
+}
+
+func (o *oidcAuth) sign(value string) string {
+	mac := hmac.New(sha256.New, o.secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks a signed value and returns the original.
+func (o *oidcAuth) verify(signed string) (string, bool) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", false
+	}
+	value := signed[:i]
+	return value, hmac.Equal([]byte(o.sign(value)), []byte(signed))
+}
+
+func (o *oidcAuth) validSession(r *http.Request) bool {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	value, ok := o.verify(cookie.Value)
+	if !ok {
+		return false
+	}
+	_, expiry, ok := strings.Cut(value, "|")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	return err == nil && time.Now().Before(time.Unix(unix, 0))
+}
+
+// login sends the browser to the provider, remembering where it was going.
+func (o *oidcAuth) login(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    o.sign(state + "|" + r.URL.RequestURI()),
+		Path:     "/",
+		MaxAge:   int(oidcStateValid.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, o.oauth2.AuthCodeURL(state), http.StatusFound)
+}
+
+// ServeHTTP handles the provider's redirect back to us after login.
+func (o *oidcAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	subject, target, err := o.callback(r)
+	if err != nil {
+		slog.Warn("OIDC login failed", "error", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	slog.Info("OIDC login", "user.id", subject)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    o.sign(subject + "|" + strconv.FormatInt(time.Now().Add(sessionLength).Unix(), 10)),
+		Path:     "/",
+		MaxAge:   int(sessionLength.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// callback validates the login response and returns the user and the page
+// they originally asked for.
+func (o *oidcAuth) callback(r *http.Request) (string, string, error) {
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		return "", "", errors.New("missing state cookie")
+	}
+	value, ok := o.verify(cookie.Value)
+	if !ok {
+		return "", "", errors.New("invalid state cookie")
+	}
+	state, target, _ := strings.Cut(value, "|")
+	if r.URL.Query().Get("state") != state {
+		return "", "", errors.New("state mismatch")
+	}
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		target = "/"
+	}
+
+	token, err := o.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		return "", "", err
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", errors.New("no ID token in token response")
+	}
+	idToken, err := o.verifier.Verify(r.Context(), raw)
+	if err != nil {
+		return "", "", err
+	}
+	if len(o.allowedGroups) > 0 {
+		var claims map[string]any
+		if err := idToken.Claims(&claims); err != nil {
+			return "", "", err
+		}
+		groups, _ := claims[o.groupsClaim].([]any)
+		allowed := slices.ContainsFunc(groups, func(g any) bool {
+			name, ok := g.(string)
+			return ok && slices.Contains(o.allowedGroups, name)
+		})
+		if !allowed {
+			return "", "", fmt.Errorf("user %s is not in an allowed group", idToken.Subject)
+		}
+	}
+	return idToken.Subject, target, nil
+}
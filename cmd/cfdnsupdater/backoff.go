@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/internal/redact"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// backoff works out how long to wait before the next cycle. After a
+// failure it retries sooner than the normal interval, doubling the delay
+// with each consecutive failure up to max, with jitter so that many
+// instances don't retry in lockstep. If retry is set, failures are
+// instead always retried after that fixed interval. Normal intervals are
+// spread by up to jitter (a fraction of the interval) either way.
+type backoff struct {
+	initial  time.Duration
+	max      time.Duration
+	retry    time.Duration
+	jitter   float64
+	failures int
+}
+
+// jittered returns d moved randomly by up to fraction of d either way.
+func jittered(d time.Duration, fraction float64) time.Duration {
+	spread := time.Duration(float64(d) * fraction)
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + rand.N(2*spread+1)
+}
+
+// start returns a random delay before the first cycle, so instances
+// started together don't all run at once.
+func (b *backoff) start(interval time.Duration) time.Duration {
+	spread := time.Duration(float64(interval) * b.jitter)
+	if spread <= 0 {
+		return 0
+	}
+	return rand.N(spread + 1)
+}
+
+// next returns the delay before the next cycle given the result of the
+// one that just finished, which started at now.
+func (b *backoff) next(err error, interval time.Duration, now time.Time) time.Duration {
+	if err == nil {
+		b.failures = 0
+		return jittered(interval, b.jitter)
+	}
+	if errors.Is(err, errBreakerOpen) {
+		// the breaker is already pacing IP lookups
+		return jittered(interval, b.jitter)
+	}
+	var limited *provider.RateLimitError
+	if errors.As(err, &limited) {
+		// the provider has told us when to come back
+		return max(limited.Until.Sub(now), 0) + rand.N(time.Second)
+	}
+	if b.retry > 0 {
+		return jittered(b.retry, b.jitter)
+	}
+	if b.initial <= 0 {
+		return jittered(interval, b.jitter)
+	}
+	b.failures++
+	delay := b.initial
+	for i := 1; i < b.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.max)
+	// equal jitter: somewhere between half and all of the delay
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// abandonAfter is how long a cycle may carry on after shutdown starts
+// before its requests are interrupted, leaving it time to wind up before
+// shutdownTimeout.
+const abandonAfter = shutdownTimeout - 5*time.Second
+
+var cycleTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "cfdnsupdater_cycle_timeouts_total",
+	Help: "The number of update cycles abandoned because they took too long",
+})
+
+// runTimedCycle runs a cycle, abandoning it if it takes longer than the
+// configured cycle timeout. The cycle isn't cancelled straight away with
+// parent, so an update in progress can finish, but its requests are
+// interrupted if it is still running once shutdown stops waiting for it.
+func runTimedCycle(parent context.Context, config CFUpdateConfig) error {
+	ctx, abandon := context.WithCancel(context.WithoutCancel(parent))
+	defer abandon()
+	stop := context.AfterFunc(parent, func() {
+		select {
+		case <-config.Clock.After(abandonAfter):
+			abandon()
+		case <-ctx.Done():
+		}
+	})
+	defer stop()
+	if config.CycleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.CycleTimeout)
+		defer cancel()
+	}
+	ctx, span := otlp.StartSpan(ctx, "update_cycle", "dns.question.name", config.Host, "dns.question.type", config.RecordType)
+	err := runCycle(ctx, config)
+	span.End(err)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		cycleTimeouts.Inc()
+		slog.ErrorContext(ctx, "Update cycle timed out", "timeout", config.CycleTimeout)
+	}
+	return redact.Error(err)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// retryDelay is the first pause between retries within a cycle.
+const retryDelay = time.Second
+
+// permanent reports whether err will happen again however soon the cycle is
+// retried, until someone changes the zone.
+func permanent(err error) bool {
+	return errors.Is(err, updater.ErrTooManyRecords) || errors.Is(err, provider.ErrZoneNotFound)
+}
+
+// runCycleWithRetries runs a cycle, retrying failures other than permanent
+// ones with doubling delays for up to budget. The budget is never allowed
+// to exceed interval, so a cycle can't run into the next scheduled one.
+func runCycleWithRetries(ctx context.Context, config CFUpdateConfig, budget, interval time.Duration) error {
+	budget = min(budget, interval)
+	ctx = withLogAttrs(ctx, "cycle.id", randomHex(8))
+	deadline := config.Clock.Now().Add(budget)
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := runTimedCycle(ctx, config)
+		var limited *provider.RateLimitError
+		if err == nil || budget <= 0 || errors.Is(err, errBreakerOpen) || errors.As(err, &limited) || permanent(err) {
+			return err
+		}
+		if config.Clock.Now().Add(delay).After(deadline) {
+			if attempt > 1 {
+				slog.WarnContext(ctx, "Retry budget exhausted, giving up on this cycle", "attempts", attempt, "budget", budget)
+			}
+			return err
+		}
+		slog.DebugContext(ctx, "Retrying failed cycle", "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-config.Clock.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+)
+
+// maxBreakerCooldown caps how long the breaker waits between probes.
+const maxBreakerCooldown = time.Hour
+
+var errBreakerOpen = errors.New("IP lookup circuit breaker is open")
+
+var (
+	breakerOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_ip_breaker_open",
+		Help: "Whether IP lookups are currently paused after repeated failures",
+	})
+	breakerShortCircuits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cfdnsupdater_ip_breaker_short_circuits_total",
+		Help: "The number of update cycles skipped because the IP lookup circuit breaker was open",
+	})
+)
+
+// circuitBreaker trips after a number of consecutive failures, then only
+// lets a single probe through each cooldown, doubling the cooldown each
+// time the probe fails. A success closes it again.
+type circuitBreaker struct {
+	threshold    int
+	baseCooldown time.Duration
+	clock        clock.Clock
+
+	mu        sync.Mutex
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, clock clock.Clock) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, baseCooldown: cooldown, clock: clock}
+}
+
+// allow reports whether a lookup should be attempted now. A nil breaker
+// always allows.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clock.Now().Before(b.openUntil) {
+		breakerShortCircuits.Inc()
+		return false
+	}
+	return true
+}
+
+// openedUntil returns when the breaker will next allow a lookup, or the
+// zero time if it is closed.
+func (b *circuitBreaker) openedUntil() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clock.Now().Before(b.openUntil) {
+		return b.openUntil
+	}
+	return time.Time{}
+}
+
+// record updates the breaker with the outcome of a lookup.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.cooldown != 0 {
+			slog.Info("IP lookups recovered, closing circuit breaker")
+		}
+		b.failures = 0
+		b.cooldown = 0
+		b.openUntil = time.Time{}
+		breakerOpen.Set(0)
+		return
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.cooldown == 0 {
+		b.cooldown = b.baseCooldown
+	} else {
+		b.cooldown = min(2*b.cooldown, maxBreakerCooldown)
+	}
+	b.openUntil = b.clock.Now().Add(b.cooldown)
+	breakerOpen.Set(1)
+	slog.Warn("IP lookups keep failing, pausing them", "failures", b.failures, "cooldown", b.cooldown)
+}
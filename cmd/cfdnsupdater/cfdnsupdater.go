@@ -0,0 +1,1342 @@
+package main
+
+//go:generate go run genversion.go
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/internal/redact"
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/desecprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/dyndnsprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/gandiprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/hetznerprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+	"jamesmcdonald.com/cfdnsupdater/pkg/plugin"
+	"jamesmcdonald.com/cfdnsupdater/pkg/porkbunprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/rfc2136provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/route53provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// shutdownTimeout bounds how long we wait for HTTP requests and any update
+// in progress when asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// HTTP server timeouts, so slow or idle clients can't tie up connections.
+// Handlers which legitimately take longer, like /trigger, lift the write
+// deadline themselves.
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = 30 * time.Second
+	httpWriteTimeout      = time.Minute
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+// newHTTPServer returns a server for handler on addr with our timeouts.
+func newHTTPServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		WriteTimeout:      httpWriteTimeout,
+		IdleTimeout:       httpIdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug),
+	}
+}
+
+// recordName identifies a managed record, and the provider that manages
+// it if that isn't the main one.
+type recordName struct {
+	Zone     string
+	Host     string
+	Provider string
+}
+
+func (n recordName) String() string {
+	if n.Provider == "" {
+		return n.Zone + "/" + n.Host
+	}
+	return n.Zone + "/" + n.Host + "@" + n.Provider
+}
+
+type CFUpdateConfig struct {
+	updater.Config
+	// IP says how IPSources detect the current IP. Its RecordType, Proxy
+	// and UserAgent are filled in from the rest of the config.
+	IP ipsource.Config
+	// IPSources are tried in priority order to detect the current IP.
+	IPSources ipsource.Sources
+	// IPBreaker stops us querying IP sources for a while after repeated
+	// failures. It may be nil.
+	IPBreaker *circuitBreaker
+	// ProviderName is cloudflare, route53, hetzner, gandi, porkbun, desec,
+	// dyndns2, duckdns, rfc2136 or plugin:command, see newProvider.
+	ProviderName string
+	// ProviderAPIBaseURL overrides the API endpoint of providers other
+	// than Cloudflare.
+	ProviderAPIBaseURL string
+	// HetznerToken is the Hetzner DNS API token.
+	HetznerToken string
+	// GandiToken is the Gandi personal access token.
+	GandiToken string
+	// PorkbunAPIKey and PorkbunSecretAPIKey are the Porkbun API key pair.
+	PorkbunAPIKey       string
+	PorkbunSecretAPIKey string
+	// DesecToken is the deSEC API token.
+	DesecToken string
+	// DyndnsURL, DyndnsUsername and DyndnsPassword describe the dyndns2
+	// server.
+	DyndnsURL      string
+	DyndnsUsername string
+	DyndnsPassword string
+	// DuckDNSToken is the DuckDNS account token.
+	DuckDNSToken string
+	// RFC2136Server is the server to send RFC 2136 updates to, signed
+	// with the TSIG key RFC2136KeyName if that is set.
+	RFC2136Server       string
+	RFC2136KeyName      string
+	RFC2136KeyAlgorithm string
+	RFC2136KeySecret    string
+	// TTL is the TTL of records created by providers other than
+	// Cloudflare, zero for the provider's default.
+	TTL int
+	// badTTLEnv is CFDNSUPDATER_TTL if it isn't a valid TTL.
+	badTTLEnv string
+
+	// Aliases are other names, possibly in other zones or managed by other
+	// providers, which are kept pointing at the same IP as Host.
+	Aliases []recordName
+	// CycleTimeout bounds how long a single update cycle may take.
+	CycleTimeout time.Duration
+	// MaxConsecutiveFailures stops the update loop when that many cycles
+	// in a row fail, if it is greater than zero.
+	MaxConsecutiveFailures int
+
+	// StatusPublisher, if set, is notified whenever the record changes.
+	StatusPublisher *StatusPublisher
+	// Notifiers are told about changes. It may be nil.
+	Notifiers *notifiers
+	// HookCommands are commands run on changes and failures. It may be nil.
+	HookCommands *hooks
+	// Targets are the host and each of its aliases, see newTargets.
+	Targets []*target
+	// Clock schedules cycles and retries.
+	Clock clock.Clock
+}
+
+// onlyCloudflare reports whether Cloudflare manages every target.
+func (config CFUpdateConfig) onlyCloudflare() bool {
+	for _, name := range config.names() {
+		if cmp.Or(name.Provider, config.ProviderName) != "cloudflare" {
+			return false
+		}
+	}
+	return true
+}
+
+// names returns the host and its aliases.
+func (config CFUpdateConfig) names() []recordName {
+	return append([]recordName{{Zone: config.Zone, Host: config.Host}}, config.Aliases...)
+}
+
+// liveness fails if an update cycle has been running for longer than
+// stuckAfter, which means the update loop has hung; restarting us is the
+// only fix. Zero disables the check.
+type liveness struct {
+	stuckAfter time.Duration
+}
+
+func (l liveness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if since := state.cycleRunning(); l.stuckAfter > 0 && since > l.stuckAfter {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := fmt.Fprintf(w, "Not alive, update cycle running for %s.", since.Round(time.Second)); err != nil {
+			slog.Error("error when responding with not alive", "error", err)
+		}
+		return
+	}
+	_, err := fmt.Fprint(w, "Alive.")
+	if err != nil {
+		slog.Error("error when responding with alive", "error", err)
+	}
+}
+
+// updated is set once an update cycle has succeeded, so we only report
+// ready once the record is known to be correct.
+var updated atomic.Bool
+
+// readiness fails until the first update succeeds, and while maxFailures
+// or more cycles in a row have failed, if it is greater than zero.
+type readiness struct {
+	maxFailures int
+}
+
+func (rd readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	message := ""
+	if !updated.Load() {
+		message = "Not ready."
+	} else if failures := state.failures(); rd.maxFailures > 0 && failures >= rd.maxFailures {
+		message = fmt.Sprintf("Not ready, %d consecutive updates failed.", failures)
+	}
+	if message != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if _, err := fmt.Fprint(w, message); err != nil {
+			slog.Error("error when responding with not ready", "error", err)
+		}
+		return
+	}
+	_, err := fmt.Fprint(w, "Ready.")
+	if err != nil {
+		slog.Error("error when responding with ready", "error", err)
+	}
+}
+
+// setupLogger logs in format, which is json, text, console, syslog or
+// journald, to w for the first three. The schema names the fields; the
+// ecs and gcp schemas are always json and logfmt is always text.
+func setupLogger(debug bool, format, schema string, w io.Writer) error {
+	schemaAttr, err := logSchemaAttr(schema)
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			return redact.Attr(groups, schemaAttr(groups, a))
+		},
+	}
+	if debug {
+		logLevel.Set(slog.LevelDebug)
+	}
+
+	if schema != "default" {
+		if format != "json" && format != "text" {
+			return fmt.Errorf("the %s log schema can't be used with the %s log format", schema, format)
+		}
+		format = "json"
+		if schema == "logfmt" {
+			format = "text"
+		}
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "console":
+		handler = newConsoleHandler(w, *opts)
+	case "syslog", "journald":
+		if format == "syslog" {
+			handler, err = newSyslogHandler(*opts)
+		} else {
+			handler, err = newJournaldHandler(*opts)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("log format must be json, text, console, syslog or journald (got %s)", format)
+	}
+	logger := slog.New(contextHandler{handler})
+	// the console is read by someone who knows what they are running
+	if format != "console" {
+		logger = logger.With(
+			"service.name", "cfdnsupdater",
+			"service.version", Version,
+			"event.module", "cloudflare",
+		)
+	}
+	if schema == "ecs" {
+		logger = logger.With("ecs.version", ecsVersion)
+	}
+	slog.SetDefault(logger)
+
+	// logrus.FieldKeyTime:  "@timestamp",
+	// logrus.FieldKeyLevel: "level",
+	// logrus.FieldKeyMsg:   "message",
+	// logrus.FieldKeyFunc:  "caller",
+	return nil
+}
+
+// publishStatus writes the status document if one is configured. Failures
+// are logged but don't fail the update, as the DNS change already happened.
+func publishStatus(ctx context.Context, config CFUpdateConfig, ip string, changes []updater.Change) {
+	if config.StatusPublisher == nil {
+		return
+	}
+	names := make([]string, len(changes))
+	for i, c := range changes {
+		names[i] = c.Host
+	}
+	if err := config.StatusPublisher.Publish(ctx, func() (*cloudflare.API, error) { return cloudflareAPI(config) }, config.Host, ip, names); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish status document", "error", err)
+		return
+	}
+	slog.DebugContext(ctx, "Published status document", "url", config.StatusPublisher.URL)
+}
+
+// newProvider returns the configured provider, or nil for Cloudflare so
+// that the updater makes a client with fresh credentials each time.
+func newProvider(config CFUpdateConfig) (provider.Provider, error) {
+	switch config.ProviderName {
+	case "cloudflare":
+		return nil, nil
+	case "route53":
+		return route53provider.New(route53provider.Config{
+			Zone:       config.Zone,
+			ZoneID:     config.ZoneID,
+			TTL:        config.TTL,
+			Endpoint:   config.ProviderAPIBaseURL,
+			HTTPClient: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+			Clock:      config.Clock,
+		}), nil
+	case "hetzner":
+		return hetznerprovider.New(hetznerprovider.Config{
+			Token:      config.HetznerToken,
+			Zone:       config.Zone,
+			ZoneID:     config.ZoneID,
+			TTL:        config.TTL,
+			BaseURL:    config.ProviderAPIBaseURL,
+			HTTPClient: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+		})
+	case "gandi":
+		return gandiprovider.New(gandiprovider.Config{
+			Token:      config.GandiToken,
+			TTL:        config.TTL,
+			BaseURL:    config.ProviderAPIBaseURL,
+			HTTPClient: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+		})
+	case "porkbun":
+		return porkbunprovider.New(porkbunprovider.Config{
+			APIKey:       config.PorkbunAPIKey,
+			SecretAPIKey: config.PorkbunSecretAPIKey,
+			TTL:          config.TTL,
+			BaseURL:      config.ProviderAPIBaseURL,
+			HTTPClient:   &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+		})
+	case "desec":
+		return desecprovider.New(desecprovider.Config{
+			Token:      config.DesecToken,
+			TTL:        config.TTL,
+			BaseURL:    config.ProviderAPIBaseURL,
+			HTTPClient: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+			Clock:      config.Clock,
+		})
+	case "dyndns2":
+		return dyndnsprovider.New(dyndnsprovider.Config{
+			UpdateURL:  config.DyndnsURL,
+			Username:   config.DyndnsUsername,
+			Password:   config.DyndnsPassword,
+			UserAgent:  fmt.Sprintf("cfdnsupdater/%s", Version),
+			HTTPClient: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+			Clock:      config.Clock,
+		})
+	case "duckdns":
+		return dyndnsprovider.New(dyndnsprovider.Config{
+			UpdateURL:    config.ProviderAPIBaseURL,
+			DuckDNSToken: config.DuckDNSToken,
+			UserAgent:    fmt.Sprintf("cfdnsupdater/%s", Version),
+			HTTPClient:   &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+			Clock:        config.Clock,
+		})
+	case "rfc2136":
+		return rfc2136provider.New(rfc2136provider.Config{
+			Server:       config.RFC2136Server,
+			KeyName:      config.RFC2136KeyName,
+			KeyAlgorithm: config.RFC2136KeyAlgorithm,
+			KeySecret:    config.RFC2136KeySecret,
+			TTL:          config.TTL,
+			Clock:        config.Clock,
+		})
+	}
+	if commandLine, ok := strings.CutPrefix(config.ProviderName, "plugin:"); ok {
+		return plugin.NewProvider(commandLine)
+	}
+	return nil, fmt.Errorf("Provider must be cloudflare, route53, hetzner, gandi, porkbun, desec, dyndns2, duckdns, rfc2136 or plugin:command (got %s)", config.ProviderName)
+}
+
+// detectIP looks up our current address for the configured record type.
+func detectIP(ctx context.Context, config CFUpdateConfig) (string, error) {
+	ip := config.IP
+	ip.RecordType, ip.Proxy = config.RecordType, config.Proxy
+	ip.UserAgent = fmt.Sprintf("cfdnsupdater/%s", Version)
+	return config.IPSources.Detect(ctx, ip)
+}
+
+// runCycle detects the current IP and updates the host's record, and those
+// of any aliases, with it.
+func runCycle(ctx context.Context, config CFUpdateConfig) error {
+	slog.DebugContext(ctx, "Starting update of host", "fqdn", config.Host)
+	if !config.IPBreaker.allow() {
+		slog.DebugContext(ctx, "IP service circuit breaker is open, skipping update")
+		return errBreakerOpen
+	}
+	if err := cfprovider.RateLimited(); err != nil && config.onlyCloudflare() {
+		slog.WarnContext(ctx, "Skipping update while rate limited by Cloudflare", "error", err)
+		return err
+	}
+	spanCtx, span := otlp.StartSpan(ctx, "ip_lookup")
+	ip, err := detectIP(spanCtx, config)
+	span.Set("destination.address", ip)
+	span.End(err)
+	config.IPBreaker.record(err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get IP", "error", err)
+		return updater.Failed(config.Config, updater.StageIPLookup, err)
+	}
+	slog.DebugContext(ctx, "Got IP", "ip", ip)
+	state.detected(ip)
+
+	var changes []updater.Change
+	var names []string
+	var errs []error
+	for _, t := range config.Targets {
+		hostCtx, span := otlp.StartSpan(ctx, "update_host", "dns.question.name", t.Host, "provider", t.Provider)
+		change, err := t.update(hostCtx, ip, config.Clock.Now())
+		span.End(err)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update DNS", "fqdn", t.Host, "provider", t.Provider, "error", err)
+			errs = append(errs, err)
+		} else if config.Canary == nil {
+			confirmIP(t.Zone, t.Host, config.RecordType, ip)
+		}
+		if change != nil {
+			changes = append(changes, *change)
+			names = append(names, t.Host+"@"+t.Provider)
+		}
+	}
+	if len(changes) > 0 {
+		state.changed()
+		for _, c := range changes {
+			lastIPChange.WithLabelValues(c.Zone, c.Host).SetToCurrentTime()
+			config.Notifiers.send(notification{Event: notifyChange, Zone: c.Zone, Host: c.Host, OldIP: c.OldIP, NewIP: c.NewIP})
+			config.HookCommands.changed(c)
+		}
+		if len(config.Aliases) > 0 {
+			slog.InfoContext(ctx, "IP changed for host group", "names", names, "ip", ip)
+		}
+		publishStatus(ctx, config, ip, changes)
+	}
+	return cycleError(config.Targets, errs)
+}
+
+// updateHostLoop runs update cycles every sleep until ctx is cancelled,
+// retrying within each cycle for up to budget and backing off according
+// to retry after failed cycles. Intervals are measured from the start of
+// each cycle so the schedule doesn't drift by however long the work takes.
+// A cycle in progress is allowed to finish; the returned channel is closed
+// once the loop has stopped. If the loop gives up after too many
+// consecutive failures, the error is sent on the channel first. Panics are
+// recovered and the loop restarted. A channel received from triggers runs
+// a cycle immediately, and is sent its result.
+func updateHostLoop(ctx context.Context, config CFUpdateConfig, sleep, budget time.Duration, retry *backoff, triggers <-chan chan<- error) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		failures := 0
+		delay := retry.start(sleep)
+		supervise(ctx, config.Clock, "update", func() {
+			wake := config.Clock.After(delay)
+			// after a panic, resume straight after the supervisor's pause
+			delay = 0
+			for {
+				var reply chan<- error
+				select {
+				case <-ctx.Done():
+					return
+				case <-wake:
+				case reply = <-triggers:
+					slog.Info("Update triggered over HTTP")
+				}
+				if ctx.Err() != nil {
+					// a late cycle may be due straight away, and select
+					// picks at random among ready cases
+					return
+				}
+				start := config.Clock.Now()
+				state.started(start)
+				deprecations.log()
+				err := runCycleWithRetries(ctx, config, budget, sleep)
+				if reply != nil {
+					reply <- err
+				}
+				if err == nil {
+					lastSuccess.SetToCurrentTime()
+					if !updated.Swap(true) {
+						slog.Info("First update complete, ready")
+					}
+				}
+				if err != nil {
+					failures++
+				} else {
+					failures = 0
+				}
+				state.finished(err, failures)
+				config.Notifiers.cycleFinished(config, err, failures)
+				if err != nil {
+					config.HookCommands.cycleFailed(config, err, failures)
+				}
+				if config.MaxConsecutiveFailures > 0 && failures >= config.MaxConsecutiveFailures {
+					done <- fmt.Errorf("%d consecutive update cycles failed, last error: %w", failures, err)
+					return
+				}
+				wait := retry.next(err, sleep, start)
+				slog.Debug("Finished update, sleeping", "interval", wait, "next", start.Add(wait))
+				wake = config.Clock.After(start.Add(wait).Sub(config.Clock.Now()))
+			}
+		})
+	}()
+	return done
+}
+
+// interval is a flag.Value for durations which also accepts a bare number
+// of seconds, as -sleep-interval did before it took durations.
+type interval struct {
+	time.Duration
+	// seconds records that the deprecated bare seconds form was used
+	seconds bool
+}
+
+func (i *interval) Set(s string) error {
+	if secs, err := strconv.ParseUint(s, 10, 0); err == nil {
+		i.Duration = time.Duration(secs) * time.Second
+		i.seconds = true
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("interval %s is negative", s)
+	}
+	i.Duration = d
+	i.seconds = false
+	return nil
+}
+
+// addRecordFlags registers the flags identifying the managed record and the
+// Cloudflare credentials, shared by the daemon and the subcommands.
+func addRecordFlags(fs *flag.FlagSet, config *CFUpdateConfig) {
+	fs.StringVar(&config.Zone, "zone", os.Getenv("CFDNSUPDATER_ZONE"), "name of the zone to update")
+	fs.StringVar(&config.Host, "host", os.Getenv("CFDNSUPDATER_HOST"), "FQDN of the host to update")
+	fs.StringVar(&config.ProviderName, "provider", cmp.Or(os.Getenv("CFDNSUPDATER_PROVIDER"), "cloudflare"), "DNS `provider` hosting the zone: cloudflare, route53 (with the standard AWS credentials), hetzner, gandi, porkbun, desec, dyndns2, duckdns, rfc2136, or plugin:command to run a provider plugin")
+	fs.StringVar(&config.ProviderAPIBaseURL, "provider-api-base-url", os.Getenv("CFDNSUPDATER_PROVIDER_API_BASE_URL"), "base URL of the API of providers other than Cloudflare, e.g. for a mock server")
+	fs.StringVar(&config.HetznerToken, "hetzner-token", os.Getenv("HETZNER_DNS_TOKEN"), "Hetzner DNS API token, for -provider hetzner")
+	fs.StringVar(&config.GandiToken, "gandi-token", os.Getenv("GANDI_PAT"), "Gandi personal access token with LiveDNS rights, for -provider gandi")
+	fs.StringVar(&config.PorkbunAPIKey, "porkbun-api-key", os.Getenv("PORKBUN_API_KEY"), "Porkbun API key, for -provider porkbun")
+	fs.StringVar(&config.PorkbunSecretAPIKey, "porkbun-secret-api-key", os.Getenv("PORKBUN_SECRET_API_KEY"), "Porkbun secret API key, for -provider porkbun")
+	fs.StringVar(&config.DesecToken, "desec-token", os.Getenv("DESEC_TOKEN"), "deSEC API token, for -provider desec")
+	fs.StringVar(&config.DyndnsURL, "dyndns-url", os.Getenv("DYNDNS_URL"), "dyndns2 update `URL`, such as https://members.dyndns.org/nic/update, for -provider dyndns2")
+	fs.StringVar(&config.DyndnsUsername, "dyndns-username", os.Getenv("DYNDNS_USERNAME"), "dyndns2 username, for -provider dyndns2")
+	fs.StringVar(&config.DyndnsPassword, "dyndns-password", os.Getenv("DYNDNS_PASSWORD"), "dyndns2 password, for -provider dyndns2")
+	fs.StringVar(&config.DuckDNSToken, "duckdns-token", os.Getenv("DUCKDNS_TOKEN"), "DuckDNS token, for -provider duckdns with -zone duckdns.org")
+	fs.StringVar(&config.RFC2136Server, "rfc2136-server", os.Getenv("RFC2136_SERVER"), "primary `server` for the zone, as host[:port], for -provider rfc2136")
+	fs.StringVar(&config.RFC2136KeyName, "rfc2136-key-name", os.Getenv("RFC2136_KEY_NAME"), "`name` of the TSIG key to sign updates with, for -provider rfc2136")
+	fs.StringVar(&config.RFC2136KeyAlgorithm, "rfc2136-key-algorithm", cmp.Or(os.Getenv("RFC2136_KEY_ALGORITHM"), "hmac-sha256"), "TSIG key `algorithm`, one of hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512")
+	fs.StringVar(&config.RFC2136KeySecret, "rfc2136-key-secret", os.Getenv("RFC2136_KEY_SECRET"), "base64 TSIG key `secret`, as in BIND's key files")
+	setTTL := func(s string) error {
+		ttl, err := strconv.Atoi(s)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("must be a number of seconds (got %s)", s)
+		}
+		config.TTL, config.badTTLEnv = ttl, ""
+		return nil
+	}
+	if s := os.Getenv("CFDNSUPDATER_TTL"); s != "" && setTTL(s) != nil {
+		// checkZoneConfig reports it, unless -ttl overrides it
+		config.badTTLEnv = s
+	}
+	fs.Func("ttl", "TTL in `seconds` of records created by providers other than Cloudflare, default depending on the provider (env: CFDNSUPDATER_TTL)", setTTL)
+	fs.StringVar(&config.Email, "email", os.Getenv("CLOUDFLARE_EMAIL"), "Cloudflare account email address")
+	fs.StringVar(&config.ApiKey, "api-key", os.Getenv("CLOUDFLARE_API_KEY"), "Cloudflare account API key")
+	fs.StringVar(&config.ApiToken, "api-token", os.Getenv("CLOUDFLARE_API_TOKEN"), "Cloudflare API token, instead of -email and -api-key")
+	fs.StringVar(&config.ApiTokenFile, "api-token-file", os.Getenv("CLOUDFLARE_API_TOKEN_FILE"), "path of a file containing the Cloudflare API token, instead of -api-token")
+	fs.StringVar(&config.RecordType, "record-type", cmp.Or(os.Getenv("CFDNSUPDATER_RECORD_TYPE"), "A"), "type of record to manage, A or AAAA")
+	fs.StringVar(&config.Proxy, "proxy", os.Getenv("CFDNSUPDATER_PROXY"), "URL of an HTTP(S) proxy for outbound requests (default from HTTPS_PROXY/HTTP_PROXY)")
+	fs.StringVar(&config.ZoneID, "zone-id", os.Getenv("CFDNSUPDATER_ZONE_ID"), "ID of the zone, to avoid looking it up by name")
+	fs.StringVar(&config.AccountID, "account-id", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "ID of the account to look the zone up in")
+	fs.StringVar(&config.APIBaseURL, "cf-api-base-url", os.Getenv("CFDNSUPDATER_CF_API_BASE_URL"), "base URL of the Cloudflare API, e.g. for a mock server (default https://api.cloudflare.com/client/v4)")
+	if socks := os.Getenv("CFDNSUPDATER_SOCKS5"); socks != "" && config.Proxy == "" {
+		config.Proxy = "socks5://" + socks
+	}
+	fs.Func("socks5", "`host:port` of a SOCKS5 proxy for outbound requests (env: CFDNSUPDATER_SOCKS5)", func(addr string) error {
+		config.Proxy = "socks5://" + addr
+		return nil
+	})
+}
+
+// addHeader parses a "Name: value" header and adds it to h.
+func addHeader(h http.Header, header string) error {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("header must be in the form \"Name: value\" (got %q)", header)
+	}
+	h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+// parseAlias parses a zone/host alias, optionally followed by @provider.
+func parseAlias(alias string) (recordName, error) {
+	name, providerName, hasProvider := strings.Cut(strings.TrimSpace(alias), "@")
+	zone, host, ok := strings.Cut(name, "/")
+	if !ok || zone == "" || host == "" || (hasProvider && providerName == "") {
+		return recordName{}, fmt.Errorf("alias must be zone/host or zone/host@provider (got %s)", alias)
+	}
+	if !strings.HasSuffix(host, zone) {
+		return recordName{}, fmt.Errorf("alias host %s must end with its zone %s", host, zone)
+	}
+	return recordName{Zone: zone, Host: host, Provider: providerName}, nil
+}
+
+// checkRecordConfig validates the settings registered by addRecordFlags.
+func checkRecordConfig(config CFUpdateConfig) error {
+	if err := checkZoneConfig(config); err != nil {
+		return err
+	}
+	if config.Host == "" {
+		return errors.New("Host name must be set, set -host or CFDNSUPDATER_HOST")
+	}
+	if !strings.HasSuffix(config.Host, config.Zone) {
+		return errors.New("The host name must end with the zone name")
+	}
+	if _, err := ipsource.Network(config.RecordType, ""); err != nil {
+		return fmt.Errorf("Record type must be A or AAAA (got %s)", config.RecordType)
+	}
+	return nil
+}
+
+// checkZoneConfig validates the settings registered by addRecordFlags that
+// are needed to access the zone, for commands that don't work on the host.
+func checkZoneConfig(config CFUpdateConfig) error {
+	if config.Zone == "" {
+		return errors.New("Zone name must be set, set -zone or CFDNSUPDATER_ZONE")
+	}
+	if config.badTTLEnv != "" {
+		return fmt.Errorf("CFDNSUPDATER_TTL must be a number of seconds (got %s)", config.badTTLEnv)
+	}
+	// every provider's requests and the IP lookups go through the proxy
+	if config.Proxy != "" {
+		u, err := url.Parse(config.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("Proxy must be a URL (got %s)", config.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("Proxy scheme must be http, https or socks5 (got %s)", u.Scheme)
+		}
+	}
+	if config.ProviderName == "hetzner" && config.HetznerToken == "" {
+		return errors.New("Hetzner DNS API token must be set, set -hetzner-token or HETZNER_DNS_TOKEN")
+	}
+	if config.ProviderName == "gandi" && config.GandiToken == "" {
+		return errors.New("Gandi personal access token must be set, set -gandi-token or GANDI_PAT")
+	}
+	if config.ProviderName == "porkbun" && (config.PorkbunAPIKey == "" || config.PorkbunSecretAPIKey == "") {
+		return errors.New("Porkbun API keys must be set, set -porkbun-api-key and -porkbun-secret-api-key or PORKBUN_API_KEY and PORKBUN_SECRET_API_KEY")
+	}
+	if config.ProviderName == "desec" && config.DesecToken == "" {
+		return errors.New("deSEC API token must be set, set -desec-token or DESEC_TOKEN")
+	}
+	if config.ProviderName == "dyndns2" && (config.DyndnsURL == "" || config.DyndnsUsername == "" || config.DyndnsPassword == "") {
+		return errors.New("dyndns2 server must be set, set -dyndns-url, -dyndns-username and -dyndns-password or DYNDNS_URL, DYNDNS_USERNAME and DYNDNS_PASSWORD")
+	}
+	if config.ProviderName == "duckdns" && config.DuckDNSToken == "" {
+		return errors.New("DuckDNS token must be set, set -duckdns-token or DUCKDNS_TOKEN")
+	}
+	if config.ProviderName == "rfc2136" && config.RFC2136Server == "" {
+		return errors.New("RFC 2136 server must be set, set -rfc2136-server or RFC2136_SERVER")
+	}
+	if _, err := newProvider(config); err != nil {
+		return err
+	}
+	if config.ProviderName != "cloudflare" {
+		return nil
+	}
+	if config.ApiTokenFile != "" {
+		if config.ApiToken != "" {
+			return errors.New("Set only one of -api-token and -api-token-file")
+		}
+		if _, err := cfprovider.ReadTokenFile(config.ApiTokenFile); err != nil {
+			return err
+		}
+	}
+	if config.ApiToken != "" || config.ApiTokenFile != "" {
+		if config.Email != "" || config.ApiKey != "" {
+			return errors.New("An API token can't be combined with an email and API key")
+		}
+	} else {
+		if config.Email == "" {
+			return errors.New("Cloudflare email must be set, set -email or CLOUDFLARE_EMAIL")
+		}
+		if config.ApiKey == "" {
+			return errors.New("API key must be set, set -api-key or CLOUDFLARE_API_KEY, or use -api-token")
+		}
+	}
+	if config.APIBaseURL != "" {
+		u, err := url.Parse(config.APIBaseURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("Cloudflare API base URL must be an http(s) URL (got %s)", config.APIBaseURL)
+		}
+	}
+	return nil
+}
+
+func main() {
+	defer exitOnPanic()
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	debug := flag.Bool("debug", false, "enable debug logging")
+	noJSON := flag.Bool("no-json", false, "disable json logging, same as -log-format text")
+	logSchema := flag.String("log-schema", cmp.Or(os.Getenv("CFDNSUPDATER_LOG_SCHEMA"), "default"), "log field names: default, ecs for strict Elastic Common Schema json, logfmt for plain text or gcp for Google Cloud Logging json")
+	logFormat := flag.String("log-format", cmp.Or(os.Getenv("CFDNSUPDATER_LOG_FORMAT"), "json"), "log format: json, text, console for reading in a terminal, syslog for the local syslog daemon or journald")
+	logOutput := flag.String("log-output", cmp.Or(os.Getenv("CFDNSUPDATER_LOG_OUTPUT"), "stdout"), "where to log: stdout, stderr or the path of a file")
+	logMaxSize := flag.Int64("log-max-size", 100, "rotate the log file when it grows past this many megabytes, 0 for no limit")
+	logMaxAge := flag.Duration("log-max-age", 0, "rotate the log file when it is older than this, 0 for no limit")
+	logBackups := flag.Int("log-max-backups", 5, "number of rotated log files to keep, 0 to keep them all")
+	var config CFUpdateConfig
+	addRecordFlags(flag.CommandLine, &config)
+	ipService := flag.String("ip-service", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE"), ipsource.DefaultService), "The URL of a service which returns our current IP")
+	ipServiceFormat := flag.String("ip-service-format", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE_FORMAT"), "text"), "format of the IP service response, text or json")
+	ipServiceField := flag.String("ip-service-field", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE_FIELD"), "ip"), "dotted path to the address in a json IP service response, e.g. ip or data.address")
+	ipServiceHeaders := http.Header{}
+	var headerErr error
+	for _, h := range strings.Split(os.Getenv("CFDNSUPDATER_IP_SERVICE_HEADERS"), "\n") {
+		if h != "" && headerErr == nil {
+			// defer reporting until logger is set up
+			headerErr = addHeader(ipServiceHeaders, h)
+		}
+	}
+	flag.Func("ip-service-header", "extra `header` for the IP service request as \"Name: value\", may be repeated (env: CFDNSUPDATER_IP_SERVICE_HEADERS, newline separated)", func(h string) error {
+		return addHeader(ipServiceHeaders, h)
+	})
+	ipServiceBasicAuth := flag.String("ip-service-basic-auth", os.Getenv("CFDNSUPDATER_IP_SERVICE_BASIC_AUTH"), "user:password for basic authentication to the IP service")
+	var ipSourceSpecs []string
+	if env := os.Getenv("CFDNSUPDATER_IP_SOURCES"); env != "" {
+		ipSourceSpecs = strings.Split(env, ",")
+	}
+	flag.Func("ip-source", "`source` of the current IP in priority order: http (the -ip-service URL), an http(s) URL, stun:host[:port], upnp, dns[:name@server], interface:name, exec:command or plugin:command; may be repeated (env: CFDNSUPDATER_IP_SOURCES, comma separated)", func(spec string) error {
+		ipSourceSpecs = append(ipSourceSpecs, spec)
+		return nil
+	})
+	ipServiceCA := flag.String("ip-service-ca", os.Getenv("CFDNSUPDATER_IP_SERVICE_CA"), "path to a PEM CA bundle to verify the IP service with")
+	ipServiceCert := flag.String("ip-service-cert", os.Getenv("CFDNSUPDATER_IP_SERVICE_CERT"), "path to a PEM client certificate for the IP service")
+	ipServiceKey := flag.String("ip-service-key", os.Getenv("CFDNSUPDATER_IP_SERVICE_KEY"), "path to the PEM private key for -ip-service-cert")
+	ipServiceInsecure := flag.Bool("ip-service-insecure", os.Getenv("CFDNSUPDATER_IP_SERVICE_INSECURE") != "", "skip verifying the IP service's certificate (dangerous)")
+	ipNetworkOverride := flag.String("ip-network", os.Getenv("CFDNSUPDATER_IP_NETWORK"), "network used to reach the IP service, tcp4, tcp6 or auto (default tcp4 for A, tcp6 for AAAA)")
+	iface := flag.String("interface", os.Getenv("CFDNSUPDATER_INTERFACE"), "network interface to detect the IP through, for multi-WAN hosts")
+	sourceAddress := flag.String("source-address", os.Getenv("CFDNSUPDATER_SOURCE_ADDRESS"), "local address to detect the IP from, for multi-homed hosts")
+	bindToDevice := flag.Bool("bind-to-device", os.Getenv("CFDNSUPDATER_BIND_TO_DEVICE") != "", "bind IP detection sockets to -interface with SO_BINDTODEVICE (Linux only)")
+	breakerThreshold := flag.Int("ip-breaker-threshold", 3, "consecutive IP lookup failures before pausing lookups, 0 to disable")
+	breakerCooldown := flag.Duration("ip-breaker-cooldown", 5*time.Minute, "initial pause after the IP lookup circuit breaker trips, doubled on each failed probe")
+	tokenCheckInterval := flag.Duration("token-check-interval", 6*time.Hour, "how often to verify the API token, 0 to disable")
+	tokenExpiryWarning := flag.Duration("token-expiry-warning", 14*24*time.Hour, "warn when the API token expires within this period")
+	observeOnly := flag.Bool("observe-only", os.Getenv("CFDNSUPDATER_OBSERVE_ONLY") != "", "never change records, instead compare our decisions with what another instance does and report them on /canary")
+	canaryGrace := flag.Duration("canary-grace", 10*time.Minute, "how long another instance has to make an update we decided on before it counts as a difference")
+	jitter, badJitterEnv := 0, ""
+	setJitter := func(s string) error {
+		j, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("must be a percentage (got %s)", s)
+		}
+		jitter, badJitterEnv = j, ""
+		return nil
+	}
+	if s := os.Getenv("CFDNSUPDATER_JITTER"); s != "" && setJitter(s) != nil {
+		// reported below, unless -jitter overrides it
+		badJitterEnv = s
+	}
+	flag.Func("jitter", "randomly vary the sleep interval by up to this `percentage`, and delay the first run by up to that much (env: CFDNSUPDATER_JITTER)", setJitter)
+	backoffInitial := flag.Duration("backoff-initial", 15*time.Second, "delay before retrying after a failed update, doubled for each consecutive failure, 0 to always wait the sleep interval")
+	backoffMax := flag.Duration("backoff-max", 30*time.Minute, "maximum delay between retries after failed updates")
+	httpBasicAuth := flag.String("http-basic-auth", os.Getenv("CFDNSUPDATER_HTTP_BASIC_AUTH"), "user:password for basic authentication to the metrics and status endpoints")
+	httpToken := flag.String("http-token", os.Getenv("CFDNSUPDATER_HTTP_TOKEN"), "bearer token required for the metrics and status endpoints, and to enable POST /trigger")
+	oidcIssuer := flag.String("oidc-issuer", os.Getenv("CFDNSUPDATER_OIDC_ISSUER"), "OpenID Connect issuer URL to log in to the metrics and status endpoints with")
+	oidcClientID := flag.String("oidc-client-id", os.Getenv("CFDNSUPDATER_OIDC_CLIENT_ID"), "OpenID Connect client ID")
+	oidcClientSecret := flag.String("oidc-client-secret", os.Getenv("CFDNSUPDATER_OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	oidcRedirectURL := flag.String("oidc-redirect-url", os.Getenv("CFDNSUPDATER_OIDC_REDIRECT_URL"), "external URL of our <urlprefix>/oauth2/callback endpoint")
+	oidcGroups := flag.String("oidc-allowed-groups", os.Getenv("CFDNSUPDATER_OIDC_ALLOWED_GROUPS"), "comma separated groups allowed to log in, default any authenticated user")
+	oidcGroupsClaim := flag.String("oidc-groups-claim", cmp.Or(os.Getenv("CFDNSUPDATER_OIDC_GROUPS_CLAIM"), "groups"), "ID token claim listing the user's groups")
+	maxFailures := flag.Int("max-consecutive-failures", 0, "exit with an error after this many consecutive failed runs, 0 to keep trying forever")
+	multipleRecords := flag.String("multiple-records", cmp.Or(os.Getenv("CFDNSUPDATER_MULTIPLE_RECORDS"), "error"), "what to do if the host has several records: error, update-all to point them all at the IP, or consolidate to keep one and delete the rest")
+	markRecords := flag.Bool("mark-records", os.Getenv("CFDNSUPDATER_MARK_RECORDS") != "", "set the comment of records we write to say cfdnsupdater manages them")
+	respectOwner := flag.Bool("respect-record-owner", os.Getenv("CFDNSUPDATER_RESPECT_RECORD_OWNER") != "", "refuse to modify records whose comment says another tool manages them")
+	ownerID := flag.String("owner-id", os.Getenv("CFDNSUPDATER_OWNER_ID"), "identify this instance in a TXT ownership record and never modify hosts owned by another")
+	registryPrefix := flag.String("registry-prefix", cmp.Or(os.Getenv("CFDNSUPDATER_REGISTRY_PREFIX"), "cfdnsupdater-owner."), "prefix added to the host name to name the TXT ownership record")
+	cleanupDuplicates := flag.Bool("cleanup-duplicates", os.Getenv("CFDNSUPDATER_CLEANUP_DUPLICATES") != "", "if the host has several records, keep one and delete the rest, same as -multiple-records consolidate")
+	forceUpdate := flag.Duration("force-update-every", 0, "rewrite the record this often even if the IP is unchanged, to undo changes made elsewhere and keep its modified time fresh, 0 to disable")
+	recordRevalidate := flag.Duration("record-revalidate-interval", time.Hour, "how long to trust the cached record before reading it from Cloudflare again, 0 to read it every run")
+	unchangedInterval := flag.Duration("unchanged-log-interval", time.Hour, "after logging that the IP is already correct, only log a summary this often while it stays unchanged, 0 to log it every run")
+	auditLogPath := flag.String("audit-log", os.Getenv("CFDNSUPDATER_AUDIT_LOG"), "path of a file to append a JSON line to for every DNS change made or attempted")
+	cycleTimeout := flag.Duration("cycle-timeout", 2*time.Minute, "abandon an update cycle that takes longer than this, 0 for no limit")
+	var aliases []recordName
+	var aliasErr error
+	if env := os.Getenv("CFDNSUPDATER_ALIASES"); env != "" {
+		for _, a := range strings.Split(env, ",") {
+			alias, err := parseAlias(a)
+			if err != nil {
+				// defer reporting until logger is set up
+				aliasErr = err
+			}
+			aliases = append(aliases, alias)
+		}
+	}
+	flag.Func("alias", "another `zone/host` to keep at the same IP as -host, may be repeated; zone/host@provider updates it with another -provider, using that provider's settings (env: CFDNSUPDATER_ALIASES, comma separated)", func(a string) error {
+		alias, err := parseAlias(a)
+		aliases = append(aliases, alias)
+		return err
+	})
+	retryBudget := flag.Duration("retry-budget", 0, "time to spend retrying a failed cycle before giving up until the next one, capped at the sleep interval")
+	listen := flag.String("listen", ":9876", "listen parameter")
+	readyMaxFailures := flag.Int("ready-max-failures", 3, "report not ready on /ready after this many consecutive failed updates, 0 to stay ready once the first update succeeds")
+	noHTTP := flag.Bool("no-http", os.Getenv("CFDNSUPDATER_NO_HTTP") != "", "don't run the HTTP server, so no port is needed")
+	tlsCert := flag.String("tls-cert", os.Getenv("CFDNSUPDATER_TLS_CERT"), "path to a PEM certificate to serve HTTPS on -listen with, reloaded when it changes")
+	tlsKey := flag.String("tls-key", os.Getenv("CFDNSUPDATER_TLS_KEY"), "path to the PEM private key for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", os.Getenv("CFDNSUPDATER_TLS_CLIENT_CA"), "path to a PEM CA bundle; client certificates it signed are accepted instead of other authentication")
+	enablePprof := flag.Bool("enable-pprof", false, "serve net/http/pprof profiles for debugging")
+	pprofListen := flag.String("pprof-listen", "localhost:6060", "separate address to serve pprof profiles on, or empty to serve them under <urlprefix>/debug/pprof/ on -listen")
+	urlprefix := flag.String("urlprefix", "", "prefix for URL paths")
+	metricsPrefixFlag := flag.String("metrics-prefix", cmp.Or(os.Getenv("CFDNSUPDATER_METRICS_PREFIX"), metricsPrefix), "prefix for the names of our metrics")
+	noGoMetrics := flag.Bool("no-go-metrics", false, "don't export the Go runtime metrics")
+	noProcessMetrics := flag.Bool("no-process-metrics", false, "don't export the process metrics")
+	noPrometheus := flag.Bool("no-prometheus", false, "don't serve the Prometheus metrics endpoint, e.g. when sending metrics to StatsD instead")
+	statsdAddr := flag.String("statsd-addr", os.Getenv("CFDNSUPDATER_STATSD_ADDR"), "`host:port` of a StatsD server to send metrics to")
+	statsdFormat := flag.String("statsd-format", cmp.Or(os.Getenv("CFDNSUPDATER_STATSD_FORMAT"), "statsd"), "StatsD dialect, statsd or dogstatsd (with tags)")
+	statsdInterval := flag.Duration("statsd-interval", 10*time.Second, "how often to send metrics to StatsD")
+	statusDocURL := flag.String("status-doc-url", os.Getenv("CFDNSUPDATER_STATUS_DOC_URL"), "URL to publish a JSON status document to on change, http(s):// for PUT or kv://<account-id>/<namespace-id>/<key> for Workers KV")
+	statusDocFormat := flag.String("status-doc-format", cmp.Or(os.Getenv("CFDNSUPDATER_STATUS_DOC_FORMAT"), "signed"), "format of the status document, signed or cloudevents")
+	statusDocToken := flag.String("status-doc-token", os.Getenv("CFDNSUPDATER_STATUS_DOC_TOKEN"), "bearer token sent when publishing the status document over HTTP")
+	statusDocKey := flag.String("status-doc-signing-key", os.Getenv("CFDNSUPDATER_STATUS_DOC_SIGNING_KEY"), "path to a PEM Ed25519 private key used to sign the status document")
+	onChangeCmd := flag.String("on-change-cmd", os.Getenv("CFDNSUPDATER_ON_CHANGE_CMD"), "shell command to run after a record is created or changed, given CFDNSUPDATER_HOST, CFDNSUPDATER_OLD_IP, CFDNSUPDATER_NEW_IP and CFDNSUPDATER_ZONE in its environment")
+	onFailureCmd := flag.String("on-failure-cmd", os.Getenv("CFDNSUPDATER_ON_FAILURE_CMD"), "shell command to run after an update cycle fails, given CFDNSUPDATER_HOST, CFDNSUPDATER_ERROR and CFDNSUPDATER_FAILURES, the number of failures in a row, in its environment")
+	notifyEvents := flag.String("notify-events", cmp.Or(os.Getenv("CFDNSUPDATER_NOTIFY_EVENTS"), "change,failure,recovery,token"), "comma separated events to send notifications for: change, failure, recovery and token")
+	notifyFailureThreshold := flag.Int("notify-failure-threshold", 3, "send a failure notification after this many consecutive failed updates, and a recovery notification when they next succeed, 0 to only notify changes")
+	webhookURL := flag.String("webhook-url", os.Getenv("CFDNSUPDATER_WEBHOOK_URL"), "URL to POST a JSON notification to whenever a record is created or changed, or updates keep failing")
+	webhookFormat := flag.String("webhook-format", cmp.Or(os.Getenv("CFDNSUPDATER_WEBHOOK_FORMAT"), "json"), "format of the webhook body, json or cloudevents for a CloudEvents 1.0 structured event with the JSON document as its data")
+	webhookTemplate := flag.String("webhook-template", os.Getenv("CFDNSUPDATER_WEBHOOK_TEMPLATE"), "path to a Go text/template producing the webhook body, given .Event, .Zone, .Host, .OldIP, .NewIP, .Time, .Version, .Error, .Failures and .Summary")
+	slackURL := flag.String("slack-webhook-url", os.Getenv("CFDNSUPDATER_SLACK_WEBHOOK_URL"), "Slack incoming webhook URL to post notifications to")
+	slackChannel := flag.String("slack-channel", os.Getenv("CFDNSUPDATER_SLACK_CHANNEL"), "Slack channel to post to instead of the webhook's default, if it allows that")
+	slackTemplate := flag.String("slack-template", os.Getenv("CFDNSUPDATER_SLACK_TEMPLATE"), "path to a Go text/template producing the Slack message text, with the same fields as -webhook-template")
+	discordURL := flag.String("discord-webhook-url", os.Getenv("CFDNSUPDATER_DISCORD_WEBHOOK_URL"), "Discord webhook URL to post notifications to")
+	discordTemplate := flag.String("discord-template", os.Getenv("CFDNSUPDATER_DISCORD_TEMPLATE"), "path to a Go text/template producing the Discord message content instead of an embed, with the same fields as -webhook-template")
+	telegramToken := flag.String("telegram-bot-token", os.Getenv("CFDNSUPDATER_TELEGRAM_BOT_TOKEN"), "token of a Telegram bot to send notifications with")
+	telegramChatID := flag.String("telegram-chat-id", os.Getenv("CFDNSUPDATER_TELEGRAM_CHAT_ID"), "ID of the Telegram chat, group or channel the bot sends notifications to")
+	telegramTemplate := flag.String("telegram-template", os.Getenv("CFDNSUPDATER_TELEGRAM_TEMPLATE"), "path to a Go text/template producing the Telegram message text, with the same fields as -webhook-template")
+	telegramAPIURL := flag.String("telegram-api-url", cmp.Or(os.Getenv("CFDNSUPDATER_TELEGRAM_API_URL"), "https://api.telegram.org"), "base URL of the Telegram Bot API server")
+	ntfyURL := flag.String("ntfy-url", os.Getenv("CFDNSUPDATER_NTFY_URL"), "URL of an ntfy topic to publish notifications to, like https://ntfy.sh/<topic>")
+	ntfyToken := flag.String("ntfy-token", os.Getenv("CFDNSUPDATER_NTFY_TOKEN"), "access token for publishing to the ntfy topic")
+	ntfyPriority := flag.String("ntfy-priority", os.Getenv("CFDNSUPDATER_NTFY_PRIORITY"), "priority of ntfy notifications, 1 to 5 or min, low, default, high or max")
+	ntfyTemplate := flag.String("ntfy-template", os.Getenv("CFDNSUPDATER_NTFY_TEMPLATE"), "path to a Go text/template producing the ntfy message, with the same fields as -webhook-template")
+	pushoverToken := flag.String("pushover-token", os.Getenv("CFDNSUPDATER_PUSHOVER_TOKEN"), "Pushover application token to send notifications with")
+	pushoverUser := flag.String("pushover-user", os.Getenv("CFDNSUPDATER_PUSHOVER_USER"), "Pushover user or group key to send notifications to")
+	pushoverPriority := flag.Int("pushover-priority", 0, "priority of Pushover notifications, from -2 to 1")
+	pushoverTemplate := flag.String("pushover-template", os.Getenv("CFDNSUPDATER_PUSHOVER_TEMPLATE"), "path to a Go text/template producing the Pushover message, with the same fields as -webhook-template")
+	gotifyURL := flag.String("gotify-url", os.Getenv("CFDNSUPDATER_GOTIFY_URL"), "URL of a Gotify server to send notifications to")
+	gotifyToken := flag.String("gotify-token", os.Getenv("CFDNSUPDATER_GOTIFY_TOKEN"), "Gotify application token to send notifications with")
+	gotifyPriority := flag.Int("gotify-priority", 5, "priority of Gotify notifications")
+	gotifyTemplate := flag.String("gotify-template", os.Getenv("CFDNSUPDATER_GOTIFY_TEMPLATE"), "path to a Go text/template producing the Gotify message, with the same fields as -webhook-template")
+	showVersion := flag.Bool("version", false, "show version and exit")
+	sleepinterval := interval{Duration: 300 * time.Second}
+	sleepwarning := ""
+	retrywarning := ""
+	if s := os.Getenv("CFDNSUPDATER_SLEEP_INTERVAL"); s != "" {
+		if err := sleepinterval.Set(s); err != nil {
+			// defer warning about incorrect setting until logger is set up
+			sleepwarning = s
+		}
+	}
+	flag.Var(&sleepinterval, "sleep-interval", "period to sleep between runs, as a duration like 5m or a number of seconds (env: CFDNSUPDATER_SLEEP_INTERVAL)")
+	var retryinterval interval
+	if s := os.Getenv("CFDNSUPDATER_RETRY_INTERVAL"); s != "" {
+		if err := retryinterval.Set(s); err != nil {
+			// defer warning about incorrect setting until logger is set up
+			retrywarning = s
+		}
+	}
+	flag.Var(&retryinterval, "retry-interval", "fixed period to wait after a failed run instead of backing off exponentially (env: CFDNSUPDATER_RETRY_INTERVAL)")
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		os.Exit(flagExitCode(err))
+	}
+
+	if *showVersion {
+		fmt.Printf("cfdnsupdater %s [%s]\n", Version, Commit)
+		os.Exit(exitOK)
+	}
+
+	if *noJSON && *logFormat == "json" {
+		*logFormat = "text"
+	}
+	_, basicAuthPassword, _ := strings.Cut(*ipServiceBasicAuth, ":")
+	_, httpPassword, _ := strings.Cut(*httpBasicAuth, ":")
+	var fileToken string
+	if config.ApiTokenFile != "" {
+		// checkRecordConfig reports errors reading it
+		fileToken, _ = cfprovider.ReadTokenFile(config.ApiTokenFile)
+	}
+	redact.Secrets(config.ApiToken, fileToken, config.ApiKey, config.Email, config.HetznerToken, config.GandiToken, config.PorkbunAPIKey, config.PorkbunSecretAPIKey, config.DesecToken, config.DyndnsPassword, config.DuckDNSToken, config.RFC2136KeySecret, *httpToken, httpPassword, *oidcClientSecret, *statusDocToken, basicAuthPassword, *slackURL, *discordURL, *telegramToken, *ntfyToken, *pushoverToken, *pushoverUser, *gotifyToken)
+	logWriter, err := openLogOutput(*logOutput, *logMaxSize<<20, *logMaxAge, *logBackups)
+	if err == nil {
+		err = setupLogger(*debug, *logFormat, *logSchema, logWriter)
+	}
+	if err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		os.Exit(exitConfig)
+	}
+
+	if sleepwarning != "" {
+		slog.Warn(fmt.Sprintf("Environment setting '%s' for sleep interval is not a duration or a number of seconds, using %s", sleepwarning, sleepinterval))
+	}
+
+	if retrywarning != "" {
+		slog.Warn(fmt.Sprintf("Environment setting '%s' for retry interval is not a duration or a number of seconds, ignoring it", retrywarning))
+	}
+
+	if len(*urlprefix) > 0 && (*urlprefix)[0] != '/' {
+		slog.Error(fmt.Sprintf("URL prefix must start with a / or it won't match (got %s)", *urlprefix))
+		os.Exit(exitConfig)
+	}
+	if err := checkRecordConfig(config); err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitConfig)
+	}
+	config.Clock = clock.System{}
+	dnsProvider, err := newProvider(config)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitConfig)
+	}
+	if *metricsPrefixFlag != "" && !validMetricsPrefix.MatchString(*metricsPrefixFlag) {
+		slog.Error(fmt.Sprintf("Metrics prefix must be a valid Prometheus metric name (got %s)", *metricsPrefixFlag))
+		os.Exit(exitConfig)
+	}
+	if headerErr != nil {
+		slog.Error("Invalid CFDNSUPDATER_IP_SERVICE_HEADERS", "error", headerErr)
+		os.Exit(exitConfig)
+	}
+	if *ipServiceFormat != "text" && *ipServiceFormat != "json" {
+		slog.Error(fmt.Sprintf("IP service format must be text or json (got %s)", *ipServiceFormat))
+		os.Exit(exitConfig)
+	}
+	ipnetwork, err := ipsource.Network(config.RecordType, *ipNetworkOverride)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitConfig)
+	}
+
+	var statusPublisher *StatusPublisher
+	if *statusDocURL != "" {
+		if *statusDocFormat != "signed" && *statusDocFormat != "cloudevents" {
+			slog.Error(fmt.Sprintf("Status document format must be signed or cloudevents (got %s)", *statusDocFormat))
+			os.Exit(exitConfig)
+		}
+		if _, err := parseStatusURL(*statusDocURL); err != nil {
+			slog.Error("Invalid status document URL", "error", err)
+			os.Exit(exitConfig)
+		}
+		statusPublisher = &StatusPublisher{
+			URL:    *statusDocURL,
+			Format: *statusDocFormat,
+			Token:  *statusDocToken,
+			Client: &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)},
+		}
+		if *statusDocKey != "" {
+			key, err := loadSigningKey(*statusDocKey)
+			if err != nil {
+				slog.Error("Failed to load status document signing key", "error", err)
+				os.Exit(exitConfig)
+			}
+			statusPublisher.Key = key
+		}
+	}
+
+	config.IP.Service = *ipService
+	config.IP.Format = *ipServiceFormat
+	config.IP.Field = *ipServiceField
+	if *ipServiceBasicAuth != "" {
+		ipServiceHeaders.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(*ipServiceBasicAuth)))
+	}
+	config.IP.Headers = ipServiceHeaders
+	config.IP.TLS, err = ipsource.TLSConfig(*ipServiceCA, *ipServiceCert, *ipServiceKey, *ipServiceInsecure)
+	if err != nil {
+		slog.Error("Invalid IP service TLS settings", "error", err)
+		os.Exit(exitConfig)
+	}
+	if *ipServiceInsecure {
+		slog.Warn("TLS certificate verification is DISABLED for the IP service; anyone on the path can feed us an IP to publish")
+	}
+	config.IP.Network = ipnetwork
+	if len(ipSourceSpecs) == 0 {
+		ipSourceSpecs = []string{"http"}
+	}
+	for _, spec := range ipSourceSpecs {
+		source, err := ipsource.New(strings.TrimSpace(spec))
+		if err != nil {
+			slog.Error("Invalid IP source", "error", err)
+			os.Exit(exitConfig)
+		}
+		config.IPSources = append(config.IPSources, source)
+	}
+	config.IP.Interface = *iface
+	config.IP.BindToDevice = *bindToDevice
+	if *sourceAddress != "" {
+		config.IP.SourceAddress = net.ParseIP(*sourceAddress)
+		if config.IP.SourceAddress == nil {
+			slog.Error(fmt.Sprintf("Source address must be an IP address (got %s)", *sourceAddress))
+			os.Exit(exitConfig)
+		}
+	}
+	config.IP.Clock = config.Clock
+	if *breakerThreshold > 0 {
+		config.IPBreaker = newCircuitBreaker(*breakerThreshold, *breakerCooldown, config.Clock)
+	}
+	config.CycleTimeout = *cycleTimeout
+	config.UnchangedLogInterval = *unchangedInterval
+	config.RecordRevalidate = *recordRevalidate
+	config.ForceUpdate = *forceUpdate
+	config.MarkRecords = *markRecords
+	config.Version = Version
+	config.RespectOwner = *respectOwner
+	if strings.ContainsAny(*ownerID, `,"`) {
+		slog.Error(fmt.Sprintf("Owner ID can't contain commas or quotes (got %s)", *ownerID))
+		os.Exit(exitConfig)
+	}
+	config.OwnerID = *ownerID
+	config.RegistryPrefix = *registryPrefix
+	if *cleanupDuplicates {
+		if *multipleRecords == "update-all" {
+			slog.Error("-cleanup-duplicates can't be combined with -multiple-records update-all")
+			os.Exit(exitConfig)
+		}
+		*multipleRecords = "consolidate"
+	}
+	switch *multipleRecords {
+	case "error", "update-all", "consolidate":
+		config.MultipleRecords = *multipleRecords
+	default:
+		slog.Error(fmt.Sprintf("Multiple records policy must be error, update-all or consolidate (got %s)", *multipleRecords))
+		os.Exit(exitConfig)
+	}
+	config.MaxConsecutiveFailures = *maxFailures
+	if aliasErr != nil {
+		slog.Error("Invalid CFDNSUPDATER_ALIASES", "error", aliasErr)
+		os.Exit(exitConfig)
+	}
+	config.Aliases = aliases
+	if *observeOnly {
+		config.Canary = updater.NewCanary(*canaryGrace)
+		slog.Info("Running in observe-only mode, records will not be changed")
+	}
+	if config.IP.BindToDevice && config.IP.Interface == "" {
+		slog.Error("Binding to a device requires -interface")
+		os.Exit(exitConfig)
+	}
+	config.StatusPublisher = statusPublisher
+	config.HookCommands = newHooks(*onChangeCmd, *onFailureCmd)
+	notifyClient := &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy), Timeout: notifyTimeout}
+	var notifierList []notifier
+	if *webhookURL != "" {
+		webhook, err := newWebhookNotifier(*webhookURL, *webhookFormat, *webhookTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid webhook settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, webhook)
+	}
+	if *slackURL != "" {
+		slack, err := newSlackNotifier(*slackURL, *slackChannel, *slackTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid Slack settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, slack)
+	}
+	if *discordURL != "" {
+		discord, err := newDiscordNotifier(*discordURL, *discordTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid Discord settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, discord)
+	}
+	if *telegramToken != "" {
+		telegram, err := newTelegramNotifier(*telegramAPIURL, *telegramToken, *telegramChatID, *telegramTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid Telegram settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, telegram)
+	}
+	if *ntfyURL != "" {
+		ntfy, err := newNtfyNotifier(*ntfyURL, *ntfyToken, *ntfyPriority, *ntfyTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid ntfy settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, ntfy)
+	}
+	if *pushoverToken != "" {
+		pushover, err := newPushoverNotifier(*pushoverToken, *pushoverUser, *pushoverPriority, *pushoverTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid Pushover settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, pushover)
+	}
+	if *gotifyURL != "" {
+		gotify, err := newGotifyNotifier(*gotifyURL, *gotifyToken, *gotifyPriority, *gotifyTemplate, notifyClient)
+		if err != nil {
+			slog.Error("Invalid Gotify settings", "error", err)
+			os.Exit(exitConfig)
+		}
+		notifierList = append(notifierList, gotify)
+	}
+	if len(notifierList) > 0 {
+		events, err := parseNotifyEvents(*notifyEvents)
+		if err != nil {
+			slog.Error("Invalid -notify-events", "error", err)
+			os.Exit(exitConfig)
+		}
+		config.Notifiers = newNotifiers(notifierList, notifyConfig{events: events, failureThreshold: *notifyFailureThreshold})
+	}
+	if *auditLogPath != "" {
+		config.Audit, err = updater.OpenAuditLog(*auditLogPath)
+		if err != nil {
+			slog.Error("Failed to open audit log", "error", err)
+			os.Exit(exitConfig)
+		}
+	}
+	checkDeprecations(config, sleepinterval)
+	deprecations.log()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if badJitterEnv != "" {
+		slog.Error(fmt.Sprintf("CFDNSUPDATER_JITTER must be a percentage (got %s)", badJitterEnv))
+		os.Exit(exitConfig)
+	}
+	if jitter < 0 || jitter > 100 {
+		slog.Error(fmt.Sprintf("Jitter must be a percentage between 0 and 100 (got %d)", jitter))
+		os.Exit(exitConfig)
+	}
+	if *backoffInitial < 0 || *backoffMax <= 0 || *backoffInitial > *backoffMax {
+		slog.Error(fmt.Sprintf("Backoff max must be positive and at least backoff initial, which can't be negative (got %s and %s)", *backoffMax, *backoffInitial))
+		os.Exit(exitConfig)
+	}
+	retry := &backoff{
+		initial: *backoffInitial,
+		max:     *backoffMax,
+		retry:   retryinterval.Duration,
+		jitter:  float64(jitter) / 100,
+	}
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "otlp" {
+		exporter, err := otlp.NewExporter("traces", &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)}, Version)
+		if err != nil {
+			slog.Error("Failed to set up OTLP trace export", "error", err)
+			os.Exit(exitConfig)
+		}
+		otlp.Tracing = otlp.NewTracer(exporter)
+	}
+
+	config.Targets, err = newTargets(config, dnsProvider)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(exitConfig)
+	}
+	triggers := make(chan chan<- error)
+	loopDone := updateHostLoop(ctx, config, sleepinterval.Duration, *retryBudget, retry, triggers)
+
+	if (config.ApiToken != "" || config.ApiTokenFile != "") && *tokenCheckInterval > 0 {
+		monitorToken(ctx, config, *tokenCheckInterval, *tokenExpiryWarning)
+	}
+
+	var exportersDone []<-chan struct{}
+	if os.Getenv("OTEL_METRICS_EXPORTER") == "otlp" {
+		exporter, err := otlp.NewExporter("metrics", &http.Client{Transport: cfprovider.ProxyTransport(config.Proxy)}, Version)
+		if err != nil {
+			slog.Error("Failed to set up OTLP metrics export", "error", err)
+			os.Exit(exitConfig)
+		}
+		interval := time.Minute
+		if ms, err := strconv.Atoi(os.Getenv("OTEL_METRIC_EXPORT_INTERVAL")); err == nil && ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+		metrics := &otlpMetricsExporter{
+			Exporter: exporter,
+			gatherer: prefixedGatherer(prometheus.DefaultGatherer, *metricsPrefixFlag),
+			start:    time.Now(),
+		}
+		exportersDone = append(exportersDone, metrics.run(ctx, interval))
+	}
+
+	if *statsdAddr != "" {
+		if *statsdInterval <= 0 {
+			slog.Error(fmt.Sprintf("StatsD interval must be positive (got %s)", *statsdInterval))
+			os.Exit(exitConfig)
+		}
+		sink, err := newStatsdSink(*statsdAddr, *statsdFormat, prefixedGatherer(prometheus.DefaultGatherer, *metricsPrefixFlag))
+		if err != nil {
+			slog.Error("Failed to set up StatsD metrics", "error", err)
+			os.Exit(exitConfig)
+		}
+		exportersDone = append(exportersDone, sink.run(ctx, *statsdInterval))
+	}
+
+	mux := http.NewServeMux()
+	serverTLS, err := serverTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+	if err != nil {
+		slog.Error("Invalid HTTP server TLS settings", "error", err)
+		os.Exit(exitConfig)
+	}
+	auth := &authenticator{token: *httpToken, clientCerts: *tlsClientCA != ""}
+	if *httpBasicAuth != "" {
+		var ok bool
+		auth.user, auth.password, ok = strings.Cut(*httpBasicAuth, ":")
+		if !ok || auth.password == "" {
+			slog.Error("HTTP basic authentication must be given as user:password")
+			os.Exit(exitConfig)
+		}
+	}
+	if *oidcIssuer != "" {
+		if *oidcClientID == "" || *oidcRedirectURL == "" {
+			slog.Error("OIDC login needs -oidc-client-id and -oidc-redirect-url")
+			os.Exit(exitConfig)
+		}
+		var groups []string
+		if *oidcGroups != "" {
+			groups = strings.Split(*oidcGroups, ",")
+		}
+		auth.oidc, err = newOIDCAuth(ctx, *oidcIssuer, *oidcClientID, *oidcClientSecret, *oidcRedirectURL, *oidcGroupsClaim, groups)
+		if err != nil {
+			slog.Error("Failed to set up OIDC login", "error", err)
+			os.Exit(exitCodeFor(err))
+		}
+		mux.Handle(*urlprefix+"/oauth2/callback", auth.oidc)
+	}
+
+	murl := *urlprefix + "/metrics"
+	rurl := *urlprefix + "/ready"
+	aurl := *urlprefix + "/alive"
+
+	if !*noPrometheus {
+		mux.Handle(murl, auth.wrap(metricsHandler(*metricsPrefixFlag, !*noGoMetrics, !*noProcessMetrics)))
+	}
+	mux.Handle(rurl, readiness{maxFailures: *readyMaxFailures})
+	// a cycle can retry for up to the interval, and the last attempt can
+	// run for the cycle timeout; allow as long again before giving up
+	mux.Handle(aurl, liveness{stuckAfter: 2 * (sleepinterval.Duration + config.CycleTimeout)})
+	if config.Canary != nil {
+		mux.Handle(*urlprefix+"/canary", auth.wrap(config.Canary))
+	}
+	mux.Handle(*urlprefix+"/version", auth.wrap(http.HandlerFunc(serveVersion)))
+	mux.Handle(*urlprefix+"/status", auth.wrap(statusHandler{config: config, interval: sleepinterval.Duration}))
+	if auth.enabled() {
+		// anyone could hammer the API through it without authentication
+		mux.Handle(*urlprefix+"/trigger", auth.wrap(triggerHandler{triggers: triggers}))
+	}
+	// likewise the log level can only be changed with authentication
+	mux.Handle(*urlprefix+"/loglevel", auth.wrap(logLevelHandler{writable: auth.enabled()}))
+	var servers []*http.Server
+	if !*noHTTP {
+		servers = append(servers, newHTTPServer(*listen, mux, serverTLS))
+	}
+	if *enablePprof {
+		if *pprofListen == "" {
+			mux.Handle(*urlprefix+"/debug/pprof/", auth.wrap(pprofHandler(*urlprefix)))
+		} else {
+			slog.Info("Serving pprof profiles on " + *pprofListen)
+			pprofServer := newHTTPServer(*pprofListen, pprofHandler(""), nil)
+			// CPU profiles and traces take as long as they are asked to
+			pprofServer.WriteTimeout = 0
+			servers = append(servers, pprofServer)
+		}
+	}
+	handleLogLevelSignal(ctx)
+	if *noHTTP {
+		slog.Info(fmt.Sprintf("cfdnsupdater %s [%s] started without an HTTP server", Version, Commit))
+	} else {
+		slog.Info(fmt.Sprintf("cfdnsupdater %s [%s] listening on %s", Version, Commit, *listen))
+	}
+	serverErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			if server.TLSConfig != nil {
+				serverErr <- server.ListenAndServeTLS("", "")
+			} else {
+				serverErr <- server.ListenAndServe()
+			}
+		}()
+	}
+	exitCode := exitOK
+	select {
+	case err := <-serverErr:
+		slog.Error("Failed to start HTTP server", "error", err)
+		os.Exit(exitNetwork)
+	case err := <-loopDone:
+		slog.Error("Giving up", "error", err)
+		exitCode = exitCodeFor(err)
+	case <-ctx.Done():
+	}
+	// a second signal kills us immediately
+	stop()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down HTTP server cleanly", "error", err, "addr", server.Addr)
+		}
+	}
+	select {
+	case <-loopDone:
+	case <-shutdownCtx.Done():
+		slog.Warn("Gave up waiting for the update in progress to finish")
+	}
+	if otlp.Tracing != nil {
+		exportersDone = append(exportersDone, otlp.Tracing.Flushed())
+	}
+	exportersDone = append(exportersDone, config.Notifiers.flushed(), config.HookCommands.flushed())
+	for _, done := range exportersDone {
+		select {
+		case <-done:
+		case <-shutdownCtx.Done():
+		}
+	}
+	slog.Info(fmt.Sprintf("cfdnsupdater %s stopped", Version))
+	os.Exit(exitCode)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of the check command.
+const (
+	checkMatch  = 0
+	checkDiffer = 1
+	checkError  = 2
+)
+
+// checkCommand compares the detected IP with the record and prints one
+// line saying whether they match, exiting with checkMatch, checkDiffer or
+// checkError for monitoring systems and scripts, rather than the usual exit
+// codes.
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	finishDetect := addDetectFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return checkMatch
+		}
+		return checkError
+	}
+
+	if err := checkRecordConfig(config); err != nil {
+		fmt.Println("ERROR -", err)
+		return checkError
+	}
+	if err := finishDetect(); err != nil {
+		fmt.Println("ERROR -", err)
+		return checkError
+	}
+
+	ctx := context.Background()
+	_, records, err := hostRecords(ctx, config)
+	if err != nil {
+		fmt.Println("ERROR -", err)
+		return checkError
+	}
+	ip, err := detectIP(ctx, config)
+	if err != nil {
+		fmt.Println("ERROR - failed to detect IP:", err)
+		return checkError
+	}
+	if len(records) == 0 {
+		fmt.Printf("DIFFERENT - %s has no %s record, detected IP is %s\n", config.Host, config.RecordType, ip)
+		return checkDiffer
+	}
+	if !recordsMatch(records, ip) {
+		var contents []string
+		for _, r := range records {
+			contents = append(contents, r.Content)
+		}
+		fmt.Printf("DIFFERENT - %s points at %s, detected IP is %s\n", config.Host, strings.Join(contents, ", "), ip)
+		return checkDiffer
+	}
+	fmt.Printf("OK - %s points at %s\n", config.Host, ip)
+	return checkMatch
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	cloudEventsContentType = "application/cloudevents+json"
+	recordChangedEventType = "com.jamesmcdonald.cfdnsupdater.record.changed"
+)
+
+// cloudEventTypes are the CloudEvent types of each notification event.
+var cloudEventTypes = map[string]string{
+	notifyChange:   recordChangedEventType,
+	notifyFailure:  "com.jamesmcdonald.cfdnsupdater.update.failed",
+	notifyRecovery: "com.jamesmcdonald.cfdnsupdater.update.recovered",
+	notifyToken:    "com.jamesmcdonald.cfdnsupdater.token.warning",
+}
+
+// cloudEvent is a CloudEvents 1.0 event in the structured JSON format.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+	// Signature is an extension attribute carrying the base64 Ed25519
+	// signature of Data, if the publisher has a signing key.
+	Signature string `json:"signature,omitempty"`
+}
+
+// newCloudEvent wraps data as a CloudEvent about host.
+func newCloudEvent(eventType, host string, data json.RawMessage) (cloudEvent, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return cloudEvent{}, err
+	}
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(id),
+		Source:          "cfdnsupdater/" + host,
+		Type:            eventType,
+		Subject:         host,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+)
+
+// commands are the subcommands selected by the first program argument. Each
+// receives the remaining arguments and returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"export":      exportCommand,
+	"show":        showCommand,
+	"delete":      deleteCommand,
+	"list":        listCommand,
+	"check":       checkCommand,
+	"healthcheck": healthcheckCommand,
+	"init":        initCommand,
+	"status":      statusCommand,
+}
+
+// addDetectFlags registers the flags choosing how a subcommand detects the
+// current IP, a subset of the daemon's. The returned function completes
+// config from them once fs has been parsed.
+func addDetectFlags(fs *flag.FlagSet, config *CFUpdateConfig) func() error {
+	fs.StringVar(&config.IP.Service, "ip-service", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE"), ipsource.DefaultService), "The URL of a service which returns our current IP")
+	fs.StringVar(&config.IP.Format, "ip-service-format", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE_FORMAT"), "text"), "format of the IP service response, text or json")
+	fs.StringVar(&config.IP.Field, "ip-service-field", cmp.Or(os.Getenv("CFDNSUPDATER_IP_SERVICE_FIELD"), "ip"), "dotted path to the address in a json IP service response, e.g. ip or data.address")
+	var specs []string
+	if env := os.Getenv("CFDNSUPDATER_IP_SOURCES"); env != "" {
+		specs = strings.Split(env, ",")
+	}
+	fs.Func("ip-source", "`source` of the current IP in priority order: http (the -ip-service URL), an http(s) URL, stun:host[:port], upnp, dns[:name@server], interface:name, exec:command or plugin:command; may be repeated (env: CFDNSUPDATER_IP_SOURCES, comma separated)", func(spec string) error {
+		specs = append(specs, spec)
+		return nil
+	})
+	return func() error {
+		if config.IP.Format != "text" && config.IP.Format != "json" {
+			return fmt.Errorf("IP service format must be text or json (got %s)", config.IP.Format)
+		}
+		network, err := ipsource.Network(config.RecordType, "")
+		if err != nil {
+			return err
+		}
+		config.IP.Network = network
+		if len(specs) == 0 {
+			specs = []string{"http"}
+		}
+		for _, spec := range specs {
+			source, err := ipsource.New(strings.TrimSpace(spec))
+			if err != nil {
+				return err
+			}
+			config.IPSources = append(config.IPSources, source)
+		}
+		return nil
+	}
+}
+
+// cloudflareAPI returns a Cloudflare API client, for the features that only
+// work with Cloudflare.
+func cloudflareAPI(config CFUpdateConfig) (*cloudflare.API, error) {
+	if config.ProviderName != "cloudflare" {
+		return nil, fmt.Errorf("this needs the cloudflare provider, not %s", config.ProviderName)
+	}
+	return cfprovider.NewAPI(config.Account)
+}
+
+// hostRecords looks up the zone and the host's records of the configured
+// type, returning the zone ID and the records.
+func hostRecords(ctx context.Context, config CFUpdateConfig) (string, []cloudflare.DNSRecord, error) {
+	api, err := cloudflareAPI(config)
+	if err != nil {
+		return "", nil, err
+	}
+	zoneID, err := cfprovider.ResolveZoneID(ctx, api, config.Account)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to look up zone: %w", err)
+	}
+	records, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+		Name: config.Host,
+		Type: config.RecordType,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list records: %w", err)
+	}
+	return zoneID, records, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+)
+
+// newConsoleHandler returns a handler for reading logs as they happen: the
+// time, a short level, the message and the attributes, coloured if w is a
+// terminal and NO_COLOR isn't set.
+func newConsoleHandler(w io.Writer, opts slog.HandlerOptions) slog.Handler {
+	color := os.Getenv("NO_COLOR") == "" && isTerminal(w)
+	var mu sync.Mutex
+	emit := func(r slog.Record, fields []logField) error {
+		var b bytes.Buffer
+		paint := func(code, s string) {
+			if color {
+				b.WriteString(code + s + ansiReset)
+			} else {
+				b.WriteString(s)
+			}
+		}
+		paint(ansiDim, r.Time.Format("15:04:05.000"))
+		b.WriteByte(' ')
+		level, code := consoleLevel(r.Level)
+		paint(code, level)
+		b.WriteByte(' ')
+		if r.Level >= slog.LevelWarn {
+			paint(ansiBold, r.Message)
+		} else {
+			b.WriteString(r.Message)
+		}
+		for _, f := range fields {
+			b.WriteByte(' ')
+			paint(ansiDim, f.key+"=")
+			b.WriteString(consoleValue(f.value))
+		}
+		b.WriteByte('\n')
+		mu.Lock()
+		defer mu.Unlock()
+		_, err := w.Write(b.Bytes())
+		return err
+	}
+	return &fieldHandler{opts: opts, emit: emit}
+}
+
+func consoleLevel(level slog.Level) (string, string) {
+	switch {
+	case level >= slog.LevelError:
+		return "ERR", ansiBold + ansiRed
+	case level >= slog.LevelWarn:
+		return "WRN", ansiBold + ansiYellow
+	case level >= slog.LevelInfo:
+		return "INF", ansiGreen
+	default:
+		return "DBG", ansiBlue
+	}
+}
+
+// consoleValue quotes a value if it would otherwise be ambiguous.
+func consoleValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\\\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// isTerminal reports whether w is a terminal rather than a file or pipe.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
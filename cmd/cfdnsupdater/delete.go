@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// deleteCommand removes the managed record, for decommissioning a host,
+// along with its ownership TXT record. Without -yes it only lists what it
+// would delete.
+func deleteCommand(args []string) int {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	yes := fs.Bool("yes", false, "really delete the records, rather than only listing them")
+	fs.BoolVar(&config.RespectOwner, "respect-record-owner", os.Getenv("CFDNSUPDATER_RESPECT_RECORD_OWNER") != "", "refuse to delete records whose comment says another tool manages them")
+	fs.StringVar(&config.OwnerID, "owner-id", os.Getenv("CFDNSUPDATER_OWNER_ID"), "the owner ID the host was updated with, refusing to delete it if its TXT ownership record names another")
+	fs.StringVar(&config.RegistryPrefix, "registry-prefix", cmp.Or(os.Getenv("CFDNSUPDATER_REGISTRY_PREFIX"), "cfdnsupdater-owner."), "prefix added to the host name to name the TXT ownership record")
+	force := fs.Bool("force", false, "delete the host even if its TXT ownership record names another owner")
+	auditLogPath := fs.String("audit-log", os.Getenv("CFDNSUPDATER_AUDIT_LOG"), "path of a file to append a JSON line to for every record deleted or attempted")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	if err := checkRecordConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *auditLogPath != "" {
+		var err error
+		config.Audit, err = updater.OpenAuditLog(*auditLogPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open audit log:", err)
+			return exitConfig
+		}
+	}
+
+	api, err := cloudflareAPI(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	ctx := context.Background()
+	zoneID, err := cfprovider.ResolveZoneID(ctx, api, config.Account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to look up zone:", err)
+		return exitCodeFor(err)
+	}
+	zone := cloudflare.ZoneIdentifier(zoneID)
+	records, _, err := api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{
+		Name: config.Host,
+		Type: config.RecordType,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list records:", err)
+		return exitCodeFor(err)
+	}
+	for _, r := range records {
+		if err := updater.CheckOwner(config.Config, provider.Record{ID: r.ID, Comment: r.Comment}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+	registryName := updater.RegistryName(config.Config)
+	txts, _, err := api.ListDNSRecords(ctx, zone, cloudflare.ListDNSRecordsParams{
+		Name: registryName,
+		Type: "TXT",
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list ownership records:", err)
+		return exitCodeFor(err)
+	}
+	for _, r := range txts {
+		owner, ok := updater.RegistryOwner(r.Content)
+		if !ok {
+			continue
+		}
+		if owner != config.OwnerID && !*force {
+			fmt.Fprintf(os.Stderr, "%s is owned by %s according to %s, not deleting it without -force\n", config.Host, owner, registryName)
+			return exitFailure
+		}
+		records = append(records, r)
+	}
+	if len(records) == 0 {
+		fmt.Printf("No %s record for %s\n", config.RecordType, config.Host)
+		return exitOK
+	}
+	if !*yes {
+		for _, r := range records {
+			fmt.Printf("Would delete %s %s %s (%s)\n", r.Name, r.Type, r.Content, r.ID)
+		}
+		fmt.Println("Run again with -yes to delete")
+		return exitFailure
+	}
+	status := exitOK
+	for _, r := range records {
+		entry := updater.AuditEntry{Action: "delete", Zone: config.Zone, Host: r.Name, Type: r.Type, RecordID: r.ID, OldIP: r.Content}
+		if r.Name == registryName {
+			// keep the claim while any of the host's records are left
+			if status != exitOK {
+				fmt.Fprintf(os.Stderr, "Not deleting %s %s %s (%s) since other records remain\n", r.Name, r.Type, r.Content, r.ID)
+				continue
+			}
+			entry.OldIP, entry.Content = "", r.Content
+		}
+		err := api.DeleteDNSRecord(ctx, zone, r.ID)
+		config.Audit.Record(entry, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete %s %s %s (%s): %v\n", r.Name, r.Type, r.Content, r.ID, err)
+			status = exitCodeFor(err)
+			continue
+		}
+		fmt.Printf("Deleted %s %s %s (%s)\n", r.Name, r.Type, r.Content, r.ID)
+	}
+	return status
+}
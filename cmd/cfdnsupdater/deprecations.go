@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// deprecationLogInterval is how often each deprecation notice is repeated
+// in the log while the process runs.
+const deprecationLogInterval = 24 * time.Hour
+
+var deprecatedSettings = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cfdnsupdater_deprecated_settings",
+	Help: "Deprecated settings in use, by deprecation ID",
+}, []string{"id"})
+
+// Deprecation describes a deprecated setting in use and exactly what to
+// configure instead.
+type Deprecation struct {
+	ID          string `json:"id"`
+	Message     string `json:"message"`
+	Replacement string `json:"replacement"`
+}
+
+// deprecationWarnings collects the deprecations that apply to this run and
+// logs them, repeating each at most once per interval.
+type deprecationWarnings struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	notices []Deprecation
+	logged  map[string]time.Time
+}
+
+var deprecations = &deprecationWarnings{
+	interval: deprecationLogInterval,
+	logged:   map[string]time.Time{},
+}
+
+// add registers a deprecation; adding the same ID twice has no effect.
+func (d *deprecationWarnings) add(notice Deprecation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, n := range d.notices {
+		if n.ID == notice.ID {
+			return
+		}
+	}
+	d.notices = append(d.notices, notice)
+	deprecatedSettings.WithLabelValues(notice.ID).Set(1)
+}
+
+// log emits every notice that hasn't been logged within the interval.
+func (d *deprecationWarnings) log() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for _, n := range d.notices {
+		if last, ok := d.logged[n.ID]; ok && now.Sub(last) < d.interval {
+			continue
+		}
+		d.logged[n.ID] = now
+		slog.Warn(n.Message,
+			"deprecation.id", n.ID,
+			"deprecation.replacement", n.Replacement,
+			"event.action", "deprecation_warning",
+		)
+	}
+}
+
+// List returns the deprecations that apply.
+func (d *deprecationWarnings) List() []Deprecation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Deprecation(nil), d.notices...)
+}
+
+// checkDeprecations registers notices for deprecated parts of config.
+func checkDeprecations(config CFUpdateConfig, sleep interval) {
+	if config.ApiToken == "" && config.ApiKey != "" {
+		deprecations.add(Deprecation{
+			ID:          "global-api-key",
+			Message:     "The global API key gives full account access; use a scoped API token instead",
+			Replacement: "create a token with Zone:DNS:Edit on " + config.Zone + ", set -api-token or CLOUDFLARE_API_TOKEN to it, and remove -email/-api-key (CLOUDFLARE_EMAIL/CLOUDFLARE_API_KEY)",
+		})
+	}
+	if sleep.seconds {
+		deprecations.add(Deprecation{
+			ID:          "sleep-interval-seconds",
+			Message:     "A sleep interval in bare seconds is deprecated; use a duration",
+			Replacement: "-sleep-interval " + sleep.String() + " (CFDNSUPDATER_SLEEP_INTERVAL=" + sleep.String() + ")",
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// discordNotifier posts to a Discord webhook: an embed with the host and
+// IPs, or the output of a template as plain content.
+type discordNotifier struct {
+	url      string
+	template *template.Template
+	client   *http.Client
+}
+
+type (
+	discordField struct {
+		Name   string `json:"name"`
+		Value  string `json:"value"`
+		Inline bool   `json:"inline,omitempty"`
+	}
+	discordFooter struct {
+		Text string `json:"text"`
+	}
+	discordEmbed struct {
+		Title     string         `json:"title"`
+		Color     int            `json:"color"`
+		Timestamp string         `json:"timestamp"`
+		Fields    []discordField `json:"fields,omitempty"`
+		Footer    discordFooter  `json:"footer"`
+	}
+	discordMessage struct {
+		Username string         `json:"username"`
+		Content  string         `json:"content,omitempty"`
+		Embeds   []discordEmbed `json:"embeds,omitempty"`
+	}
+)
+
+// discordColors are the embed colours per event: blue, red, green and
+// orange.
+var discordColors = map[string]int{
+	notifyChange:   0x3498db,
+	notifyFailure:  0xe74c3c,
+	notifyRecovery: 0x2ecc71,
+	notifyToken:    0xe67e22,
+}
+
+func newDiscordNotifier(webhookURL, templateFile string, client *http.Client) (*discordNotifier, error) {
+	if err := checkNotifyURL(webhookURL); err != nil {
+		return nil, fmt.Errorf("Discord webhook %w", err)
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &discordNotifier{url: webhookURL, template: t, client: client}, nil
+}
+
+func (d *discordNotifier) name() string {
+	return "discord"
+}
+
+func (d *discordNotifier) notify(ctx context.Context, n notification) error {
+	msg := discordMessage{Username: "cfdnsupdater"}
+	if d.template != nil {
+		text, err := renderMessage(d.template, n)
+		if err != nil {
+			return err
+		}
+		msg.Content = text
+	} else {
+		msg.Embeds = []discordEmbed{discordEmbedFor(n)}
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, d.client, d.url, "application/json", body, nil)
+}
+
+func discordEmbedFor(n notification) discordEmbed {
+	e := discordEmbed{
+		Title:     n.summary(),
+		Color:     discordColors[n.Event],
+		Timestamp: n.Time.Format(time.RFC3339),
+		Fields:    []discordField{{Name: "Host", Value: n.Host, Inline: true}},
+		Footer:    discordFooter{Text: "cfdnsupdater " + n.Version},
+	}
+	switch n.Event {
+	case notifyChange:
+		if n.OldIP != "" {
+			e.Fields = append(e.Fields, discordField{Name: "Old IP", Value: n.OldIP, Inline: true})
+		}
+		e.Fields = append(e.Fields, discordField{Name: "New IP", Value: n.NewIP, Inline: true})
+	case notifyFailure:
+		e.Title = "Updating " + n.Host + " keeps failing"
+		e.Fields = append(e.Fields,
+			discordField{Name: "Failures", Value: strconv.Itoa(n.Failures), Inline: true},
+			discordField{Name: "Error", Value: n.Error},
+		)
+	}
+	return e
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// disableEcho is only supported on Linux; elsewhere secrets are echoed as
+// they are typed.
+func disableEcho(f *os.File) (func(), error) {
+	return nil, errors.New("turning off terminal echo is only supported on Linux")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"runtime/debug"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// Exit codes, so wrapper scripts and systemd OnFailure= units can tell why
+// we stopped. exitPanic matches what the Go runtime exits with after a
+// panic nothing recovered from.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitPanic   = 2
+	// exitConfig is for invalid flags, environment variables or files
+	// they name
+	exitConfig = 3
+	// exitAuth is for credentials Cloudflare rejected
+	exitAuth = 4
+	// exitNetwork is for services we couldn't reach and addresses we
+	// couldn't listen on
+	exitNetwork = 5
+)
+
+// exitCodeFor returns the exit code for giving up because of err.
+func exitCodeFor(err error) int {
+	switch updater.ErrorClass(err) {
+	case "auth":
+		return exitAuth
+	case "network", "timeout":
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// flagExitCode returns the exit code for an error parsing flags, which the
+// flag package has already reported.
+func flagExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitConfig
+}
+
+// exitOnPanic, deferred, logs a panic and exits with exitPanic.
+func exitOnPanic() {
+	if r := recover(); r != nil {
+		slog.Error("Panic", "panic", r, "stack", string(debug.Stack()))
+		os.Exit(exitPanic)
+	}
+}
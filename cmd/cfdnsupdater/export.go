@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+)
+
+// exportTemplates are the built-in output formats for the export command.
+var exportTemplates = map[string]string{
+	"zonefile": `; Records managed by cfdnsupdater {{.Version}} in zone {{.Zone}}
+{{range .Records}}{{fqdn .Name}}	{{ttl .TTL}}	IN	{{.Type}}	{{.Content}}
+{{end}}`,
+}
+
+type exportData struct {
+	Zone    string
+	Version string
+	Records []cloudflare.DNSRecord
+}
+
+var exportFuncs = template.FuncMap{
+	"fqdn": func(name string) string {
+		return strings.TrimSuffix(name, ".") + "."
+	},
+	// Cloudflare uses a TTL of 1 to mean "automatic", which is 300 seconds
+	// for unproxied records.
+	"ttl": func(ttl int) int {
+		if ttl == 1 {
+			return 300
+		}
+		return ttl
+	},
+}
+
+// exportCommand writes the managed records to stdout in a format suitable
+// for other DNS tooling.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	format := fs.String("format", "zonefile", "output format, one of: zonefile")
+	templateFile := fs.String("template", "", "path to a text/template file to use instead of a built-in format")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	if err := checkRecordConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	text, ok := exportTemplates[*format]
+	if *templateFile != "" {
+		b, err := os.ReadFile(*templateFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to read template:", err)
+			return exitConfig
+		}
+		text, ok = string(b), true
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown export format %s\n", *format)
+		return exitConfig
+	}
+	tmpl, err := template.New("export").Funcs(exportFuncs).Parse(text)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load template:", err)
+		return exitConfig
+	}
+
+	api, err := cloudflareAPI(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	ctx := context.Background()
+	zoneID, err := cfprovider.ResolveZoneID(ctx, api, config.Account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to look up zone:", err)
+		return exitCodeFor(err)
+	}
+	records, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+		Name: config.Host,
+		Type: config.RecordType,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list records:", err)
+		return exitCodeFor(err)
+	}
+
+	err = tmpl.Execute(os.Stdout, exportData{
+		Zone:    config.Zone,
+		Version: Version,
+		Records: records,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to render export:", err)
+		return exitFailure
+	}
+	return exitOK
+}
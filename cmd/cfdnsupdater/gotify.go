@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// gotifyNotifier sends messages to a Gotify server as an application.
+type gotifyNotifier struct {
+	// url is the server's message endpoint
+	url      string
+	token    string
+	priority int
+	template *template.Template
+	client   *http.Client
+}
+
+type gotifyMessage struct {
+	Title    string `json:"title"`
+	Message  string `json:"message"`
+	Priority int    `json:"priority"`
+}
+
+// newGotifyNotifier returns a notifier for the Gotify server at serverURL,
+// sending with an application token.
+func newGotifyNotifier(serverURL, token string, priority int, templateFile string, client *http.Client) (*gotifyNotifier, error) {
+	if err := checkNotifyURL(serverURL); err != nil {
+		return nil, fmt.Errorf("Gotify server %w", err)
+	}
+	if token == "" {
+		return nil, errors.New("an application token is needed to send Gotify messages")
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &gotifyNotifier{
+		url:      strings.TrimSuffix(serverURL, "/") + "/message",
+		token:    token,
+		priority: priority,
+		template: t,
+		client:   client,
+	}, nil
+}
+
+func (g *gotifyNotifier) name() string {
+	return "gotify"
+}
+
+func (g *gotifyNotifier) notify(ctx context.Context, n notification) error {
+	text, err := renderMessage(g.template, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(gotifyMessage{Title: "cfdnsupdater: " + n.Host, Message: text, Priority: g.priority})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Gotify-Key", g.token)
+	return postNotification(ctx, g.client, g.url, "application/json", body, header)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+const (
+	// hookTimeout bounds how long a hook command may run before it is
+	// killed.
+	hookTimeout = time.Minute
+	// hookQueueSize is how many commands may wait for a slow one to
+	// finish before more are dropped.
+	hookQueueSize = 32
+)
+
+var hookFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_hook_failures_total",
+	Help: "The number of hook commands that failed, by event",
+}, []string{"event"})
+
+// hooks runs user commands through the shell when a record changes and
+// when an update cycle fails, with the details in CFDNSUPDATER_*
+// environment variables. Commands run in the background one at a time, in
+// order. A nil hooks runs nothing.
+type hooks struct {
+	onChange  string
+	onFailure string
+
+	queue   chan hookRun
+	pending sync.WaitGroup
+}
+
+// hookRun is a command waiting to run with its environment.
+type hookRun struct {
+	command string
+	env     []string
+	n       notification
+}
+
+func newHooks(onChange, onFailure string) *hooks {
+	if onChange == "" && onFailure == "" {
+		return nil
+	}
+	h := &hooks{onChange: onChange, onFailure: onFailure, queue: make(chan hookRun, hookQueueSize)}
+	go func() {
+		for r := range h.queue {
+			r.run()
+			h.pending.Done()
+		}
+	}()
+	return h
+}
+
+// changed runs the change command for a created or changed record.
+func (h *hooks) changed(c updater.Change) {
+	if h == nil || h.onChange == "" {
+		return
+	}
+	h.run(h.onChange, notification{Event: notifyChange, Zone: c.Zone, Host: c.Host, OldIP: c.OldIP, NewIP: c.NewIP})
+}
+
+// cycleFailed runs the failure command after a failed update cycle.
+func (h *hooks) cycleFailed(config CFUpdateConfig, err error, failures int) {
+	if h == nil || h.onFailure == "" {
+		return
+	}
+	h.run(h.onFailure, notification{Event: notifyFailure, Zone: config.Zone, Host: config.Host, Error: err.Error(), Failures: failures})
+}
+
+func (h *hooks) run(command string, n notification) {
+	env := append(os.Environ(),
+		"CFDNSUPDATER_EVENT="+n.Event,
+		"CFDNSUPDATER_ZONE="+n.Zone,
+		"CFDNSUPDATER_HOST="+n.Host,
+		"CFDNSUPDATER_OLD_IP="+n.OldIP,
+		"CFDNSUPDATER_NEW_IP="+n.NewIP,
+		"CFDNSUPDATER_ERROR="+n.Error,
+		"CFDNSUPDATER_FAILURES="+strconv.Itoa(n.Failures),
+	)
+	h.pending.Add(1)
+	select {
+	case h.queue <- hookRun{command: command, env: env, n: n}:
+	default:
+		h.pending.Done()
+		hookFailures.WithLabelValues(n.Event).Inc()
+		slog.Warn("Too many hook commands waiting, dropping one", "event", n.Event, "fqdn", n.Host, "command", command)
+	}
+}
+
+func (r hookRun) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := shellCommand(ctx, r.command)
+	cmd.Env = r.env
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if err != nil {
+		hookFailures.WithLabelValues(r.n.Event).Inc()
+		slog.Error("Hook command failed", "event", r.n.Event, "fqdn", r.n.Host, "command", r.command, "error", err, "output", output)
+		return
+	}
+	slog.Debug("Ran hook command", "event", r.n.Event, "fqdn", r.n.Host, "command", r.command, "duration", time.Since(start), "output", output)
+}
+
+// shellCommand runs command with the system shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
+
+// flushed returns a channel that is closed once running commands finish.
+func (h *hooks) flushed() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		if h != nil {
+			h.pending.Wait()
+		}
+		close(done)
+	}()
+	return done
+}
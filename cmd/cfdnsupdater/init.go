@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+)
+
+// initCommand asks for the settings needed to run the daemon, checks them
+// against the Cloudflare API, and writes them to an environment file for
+// systemd's EnvironmentFile= or docker run --env-file, with the API token
+// in a separate file that it refers to. Settings given as flags or in the
+// environment are offered as defaults.
+func initCommand(args []string) int {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	output := fs.String("output", "cfdnsupdater.env", "path of the environment file to write")
+	tokenOutput := fs.String("token-output", "cloudflare-api-token", "path of the file to write the API token to")
+	force := fs.Bool("force", false, "overwrite existing files")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	for _, path := range []string{*output, *tokenOutput} {
+		if _, err := os.Stat(path); err == nil && !*force {
+			fmt.Fprintf(os.Stderr, "%s already exists, use -force to overwrite it\n", path)
+			return exitConfig
+		}
+	}
+	if config.ApiToken == "" && config.ApiTokenFile != "" {
+		// offer the token we already have
+		config.ApiToken, _ = cfprovider.ReadTokenFile(config.ApiTokenFile)
+	}
+	config.ApiTokenFile = ""
+
+	in := bufio.NewReader(os.Stdin)
+	var err error
+	ask := func(prompt, current string) string {
+		if err != nil {
+			return ""
+		}
+		var answer string
+		answer, err = promptLine(in, prompt, current)
+		return answer
+	}
+	config.Zone = ask("Zone name, e.g. example.com", config.Zone)
+	config.Host = ask("Host name to keep updated, e.g. home.example.com", config.Host)
+	config.RecordType = strings.ToUpper(ask("Record type, A or AAAA", config.RecordType))
+	sleep := ask("Update interval", cmp.Or(os.Getenv("CFDNSUPDATER_SLEEP_INTERVAL"), "5m"))
+	if err == nil {
+		config.ApiToken, err = promptSecret(in, "Cloudflare API token with Zone:DNS:Edit permission", config.ApiToken)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nFailed to read answer:", err)
+		return exitFailure
+	}
+	var sleepInterval interval
+	if err := sleepInterval.Set(sleep); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid update interval:", err)
+		return exitConfig
+	}
+	if err := checkRecordConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	fmt.Println("Checking the settings with Cloudflare...")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	zoneID, err := verifySetup(ctx, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeFor(err)
+	}
+
+	tokenPath, err := filepath.Abs(*tokenOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	if err := os.WriteFile(tokenPath, []byte(config.ApiToken+"\n"), 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write API token:", err)
+		return exitFailure
+	}
+	// WriteFile doesn't change the mode of an existing file
+	if err := os.Chmod(tokenPath, 0o600); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to restrict access to the API token:", err)
+		return exitFailure
+	}
+	var env strings.Builder
+	fmt.Fprintf(&env, "# Written by cfdnsupdater init %s on %s\n", Version, time.Now().Format(time.DateOnly))
+	fmt.Fprintf(&env, "# Use with systemd's EnvironmentFile= or docker run --env-file.\n")
+	fmt.Fprintf(&env, "CFDNSUPDATER_ZONE=%s\n", config.Zone)
+	fmt.Fprintf(&env, "CFDNSUPDATER_ZONE_ID=%s\n", zoneID)
+	fmt.Fprintf(&env, "CFDNSUPDATER_HOST=%s\n", config.Host)
+	fmt.Fprintf(&env, "CFDNSUPDATER_RECORD_TYPE=%s\n", config.RecordType)
+	fmt.Fprintf(&env, "CFDNSUPDATER_SLEEP_INTERVAL=%s\n", sleepInterval.Duration)
+	fmt.Fprintf(&env, "CLOUDFLARE_API_TOKEN_FILE=%s\n", tokenPath)
+	if config.APIBaseURL != "" {
+		fmt.Fprintf(&env, "CFDNSUPDATER_CF_API_BASE_URL=%s\n", config.APIBaseURL)
+	}
+	if err := os.WriteFile(*output, []byte(env.String()), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to write settings:", err)
+		return exitFailure
+	}
+	fmt.Printf("Wrote %s and %s\n", *output, tokenPath)
+	return exitOK
+}
+
+// verifySetup checks the token is active and can see the zone and its
+// records, returning the zone's ID.
+func verifySetup(ctx context.Context, config CFUpdateConfig) (string, error) {
+	api, err := cloudflareAPI(config)
+	if err != nil {
+		return "", err
+	}
+	token, err := api.VerifyAPIToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to verify API token: %w", err)
+	}
+	if token.Status != "active" {
+		return "", fmt.Errorf("API token is %s, not active", token.Status)
+	}
+	zoneID, records, err := hostRecords(ctx, config)
+	if err != nil {
+		return "", fmt.Errorf("The token can't read the records of %s: %w", config.Zone, err)
+	}
+	if len(records) == 0 {
+		fmt.Printf("Token OK, %s has no %s record yet, the first update will create it\n", config.Host, config.RecordType)
+	} else {
+		fmt.Printf("Token OK, %s currently points at %s\n", config.Host, records[0].Content)
+	}
+	return zoneID, nil
+}
+
+// promptLine asks for a line of input, returning current if the answer is
+// empty. It keeps asking while the answer is empty and there is no
+// current value.
+func promptLine(in *bufio.Reader, prompt, current string) (string, error) {
+	for {
+		if current != "" {
+			fmt.Printf("%s [%s]: ", prompt, current)
+		} else {
+			fmt.Printf("%s: ", prompt)
+		}
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && (answer == "" || !errors.Is(err, io.EOF)) {
+			return "", err
+		}
+		if answer == "" {
+			answer = current
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// promptSecret is promptLine without echoing the answer, when reading from
+// a terminal that allows that, and without showing the current value.
+func promptSecret(in *bufio.Reader, prompt, current string) (string, error) {
+	if current != "" {
+		prompt += " [keep current]"
+	}
+	if isTerminal(os.Stdin) {
+		if restore, err := disableEcho(os.Stdin); err == nil {
+			defer fmt.Println()
+			defer restore()
+		}
+	}
+	for {
+		fmt.Printf("%s: ", prompt)
+		answer, err := in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && (answer == "" || !errors.Is(err, io.EOF)) {
+			return "", err
+		}
+		if answer == "" {
+			answer = current
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+)
+
+// listCommand prints the records in the zone, optionally only those with a
+// name or type, and points out names with several records of a type.
+func listCommand(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	name := fs.String("name", "", "only list records with this FQDN")
+	recordType := fs.String("type", "", "only list records of this type, e.g. A or TXT")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	if err := checkZoneConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	api, err := cloudflareAPI(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	ctx := context.Background()
+	zoneID, err := cfprovider.ResolveZoneID(ctx, api, config.Account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to look up zone:", err)
+		return exitCodeFor(err)
+	}
+	records, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
+		Name: *name,
+		Type: strings.ToUpper(*recordType),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to list records:", err)
+		return exitCodeFor(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tCONTENT\tTTL\tPROXIED\tID")
+	// address records sharing a name and type, which the daemon would
+	// have to consolidate
+	type nameType struct{ name, typ string }
+	counts := map[nameType]int{}
+	var duplicates []nameType
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Type, r.Content, showTTL(r.TTL), yesNo(r.Proxied != nil && *r.Proxied), r.ID)
+		if _, err := ipsource.Network(r.Type, ""); err != nil {
+			continue
+		}
+		key := nameType{r.Name, r.Type}
+		if counts[key]++; counts[key] == 2 {
+			duplicates = append(duplicates, key)
+		}
+	}
+	w.Flush()
+	for _, key := range duplicates {
+		fmt.Printf("%s has %d %s records\n", key.name, counts[key], key.typ)
+	}
+	return exitOK
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// localDaemon is how subcommands reach the HTTP endpoints of a daemon
+// running on this machine.
+type localDaemon struct {
+	listen    string
+	urlprefix string
+	useTLS    bool
+	token     string
+	basicAuth string
+	timeout   time.Duration
+}
+
+// addLocalDaemonFlags registers the flags locating the local daemon, which
+// mirror its own.
+func addLocalDaemonFlags(fs *flag.FlagSet) *localDaemon {
+	d := &localDaemon{}
+	fs.StringVar(&d.listen, "listen", ":9876", "the daemon's -listen address")
+	fs.StringVar(&d.urlprefix, "urlprefix", "", "the daemon's -urlprefix")
+	fs.BoolVar(&d.useTLS, "tls", os.Getenv("CFDNSUPDATER_TLS_CERT") != "", "connect with HTTPS, for a daemon run with -tls-cert; the certificate isn't verified")
+	fs.StringVar(&d.token, "http-token", os.Getenv("CFDNSUPDATER_HTTP_TOKEN"), "the daemon's -http-token, for endpoints needing authentication")
+	fs.StringVar(&d.basicAuth, "http-basic-auth", os.Getenv("CFDNSUPDATER_HTTP_BASIC_AUTH"), "the daemon's -http-basic-auth user:password, instead of -http-token")
+	fs.DurationVar(&d.timeout, "timeout", 5*time.Second, "how long to wait for an answer")
+	return d
+}
+
+// get requests the endpoint at path under the URL prefix.
+func (d *localDaemon) get(ctx context.Context, path string) (*http.Response, error) {
+	host, port, err := net.SplitHostPort(d.listen)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address: %w", err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	client := &http.Client{Timeout: d.timeout}
+	if d.useTLS {
+		scheme = "https"
+		// we're talking to ourselves, and the certificate is for our
+		// public name
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s%s", scheme, net.JoinHostPort(host, port), d.urlprefix, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("cfdnsupdater/%s", Version))
+	switch {
+	case d.token != "":
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	case d.basicAuth != "":
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(d.basicAuth)))
+	}
+	return client.Do(req)
+}
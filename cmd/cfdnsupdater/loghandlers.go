@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log records get the attributes
+// args, given as for slog.Logger.With, when logged with the *Context
+// functions.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(logAttrsKey{}).([]any)
+	return context.WithValue(ctx, logAttrsKey{}, append(slices.Clip(attrs), args...))
+}
+
+// contextHandler adds the attributes set with withLogAttrs to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]any); ok {
+		r = r.Clone()
+		r.Add(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// logField is an attribute flattened to a dotted key and a string value.
+type logField struct {
+	key, value string
+}
+
+// fieldHandler is a slog.Handler for the console, syslog and journald
+// formats, which have no nesting. It flattens each record's attributes,
+// including groups, to dotted keys and passes them to emit.
+type fieldHandler struct {
+	opts   slog.HandlerOptions
+	emit   func(r slog.Record, fields []logField) error
+	fields []logField
+	groups []string
+}
+
+func (h *fieldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	minimum := slog.LevelInfo
+	if h.opts.Level != nil {
+		minimum = h.opts.Level.Level()
+	}
+	return level >= minimum
+}
+
+func (h *fieldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.fields = slices.Clip(h.fields)
+	for _, a := range attrs {
+		h2.fields = h2.flatten(h2.fields, h.groups, a)
+	}
+	return &h2
+}
+
+func (h *fieldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(slices.Clip(h.groups), name)
+	return &h2
+}
+
+func (h *fieldHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := slices.Clip(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = h.flatten(fields, h.groups, a)
+		return true
+	})
+	if h.opts.ReplaceAttr != nil {
+		r.Message = h.opts.ReplaceAttr(nil, slog.String(slog.MessageKey, r.Message)).Value.String()
+	}
+	return h.emit(r, fields)
+}
+
+func (h *fieldHandler) flatten(fields []logField, groups []string, a slog.Attr) []logField {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			groups = append(slices.Clip(groups), a.Key)
+		}
+		for _, ga := range a.Value.Group() {
+			fields = h.flatten(fields, groups, ga)
+		}
+		return fields
+	}
+	if h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	value := a.Value.String()
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	}
+	return append(fields, logField{key: strings.Join(append(slices.Clip(groups), a.Key), "."), value: value})
+}
+
+// logSeverity maps a slog level to a syslog severity, which journald also
+// uses for PRIORITY.
+func logSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// datagramLog is a connection to a local logging socket, redialled if the
+// daemon restarts.
+type datagramLog struct {
+	paths []string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func dialDatagramLog(paths ...string) (*datagramLog, error) {
+	d := &datagramLog{paths: paths}
+	if err := d.dial(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *datagramLog) dial() error {
+	var errs []error
+	for _, path := range d.paths {
+		conn, err := net.Dial("unixgram", path)
+		if err == nil {
+			d.conn = conn
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (d *datagramLog) write(b []byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		if _, err := d.conn.Write(b); err == nil {
+			return nil
+		}
+		d.conn.Close()
+		d.conn = nil
+	}
+	if err := d.dial(); err != nil {
+		return err
+	}
+	_, err := d.conn.Write(b)
+	return err
+}
+
+// syslogFacility is the daemon facility.
+const syslogFacility = 3
+
+// syslogSDID names the RFC 5424 structured data element holding the
+// attributes. 32473 is the enterprise number reserved for examples.
+const syslogSDID = "cfdnsupdater@32473"
+
+var syslogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// newSyslogHandler returns a handler that sends RFC 5424 messages to the
+// local syslog daemon, with the attributes as structured data.
+func newSyslogHandler(opts slog.HandlerOptions) (slog.Handler, error) {
+	conn, err := dialDatagramLog("/dev/log", "/var/run/syslog", "/var/run/log")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to syslog: %w", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	header := " " + hostname + " cfdnsupdater " + strconv.Itoa(os.Getpid()) + " - "
+	emit := func(r slog.Record, fields []logField) error {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "<%d>1 %s%s", syslogFacility*8+logSeverity(r.Level), r.Time.Format("2006-01-02T15:04:05.000000Z07:00"), header)
+		if len(fields) == 0 {
+			b.WriteString("-")
+		} else {
+			b.WriteString("[" + syslogSDID)
+			for _, f := range fields {
+				fmt.Fprintf(&b, ` %s="%s"`, syslogParamName(f.key), syslogEscaper.Replace(f.value))
+			}
+			b.WriteString("]")
+		}
+		b.WriteString(" " + r.Message)
+		return conn.write(b.Bytes())
+	}
+	return &fieldHandler{opts: opts, emit: emit}, nil
+}
+
+// syslogParamName makes a key a valid SD-PARAM name: at most 32 printable
+// characters other than space, =, ] and ".
+func syslogParamName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// newJournaldHandler returns a handler that sends entries to journald with
+// its native protocol, so attributes become fields of the entry.
+func newJournaldHandler(opts slog.HandlerOptions) (slog.Handler, error) {
+	conn, err := dialDatagramLog("/run/systemd/journal/socket")
+	if err != nil {
+		return nil, fmt.Errorf("connecting to journald: %w", err)
+	}
+	emit := func(r slog.Record, fields []logField) error {
+		var b bytes.Buffer
+		journalField(&b, "PRIORITY", strconv.Itoa(logSeverity(r.Level)))
+		journalField(&b, "SYSLOG_IDENTIFIER", "cfdnsupdater")
+		journalField(&b, "MESSAGE", r.Message)
+		for _, f := range fields {
+			journalField(&b, journalFieldName(f.key), f.value)
+		}
+		return conn.write(b.Bytes())
+	}
+	return &fieldHandler{opts: opts, emit: emit}, nil
+}
+
+// journalField appends a field in the journald native format, which needs
+// a length prefixed value if it contains a newline.
+func journalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName makes a key a valid journald field name: upper case
+// letters, digits and underscores, not starting with an underscore or digit.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if name == "" {
+		name = "FIELD"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// logLevel is the minimum level logged, which can be changed while we run.
+var logLevel = new(slog.LevelVar)
+
+// logLevelHandler reports the log level on GET and, if writable, sets it
+// from the body of a PUT, e.g. "debug" or "info".
+type logLevelHandler struct {
+	writable bool
+}
+
+func (h logLevelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+	case r.Method == http.MethodPut && h.writable:
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(strings.TrimSpace(string(body)))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		setLogLevel(level, "http")
+	default:
+		allow := "GET, HEAD"
+		if h.writable {
+			allow += ", PUT"
+		}
+		w.Header().Set("Allow", allow)
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := fmt.Fprintln(w, strings.ToLower(logLevel.Level().String())); err != nil {
+		slog.Error("error when responding with log level", "error", err)
+	}
+}
+
+func setLogLevel(level slog.Level, via string) {
+	previous := logLevel.Level()
+	logLevel.Set(level)
+	if level != previous {
+		slog.Warn("Changed log level", "from", previous, "to", level, "via", via)
+	}
+}
+
+// toggleDebug switches between debug and info logging.
+func toggleDebug() {
+	if logLevel.Level() <= slog.LevelDebug {
+		setLogLevel(slog.LevelInfo, "signal")
+	} else {
+		setLogLevel(slog.LevelDebug, "signal")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "context"
+
+// handleLogLevelSignal does nothing, as there is no SIGUSR2 to toggle debug
+// logging with; use PUT /loglevel, which needs -http-token or another
+// form of authentication, instead.
+func handleLogLevelSignal(ctx context.Context) {}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is appended to the log file name when it is rotated. It
+// sorts in time order.
+const rotatedSuffix = "2006-01-02T15-04-05.000"
+
+// openLogOutput returns the writer for -log-output: stdout, stderr or the
+// path of a file, which is rotated when it grows past maxSize bytes or is
+// older than maxAge.
+func openLogOutput(output string, maxSize int64, maxAge time.Duration, backups int) (io.Writer, error) {
+	switch output {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	f := &rotatingFile{path: output, maxSize: maxSize, maxAge: maxAge, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// rotatingFile is a log file that is renamed aside and replaced with a new
+// one when it gets too big or too old, keeping a limited number of old
+// files.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	// backups is how many rotated files to keep, 0 for all of them
+	backups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.maxAge > 0 && time.Since(f.opened) > f.maxAge
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			// keep logging to the old file rather than losing the line
+			fmt.Fprintf(os.Stderr, "cfdnsupdater: failed to rotate log file: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := os.Rename(f.path, f.path+"."+time.Now().Format(rotatedSuffix)); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	old.Close()
+	return f.prune()
+}
+
+// prune removes the oldest rotated files beyond the number to keep.
+func (f *rotatingFile) prune() error {
+	if f.backups <= 0 {
+		return nil
+	}
+	rotated, err := filepath.Glob(f.path + ".[0-9][0-9][0-9][0-9]-*")
+	if err != nil {
+		return err
+	}
+	sort.Strings(rotated)
+	for len(rotated) > f.backups {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
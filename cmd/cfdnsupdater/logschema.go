@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ecsVersion is the version of the Elastic Common Schema the ecs log schema
+// follows.
+const ecsVersion = "8.11.0"
+
+// ecsFieldSets are the ECS field sets our attributes use. Others are put in
+// our own namespace so they can't clash with ECS fields.
+var ecsFieldSets = map[string]bool{
+	"destination": true,
+	"dns":         true,
+	"error":       true,
+	"event":       true,
+	"http":        true,
+	"labels":      true,
+	"service":     true,
+	"source":      true,
+	"trace":       true,
+	"url":         true,
+}
+
+// ecsRenames maps our short attribute names to their ECS fields.
+var ecsRenames = map[string]string{
+	"fqdn":  "dns.question.name",
+	"error": "error.message",
+	"type":  "dns.question.type",
+}
+
+// logSchemaAttr returns the ReplaceAttr function that renames the standard
+// and our own attributes for a log schema:
+//
+//   - default: @timestamp, level and msg, as we always have
+//   - ecs: the Elastic Common Schema, strictly
+//   - logfmt: plain time, level and msg
+//   - gcp: the fields Google Cloud Logging understands, leaving the rest
+//     for jsonPayload
+func logSchemaAttr(schema string) (func([]string, slog.Attr) slog.Attr, error) {
+	switch schema {
+	case "default":
+		return func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				a.Key = "@timestamp"
+			}
+			return a
+		}, nil
+	case "ecs":
+		return func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.TimeKey:
+				a.Key = "@timestamp"
+			case slog.LevelKey:
+				a.Key = "log.level"
+				a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+			case slog.MessageKey:
+				a.Key = "message"
+			default:
+				if renamed, ok := ecsRenames[a.Key]; ok {
+					a.Key = renamed
+				} else if set, _, _ := strings.Cut(a.Key, "."); !ecsFieldSets[set] && a.Key != "ecs.version" {
+					a.Key = "cfdnsupdater." + a.Key
+				}
+			}
+			return a
+		}, nil
+	case "logfmt":
+		return func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.LevelKey {
+				a.Value = slog.StringValue(strings.ToLower(a.Value.String()))
+			}
+			return a
+		}, nil
+	case "gcp":
+		return func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				a.Key = "severity"
+				a.Value = slog.StringValue(gcpSeverity(a.Value.Any().(slog.Level)))
+			case slog.MessageKey:
+				a.Key = "message"
+			}
+			return a
+		}, nil
+	}
+	return nil, fmt.Errorf("log schema must be default, ecs, logfmt or gcp (got %s)", schema)
+}
+
+// gcpSeverity maps a slog level to a Cloud Logging LogSeverity.
+func gcpSeverity(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARNING"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
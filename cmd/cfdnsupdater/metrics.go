@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricsPrefix starts the names of all our own metrics.
+const metricsPrefix = "cfdnsupdater"
+
+var validMetricsPrefix = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// prefixedGatherer renames our metrics to start with prefix instead,
+// leaving the Go, process and promhttp metrics alone.
+func prefixedGatherer(g prometheus.Gatherer, prefix string) prometheus.Gatherer {
+	if prefix == metricsPrefix {
+		return g
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := g.Gather()
+		for _, mf := range families {
+			if rest, ok := strings.CutPrefix(mf.GetName(), metricsPrefix+"_"); ok {
+				name := rest
+				if prefix != "" {
+					name = prefix + "_" + rest
+				}
+				mf.Name = &name
+			}
+		}
+		return families, err
+	})
+}
+
+// metricsHandler serves the default registry's metrics, optionally without
+// the Go runtime and process collectors.
+func metricsHandler(prefix string, goMetrics, processMetrics bool) http.Handler {
+	if !goMetrics {
+		prometheus.Unregister(collectors.NewGoCollector())
+	}
+	if !processMetrics {
+		prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prefixedGatherer(prometheus.DefaultGatherer, prefix), promhttp.HandlerOpts{}))
+}
+
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "cfdnsupdater_build_info",
+	Help: "The version of cfdnsupdater running, always 1",
+	ConstLabels: prometheus.Labels{
+		"version":   Version,
+		"commit":    Commit,
+		"goversion": runtime.Version(),
+	},
+}, func() float64 { return 1 })
+
+var (
+	lastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_last_success_timestamp_seconds",
+		Help: "When an update cycle last succeeded, as a Unix timestamp",
+	})
+	lastIPChange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_last_ip_change_timestamp_seconds",
+		Help: "When a record was last changed to a new IP, as a Unix timestamp",
+	}, []string{"zone", "host"})
+)
+
+var currentIP = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "cfdnsupdater_current_ip_info",
+	Help: "The address each record was last confirmed to point at, always 1",
+}, []string{"zone", "host", "record_type", "ip"})
+
+// confirmIP records that host's record now points at ip, replacing any
+// address previously reported for it.
+func confirmIP(zone, host, recordType, ip string) {
+	currentIP.DeletePartialMatch(prometheus.Labels{"zone": zone, "host": host, "record_type": recordType})
+	currentIP.WithLabelValues(zone, host, recordType, ip).Set(1)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/redact"
+)
+
+// Notification events.
+const (
+	notifyChange   = "change"
+	notifyFailure  = "failure"
+	notifyRecovery = "recovery"
+	notifyToken    = "token"
+)
+
+const (
+	notifyAttempts   = 3
+	notifyRetryDelay = 2 * time.Second
+	notifyTimeout    = 30 * time.Second
+	// notifyQueueSize is how many notifications may wait for a slow
+	// notifier before more are dropped.
+	notifyQueueSize = 32
+)
+
+var notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_notification_failures_total",
+	Help: "The number of notifications that couldn't be delivered after retrying, by notifier",
+}, []string{"notifier"})
+
+// notification is an event worth telling someone about: a record was
+// created or changed, updates have kept failing, they have started
+// working again after that, or the API token is inactive or expiring.
+type notification struct {
+	Event   string
+	Zone    string
+	Host    string
+	OldIP   string
+	NewIP   string
+	Time    time.Time
+	Version string
+	// Error and Failures are the last error and the number of consecutive
+	// failed cycles, for failure events. Error describes the problem for
+	// token events.
+	Error    string
+	Failures int
+}
+
+// summary is the default text of chat messages.
+func (n notification) summary() string {
+	switch n.Event {
+	case notifyFailure:
+		return fmt.Sprintf("Updating %s has failed %d times in a row: %s", n.Host, n.Failures, n.Error)
+	case notifyRecovery:
+		return fmt.Sprintf("Updating %s is working again", n.Host)
+	case notifyToken:
+		return fmt.Sprintf("The API token used for %s %s", n.Host, n.Error)
+	}
+	if n.OldIP == "" {
+		return fmt.Sprintf("%s created with IP %s", n.Host, n.NewIP)
+	}
+	return fmt.Sprintf("%s changed from %s to %s", n.Host, n.OldIP, n.NewIP)
+}
+
+// notifier delivers notifications to one destination.
+type notifier interface {
+	name() string
+	notify(ctx context.Context, n notification) error
+}
+
+// notifyConfig is the configuration shared by all notifiers.
+type notifyConfig struct {
+	// events are the events to send
+	events map[string]bool
+	// failureThreshold is how many cycles in a row must fail to send a
+	// failure notification, 0 for never.
+	failureThreshold int
+}
+
+// parseNotifyEvents parses a comma separated list of events: change,
+// failure, recovery and token, for an API token that is inactive or about
+// to expire.
+func parseNotifyEvents(s string) (map[string]bool, error) {
+	events := map[string]bool{}
+	for _, e := range strings.Split(s, ",") {
+		switch e = strings.TrimSpace(e); e {
+		case notifyChange, notifyFailure, notifyRecovery, notifyToken:
+			events[e] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown notification event %q, expected %s, %s, %s or %s", e, notifyChange, notifyFailure, notifyRecovery, notifyToken)
+		}
+	}
+	return events, nil
+}
+
+// notifiers sends each notification to every configured notifier in the
+// background, in order, retrying failed deliveries. A nil notifiers sends
+// nothing.
+type notifiers struct {
+	notifyConfig
+	list    []notifier
+	queues  []chan notification
+	pending sync.WaitGroup
+
+	// alerting records that a failure was notified, so recovery should be.
+	// It's only used by the update loop.
+	alerting bool
+}
+
+func newNotifiers(list []notifier, config notifyConfig) *notifiers {
+	ns := &notifiers{notifyConfig: config, list: list}
+	for _, nf := range list {
+		queue := make(chan notification, notifyQueueSize)
+		ns.queues = append(ns.queues, queue)
+		go func() {
+			for n := range queue {
+				ns.deliver(nf, n)
+				ns.pending.Done()
+			}
+		}()
+	}
+	return ns
+}
+
+// cycleFinished sends a failure notification when the number of failed
+// cycles in a row reaches the threshold, and a recovery notification when
+// a cycle next succeeds.
+func (ns *notifiers) cycleFinished(config CFUpdateConfig, err error, failures int) {
+	if ns == nil || ns.failureThreshold <= 0 {
+		return
+	}
+	switch {
+	case err != nil && failures == ns.failureThreshold:
+		ns.alerting = true
+		ns.send(notification{Event: notifyFailure, Zone: config.Zone, Host: config.Host, Error: err.Error(), Failures: failures})
+	case err == nil && ns.alerting:
+		ns.alerting = false
+		ns.send(notification{Event: notifyRecovery, Zone: config.Zone, Host: config.Host})
+	}
+}
+
+func (ns *notifiers) send(n notification) {
+	if ns == nil || !ns.events[n.Event] {
+		return
+	}
+	n.Time, n.Version = time.Now().UTC(), Version
+	for i, nf := range ns.list {
+		ns.pending.Add(1)
+		select {
+		case ns.queues[i] <- n:
+		default:
+			ns.pending.Done()
+			notificationFailures.WithLabelValues(nf.name()).Inc()
+			slog.Warn("Too many notifications waiting, dropping one", "notifier", nf.name(), "event", n.Event, "fqdn", n.Host)
+		}
+	}
+}
+
+func (ns *notifiers) deliver(nf notifier, n notification) {
+	delay := notifyRetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := nf.notify(ctx, n)
+		cancel()
+		if err == nil {
+			slog.Debug("Sent notification", "notifier", nf.name(), "event", n.Event, "fqdn", n.Host)
+			return
+		}
+		if attempt == notifyAttempts {
+			notificationFailures.WithLabelValues(nf.name()).Inc()
+			slog.Error("Failed to send notification", "notifier", nf.name(), "event", n.Event, "fqdn", n.Host, "error", redact.String(err.Error()))
+			return
+		}
+		slog.Debug("Retrying notification", "notifier", nf.name(), "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// flushed returns a channel that is closed once notifications being sent
+// have been delivered or given up on.
+func (ns *notifiers) flushed() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		if ns != nil {
+			ns.pending.Wait()
+		}
+		close(done)
+	}()
+	return done
+}
+
+// loadNotifyTemplate parses a Go text/template file for a notifier, or
+// returns nil if file is empty. Templates are executed with the
+// notification, so can use .Event, .Zone, .Host, .OldIP, .NewIP, .Time,
+// .Version, .Error and .Failures, .Summary for the default text, and a
+// json function to quote values.
+func loadNotifyTemplate(file string) (*template.Template, error) {
+	if file == "" {
+		return nil, nil
+	}
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(file).Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(string(text))
+}
+
+// notifyTemplateData is what notification templates are executed with.
+type notifyTemplateData struct {
+	notification
+	Summary string
+}
+
+// renderMessage returns the text of a chat message, from t if it is set.
+func renderMessage(t *template.Template, n notification) (string, error) {
+	if t == nil {
+		return n.summary(), nil
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, notifyTemplateData{n, n.summary()}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// postNotification sends body to url, failing unless the response status
+// is 2xx. The error includes the start of the response, which usually
+// explains what was wrong.
+func postNotification(ctx context.Context, client *http.Client, url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", fmt.Sprintf("cfdnsupdater/%s", Version))
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		if len(bytes.TrimSpace(detail)) == 0 {
+			return fmt.Errorf("unexpected HTTP status %s", res.Status)
+		}
+		return fmt.Errorf("unexpected HTTP status %s: %s", res.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
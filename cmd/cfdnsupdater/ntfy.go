@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// ntfyNotifier publishes messages to an ntfy topic, on ntfy.sh or a
+// self-hosted server.
+type ntfyNotifier struct {
+	// url is the topic's URL
+	url      string
+	token    string
+	priority string
+	template *template.Template
+	client   *http.Client
+}
+
+// ntfyTags are shown as emoji next to the messages of each event.
+var ntfyTags = map[string]string{
+	notifyChange:   "arrows_counterclockwise",
+	notifyFailure:  "warning",
+	notifyRecovery: "white_check_mark",
+	notifyToken:    "key",
+}
+
+func newNtfyNotifier(topicURL, token, priority, templateFile string, client *http.Client) (*ntfyNotifier, error) {
+	if err := checkNotifyURL(topicURL); err != nil {
+		return nil, fmt.Errorf("ntfy topic %w", err)
+	}
+	switch priority {
+	case "", "1", "2", "3", "4", "5", "min", "low", "default", "high", "max", "urgent":
+	default:
+		return nil, fmt.Errorf("ntfy priority must be 1 to 5 or min, low, default, high or max (got %s)", priority)
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &ntfyNotifier{url: topicURL, token: token, priority: priority, template: t, client: client}, nil
+}
+
+func (n *ntfyNotifier) name() string {
+	return "ntfy"
+}
+
+func (n *ntfyNotifier) notify(ctx context.Context, nf notification) error {
+	text, err := renderMessage(n.template, nf)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Title", "cfdnsupdater: "+nf.Host)
+	header.Set("Tags", ntfyTags[nf.Event])
+	if n.priority != "" {
+		header.Set("Priority", n.priority)
+	}
+	if n.token != "" {
+		header.Set("Authorization", "Bearer "+n.token)
+	}
+	return postNotification(ctx, n.client, n.url, "text/plain; charset=utf-8", []byte(text), header)
+}
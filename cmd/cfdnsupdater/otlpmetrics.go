@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+)
+
+// otlpMetricsExporter periodically converts our Prometheus metrics to OTLP
+// and pushes them to a collector, so no scrape is needed.
+type otlpMetricsExporter struct {
+	*otlp.Exporter
+	gatherer prometheus.Gatherer
+	start    time.Time
+}
+
+// otlpCumulative is the OTLP aggregation temporality of Prometheus counters
+// and histograms.
+const otlpCumulative = 2
+
+type (
+	otlpNumberPoint struct {
+		Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsDouble          float64         `json:"asDouble"`
+	}
+	otlpHistogramPoint struct {
+		Attributes        []otlp.KeyValue `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		Count             string          `json:"count"`
+		Sum               float64         `json:"sum"`
+		BucketCounts      []string        `json:"bucketCounts"`
+		ExplicitBounds    []float64       `json:"explicitBounds"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	}
+	otlpMetric struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Unit        string         `json:"unit,omitempty"`
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlp.Scope   `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlp.Resource      `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpMetricsRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+)
+
+// run exports every interval until ctx is cancelled, and once more as we
+// stop so the final counts aren't lost. The returned channel is closed
+// after that last export.
+func (e *otlpMetricsExporter) run(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervise(ctx, clock.System{}, "otlp_metrics", e.loop(ctx, interval))
+	}()
+	return done
+}
+
+func (e *otlpMetricsExporter) loop(ctx context.Context, interval time.Duration) func() {
+	return func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.Background(), e.Timeout())
+				defer cancel()
+				e.push(ctx)
+				return
+			case <-ticker.C:
+			}
+			e.push(ctx)
+		}
+	}
+}
+
+func (e *otlpMetricsExporter) push(ctx context.Context) {
+	families, err := e.gatherer.Gather()
+	if err == nil {
+		err = e.Export(ctx, e.request(families, time.Now()))
+	}
+	if err != nil {
+		slog.Warn("Failed to export metrics over OTLP", "error", err)
+	}
+}
+
+// request converts gathered metric families into an OTLP request.
+func (e *otlpMetricsExporter) request(families []*dto.MetricFamily, now time.Time) otlpMetricsRequest {
+	start, ts := otlp.Time(e.start), otlp.Time(now)
+	var metrics []otlpMetric
+	for _, mf := range families {
+		name := mf.GetName()
+		if strings.HasPrefix(name, "promhttp_") {
+			continue
+		}
+		metric := otlpMetric{Name: name, Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			for _, m := range mf.GetMetric() {
+				metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberPoint{
+					Attributes:        otlpLabels(m.GetLabel()),
+					StartTimeUnixNano: start,
+					TimeUnixNano:      ts,
+					AsDouble:          m.GetCounter().GetValue(),
+				})
+			}
+		case dto.MetricType_GAUGE:
+			metric.Gauge = &otlpGauge{}
+			for _, m := range mf.GetMetric() {
+				metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberPoint{
+					Attributes:   otlpLabels(m.GetLabel()),
+					TimeUnixNano: ts,
+					AsDouble:     m.GetGauge().GetValue(),
+				})
+			}
+		case dto.MetricType_HISTOGRAM:
+			metric.Unit = otlpUnit(name)
+			metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+			for _, m := range mf.GetMetric() {
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, otlpHistogramFrom(m, start, ts))
+			}
+		default:
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: e.ResourceAttributes(),
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlp.Scope{Name: "cfdnsupdater", Version: Version},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+// otlpHistogramFrom converts Prometheus's cumulative buckets into OTLP's
+// per-bucket counts, which have an extra bucket for everything above the
+// last bound.
+func otlpHistogramFrom(m *dto.Metric, start, ts string) otlpHistogramPoint {
+	h := m.GetHistogram()
+	point := otlpHistogramPoint{
+		Attributes:        otlpLabels(m.GetLabel()),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+	}
+	var previous uint64
+	for _, b := range h.GetBucket() {
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+		previous = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+	return point
+}
+
+func otlpLabels(labels []*dto.LabelPair) []otlp.KeyValue {
+	attrs := make([]otlp.KeyValue, len(labels))
+	for i, l := range labels {
+		attrs[i] = otlp.Attribute(l.GetName(), l.GetValue())
+	}
+	return attrs
+}
+
+// otlpUnit guesses the UCUM unit from a Prometheus metric name suffix.
+func otlpUnit(name string) string {
+	if strings.HasSuffix(name, "_seconds") {
+		return "s"
+	}
+	return ""
+}
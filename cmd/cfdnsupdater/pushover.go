@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// pushoverURL is the Pushover API method sending messages.
+const pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// pushoverNotifier sends messages to a Pushover user or group.
+type pushoverNotifier struct {
+	token    string
+	user     string
+	priority int
+	template *template.Template
+	client   *http.Client
+}
+
+type pushoverMessage struct {
+	Token     string `json:"token"`
+	User      string `json:"user"`
+	Title     string `json:"title"`
+	Message   string `json:"message"`
+	Priority  int    `json:"priority"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// newPushoverNotifier returns a notifier sending with the application
+// token to the user or group key. Priorities of 2, which need
+// acknowledging, aren't supported.
+func newPushoverNotifier(token, user string, priority int, templateFile string, client *http.Client) (*pushoverNotifier, error) {
+	if user == "" {
+		return nil, errors.New("a user or group key is needed to send Pushover messages")
+	}
+	if priority < -2 || priority > 1 {
+		return nil, fmt.Errorf("Pushover priority must be from -2 to 1 (got %d)", priority)
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &pushoverNotifier{token: token, user: user, priority: priority, template: t, client: client}, nil
+}
+
+func (p *pushoverNotifier) name() string {
+	return "pushover"
+}
+
+func (p *pushoverNotifier) notify(ctx context.Context, n notification) error {
+	text, err := renderMessage(p.template, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(pushoverMessage{
+		Token:     p.token,
+		User:      p.user,
+		Title:     "cfdnsupdater: " + n.Host,
+		Message:   text,
+		Priority:  p.priority,
+		Timestamp: n.Time.Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, p.client, pushoverURL, "application/json", body, nil)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// showCommand prints the managed record as Cloudflare has it, alongside
+// the IP detected now, to check the two are in sync.
+func showCommand(args []string) int {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	var config CFUpdateConfig
+	addRecordFlags(fs, &config)
+	finishDetect := addDetectFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	if err := checkRecordConfig(config); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if err := finishDetect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	ctx := context.Background()
+	zoneID, records, err := hostRecords(ctx, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeFor(err)
+	}
+	ip, detectErr := detectIP(ctx, config)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Zone:\t%s (%s)\n", config.Zone, zoneID)
+	fmt.Fprintf(w, "Host:\t%s\n", config.Host)
+	if len(records) == 0 {
+		fmt.Fprintf(w, "Record:\tno %s record\n", config.RecordType)
+	}
+	for _, r := range records {
+		fmt.Fprintf(w, "Record:\t%s %s\tTTL %s\tproxied %s\tmodified %s\n", r.Type, r.Content, showTTL(r.TTL), yesNo(r.Proxied != nil && *r.Proxied), r.ModifiedOn.Local().Format(time.DateTime))
+	}
+	if detectErr != nil {
+		fmt.Fprintf(w, "Detected:\tfailed: %v\n", detectErr)
+	} else {
+		fmt.Fprintf(w, "Detected:\t%s\n", ip)
+		fmt.Fprintf(w, "In sync:\t%s\n", yesNo(len(records) > 0 && recordsMatch(records, ip)))
+	}
+	w.Flush()
+	if detectErr != nil {
+		return exitCodeFor(detectErr)
+	}
+	return exitOK
+}
+
+// recordsMatch reports whether every record points at ip.
+func recordsMatch(records []cloudflare.DNSRecord, ip string) bool {
+	for _, r := range records {
+		if r.Content != ip {
+			return false
+		}
+	}
+	return true
+}
+
+func showTTL(ttl int) string {
+	if ttl == 1 {
+		return "auto"
+	}
+	return fmt.Sprint(ttl)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+)
+
+// slackNotifier posts messages to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+	// channel overrides the webhook's default channel, for webhooks that
+	// allow it
+	channel  string
+	template *template.Template
+	client   *http.Client
+}
+
+type slackMessage struct {
+	Channel string `json:"channel,omitempty"`
+	Text    string `json:"text"`
+}
+
+// slackIcons prefix the default messages with an emoji per event.
+var slackIcons = map[string]string{
+	notifyChange:   ":arrows_counterclockwise: ",
+	notifyFailure:  ":warning: ",
+	notifyRecovery: ":white_check_mark: ",
+	notifyToken:    ":key: ",
+}
+
+func newSlackNotifier(webhookURL, channel, templateFile string, client *http.Client) (*slackNotifier, error) {
+	if err := checkNotifyURL(webhookURL); err != nil {
+		return nil, fmt.Errorf("Slack webhook %w", err)
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{url: webhookURL, channel: channel, template: t, client: client}, nil
+}
+
+func (s *slackNotifier) name() string {
+	return "slack"
+}
+
+func (s *slackNotifier) notify(ctx context.Context, n notification) error {
+	text, err := renderMessage(s.template, n)
+	if err != nil {
+		return err
+	}
+	if s.template == nil {
+		text = slackIcons[n.Event] + text
+	}
+	body, err := json.Marshal(slackMessage{Channel: s.channel, Text: text})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, s.client, s.url, "application/json", body, nil)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+)
+
+// statsdPacketSize keeps packets inside a typical MTU so they aren't
+// fragmented.
+const statsdPacketSize = 1432
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// statsdSink periodically sends our Prometheus metrics to a StatsD or
+// DogStatsD server. Counters are sent as the increase since the last
+// flush, gauges as their value and histograms as timings: the mean of the
+// observations since the last flush, with a sample rate so the server
+// counts each observation.
+type statsdSink struct {
+	conn      net.Conn
+	dogstatsd bool
+	gatherer  prometheus.Gatherer
+
+	// last holds the previous value of each counter, and the count and
+	// sum of each histogram, by series
+	last map[string]float64
+	buf  bytes.Buffer
+}
+
+func newStatsdSink(addr, format string, gatherer prometheus.Gatherer) (*statsdSink, error) {
+	if format != "statsd" && format != "dogstatsd" {
+		return nil, fmt.Errorf("StatsD format must be statsd or dogstatsd (got %s)", format)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{
+		conn:      conn,
+		dogstatsd: format == "dogstatsd",
+		gatherer:  gatherer,
+		last:      map[string]float64{},
+	}, nil
+}
+
+// run flushes metrics every interval until ctx is cancelled, and once more
+// as we stop so the final counts aren't lost. The returned channel is
+// closed after that last flush.
+func (s *statsdSink) run(ctx context.Context, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		supervise(ctx, clock.System{}, "statsd", func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+				case <-ticker.C:
+				}
+				if err := s.flush(); err != nil {
+					slog.Warn("Failed to send metrics to StatsD", "error", err)
+				}
+				if ctx.Err() != nil {
+					s.conn.Close()
+					return
+				}
+			}
+		})
+	}()
+	return done
+}
+
+func (s *statsdSink) flush() error {
+	families, err := s.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	for _, mf := range families {
+		name := mf.GetName()
+		if strings.HasPrefix(name, "go_") || strings.HasPrefix(name, "process_") || strings.HasPrefix(name, "promhttp_") {
+			continue
+		}
+		if mf.GetType() == dto.MetricType_HISTOGRAM {
+			// timings are sent in milliseconds
+			name = strings.TrimSuffix(name, "_seconds")
+		}
+		for _, m := range mf.GetMetric() {
+			series, tags := s.series(name, m.GetLabel())
+			key := series + "|" + tags
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				if delta := s.delta(key, m.GetCounter().GetValue()); delta > 0 {
+					err = s.send(series, tags, delta, "c", 1)
+				}
+			case dto.MetricType_GAUGE:
+				v := m.GetGauge().GetValue()
+				if v < 0 {
+					// a signed gauge value is a relative change in StatsD
+					if err = s.send(series, tags, 0, "g", 1); err != nil {
+						break
+					}
+				}
+				err = s.send(series, tags, v, "g", 1)
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				count := s.delta(key+"|count", float64(h.GetSampleCount()))
+				sum := s.delta(key+"|sum", h.GetSampleSum())
+				if count > 0 {
+					err = s.send(series, tags, sum/count*1000, "ms", 1/count)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return s.write()
+}
+
+// series returns the StatsD name and DogStatsD tags of a metric. Plain
+// StatsD has no tags, so label values are folded into the name.
+func (s *statsdSink) series(name string, labels []*dto.LabelPair) (string, string) {
+	var series strings.Builder
+	var tags strings.Builder
+	series.WriteString(name)
+	for i, l := range labels {
+		if s.dogstatsd {
+			if i > 0 {
+				tags.WriteByte(',')
+			}
+			tags.WriteString(statsdEscaper.Replace(l.GetName()) + ":" + statsdEscaper.Replace(l.GetValue()))
+			continue
+		}
+		series.WriteString("." + strings.ReplaceAll(statsdEscaper.Replace(l.GetValue()), ".", "_"))
+	}
+	return series.String(), tags.String()
+}
+
+// delta returns how much a cumulative value has grown since last time.
+func (s *statsdSink) delta(key string, value float64) float64 {
+	previous, seen := s.last[key]
+	s.last[key] = value
+	if !seen || value < previous {
+		return value
+	}
+	return value - previous
+}
+
+func (s *statsdSink) send(name, tags string, value float64, kind string, rate float64) error {
+	line := name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind
+	if rate < 1 {
+		line += "|@" + strconv.FormatFloat(rate, 'f', -1, 64)
+	}
+	if tags != "" {
+		line += "|#" + tags
+	}
+	if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > statsdPacketSize {
+		if err := s.write(); err != nil {
+			return err
+		}
+	}
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+	return nil
+}
+
+func (s *statsdSink) write() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return err
+}
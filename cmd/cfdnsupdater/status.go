@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// VersionInfo identifies the running build, served on /version.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+}
+
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	info := VersionInfo{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.Error("error when responding with version", "error", err)
+	}
+}
+
+// StatusConfig summarises what we were configured to do.
+type StatusConfig struct {
+	Zone        string   `json:"zone"`
+	Host        string   `json:"host"`
+	Aliases     []string `json:"aliases,omitempty"`
+	RecordType  string   `json:"record_type"`
+	Interval    string   `json:"interval"`
+	ObserveOnly bool     `json:"observe_only"`
+}
+
+// StatusReport is the state of the updater served on /status.
+type StatusReport struct {
+	Version             string                `json:"version"`
+	Commit              string                `json:"commit"`
+	Config              StatusConfig          `json:"config"`
+	Ready               bool                  `json:"ready"`
+	IP                  string                `json:"ip,omitempty"`
+	IPDetected          *time.Time            `json:"ip_detected,omitempty"`
+	LastChange          *time.Time            `json:"last_change,omitempty"`
+	LastSuccess         *time.Time            `json:"last_success,omitempty"`
+	LastError           string                `json:"last_error,omitempty"`
+	LastErrorTime       *time.Time            `json:"last_error_time,omitempty"`
+	ConsecutiveFailures int                   `json:"consecutive_failures"`
+	IPSources           []ipsource.Status     `json:"ip_sources"`
+	IPBreakerOpenUntil  *time.Time            `json:"ip_breaker_open_until,omitempty"`
+	RateLimitedUntil    *time.Time            `json:"rate_limited_until,omitempty"`
+	Deprecations        []Deprecation         `json:"deprecations,omitempty"`
+	Canary              *updater.CanaryReport `json:"canary,omitempty"`
+	RecentCycles        []CycleOutcome        `json:"recent_cycles"`
+	Targets             []TargetStatus        `json:"targets,omitempty"`
+}
+
+// CycleOutcome is how an update cycle went.
+type CycleOutcome struct {
+	Start           time.Time `json:"start"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Changed         bool      `json:"changed"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// recentCycles is how many cycle outcomes the status report keeps.
+const recentCycles = 10
+
+// updaterState is what the update loop has done so far.
+type updaterState struct {
+	mu                  sync.Mutex
+	ip                  string
+	ipDetected          time.Time
+	lastChange          time.Time
+	lastSuccess         time.Time
+	lastError           string
+	lastErrorTime       time.Time
+	consecutiveFailures int
+	// cycleStart is when the cycle in progress started, or zero
+	cycleStart time.Time
+	// cycleChanged records that the cycle in progress changed a record
+	cycleChanged bool
+	// recent are the outcomes of the last cycles, newest first
+	recent []CycleOutcome
+}
+
+var state = &updaterState{}
+
+// started records that a cycle has started.
+func (s *updaterState) started(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycleStart, s.cycleChanged = t, false
+}
+
+// cycleRunning returns how long the cycle in progress has been running, or
+// zero if none is.
+func (s *updaterState) cycleRunning() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cycleStart.IsZero() {
+		return 0
+	}
+	return time.Since(s.cycleStart)
+}
+
+func (s *updaterState) failures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.consecutiveFailures
+}
+
+// detected records the IP found by a cycle.
+func (s *updaterState) detected(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ip, s.ipDetected = ip, time.Now()
+}
+
+// changed records that a cycle changed a record.
+func (s *updaterState) changed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastChange = time.Now()
+	s.cycleChanged = true
+}
+
+// finished records the outcome of a cycle.
+func (s *updaterState) finished(err error, failures int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.consecutiveFailures = failures
+	outcome := CycleOutcome{Start: s.cycleStart, DurationSeconds: time.Since(s.cycleStart).Seconds(), Changed: s.cycleChanged}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	s.recent = append([]CycleOutcome{outcome}, s.recent[:min(len(s.recent), recentCycles-1)]...)
+	s.cycleStart = time.Time{}
+	if err != nil {
+		s.lastError, s.lastErrorTime = err.Error(), time.Now()
+		return
+	}
+	s.lastSuccess = time.Now()
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// statusHandler serves a StatusReport as JSON.
+type statusHandler struct {
+	config   CFUpdateConfig
+	interval time.Duration
+}
+
+func (h statusHandler) Report() StatusReport {
+	report := StatusReport{
+		Version: Version,
+		Commit:  Commit,
+		Config: StatusConfig{
+			Zone:        h.config.Zone,
+			Host:        h.config.Host,
+			RecordType:  h.config.RecordType,
+			Interval:    h.interval.String(),
+			ObserveOnly: h.config.Canary != nil,
+		},
+		Ready:              updated.Load(),
+		IPSources:          h.config.IPSources.Status(),
+		IPBreakerOpenUntil: optionalTime(h.config.IPBreaker.openedUntil()),
+		Deprecations:       deprecations.List(),
+	}
+	for _, a := range h.config.Aliases {
+		report.Config.Aliases = append(report.Config.Aliases, a.String())
+	}
+	for _, t := range h.config.Targets {
+		report.Targets = append(report.Targets, t.status())
+	}
+	var limited *provider.RateLimitError
+	if errors.As(cfprovider.RateLimited(), &limited) {
+		report.RateLimitedUntil = &limited.Until
+	}
+	if h.config.Canary != nil {
+		canary := h.config.Canary.Report()
+		report.Canary = &canary
+	}
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	report.IP = state.ip
+	report.IPDetected = optionalTime(state.ipDetected)
+	report.LastChange = optionalTime(state.lastChange)
+	report.LastSuccess = optionalTime(state.lastSuccess)
+	report.LastError = state.lastError
+	report.LastErrorTime = optionalTime(state.lastErrorTime)
+	report.ConsecutiveFailures = state.consecutiveFailures
+	report.RecentCycles = append([]CycleOutcome{}, state.recent...)
+	return report
+}
+
+func (h statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Report()); err != nil {
+		slog.Error("error when responding with status", "error", err)
+	}
+}
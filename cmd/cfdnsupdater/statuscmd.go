@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statusCommand prints the /status report of a daemon running on this
+// machine, or with -watch keeps redrawing it on the terminal.
+func statusCommand(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	daemon := addLocalDaemonFlags(fs)
+	watch := fs.Bool("watch", false, "keep polling and redrawing the status until interrupted")
+	every := fs.Duration("interval", 2*time.Second, "how often to poll with -watch")
+	if err := fs.Parse(args); err != nil {
+		return flagExitCode(err)
+	}
+
+	color := os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+	if !*watch {
+		report, err := fetchStatus(context.Background(), daemon)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to get status:", err)
+			return exitCodeFor(err)
+		}
+		os.Stdout.Write(renderStatus(report, color, time.Now()))
+		return exitOK
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ticker := time.NewTicker(*every)
+	defer ticker.Stop()
+	for {
+		var screen []byte
+		report, err := fetchStatus(ctx, daemon)
+		if err != nil {
+			screen = []byte(fmt.Sprintf("Failed to get status: %v\n", err))
+		} else {
+			screen = renderStatus(report, color, time.Now())
+		}
+		// home the cursor and clear the screen before redrawing
+		os.Stdout.Write(append([]byte("\x1b[H\x1b[2J"), screen...))
+		fmt.Printf("\nPolling every %s, press Ctrl-C to quit\n", *every)
+		select {
+		case <-ctx.Done():
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+func fetchStatus(ctx context.Context, daemon *localDaemon) (StatusReport, error) {
+	var report StatusReport
+	res, err := daemon.get(ctx, "/status")
+	if err != nil {
+		return report, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return report, fmt.Errorf("%s returned %s", res.Request.URL, res.Status)
+	}
+	return report, json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&report)
+}
+
+// renderStatus lays out a report for reading on a terminal.
+func renderStatus(r StatusReport, color bool, now time.Time) []byte {
+	var b bytes.Buffer
+	paint := func(code, s string) string {
+		if color {
+			return code + s + ansiReset
+		}
+		return s
+	}
+	when := func(t *time.Time) string {
+		if t == nil {
+			return paint(ansiDim, "never")
+		}
+		return fmt.Sprintf("%s %s", t.Local().Format(time.DateTime), paint(ansiDim, "("+now.Sub(*t).Truncate(time.Second).String()+" ago)"))
+	}
+
+	fmt.Fprintf(&b, "%s %s\n", paint(ansiBold, "cfdnsupdater "+r.Version), paint(ansiDim, r.Commit))
+	fmt.Fprintf(&b, "%s (%s) in %s, every %s", r.Config.Host, r.Config.RecordType, r.Config.Zone, r.Config.Interval)
+	if len(r.Config.Aliases) > 0 {
+		fmt.Fprintf(&b, ", aliases %s", strings.Join(r.Config.Aliases, ", "))
+	}
+	if r.Config.ObserveOnly {
+		b.WriteString(", observing only")
+	}
+	b.WriteString("\n\n")
+
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	ready := paint(ansiGreen, "yes")
+	if !r.Ready {
+		ready = paint(ansiYellow, "no")
+	}
+	fmt.Fprintf(w, "Ready:\t%s\n", ready)
+	if r.IP != "" {
+		fmt.Fprintf(w, "IP:\t%s, detected %s\n", paint(ansiBold, r.IP), when(r.IPDetected))
+	} else {
+		fmt.Fprintf(w, "IP:\t%s\n", paint(ansiDim, "not detected yet"))
+	}
+	fmt.Fprintf(w, "Last change:\t%s\n", when(r.LastChange))
+	fmt.Fprintf(w, "Last success:\t%s\n", when(r.LastSuccess))
+	failures := fmt.Sprintf("%d in a row", r.ConsecutiveFailures)
+	if r.ConsecutiveFailures > 0 {
+		failures = paint(ansiRed, failures)
+	}
+	fmt.Fprintf(w, "Failures:\t%s\n", failures)
+	if r.LastError != "" {
+		fmt.Fprintf(w, "Last error:\t%s\n", when(r.LastErrorTime))
+		fmt.Fprintf(w, "\t%s\n", paint(ansiRed, r.LastError))
+	}
+	if r.IPBreakerOpenUntil != nil {
+		fmt.Fprintf(w, "IP lookups paused until:\t%s\n", r.IPBreakerOpenUntil.Local().Format(time.DateTime))
+	}
+	if r.RateLimitedUntil != nil {
+		fmt.Fprintf(w, "Rate limited until:\t%s\n", r.RateLimitedUntil.Local().Format(time.DateTime))
+	}
+	w.Flush()
+
+	b.WriteString("\nIP sources\n")
+	w = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	for _, s := range r.IPSources {
+		health := paint(ansiGreen, "healthy")
+		if !s.Healthy {
+			health = paint(ansiRed, fmt.Sprintf("failing (%d)", s.ConsecutiveFailures))
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", s.Name, health, s.LastError)
+	}
+	w.Flush()
+
+	if len(r.Targets) > 1 {
+		b.WriteString("\nTargets\n")
+		w = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		for _, t := range r.Targets {
+			outcome := paint(ansiGreen, "ok")
+			switch {
+			case t.RateLimitedUntil != nil && t.RateLimitedUntil.After(now):
+				outcome = paint(ansiYellow, "rate limited until "+t.RateLimitedUntil.Local().Format(time.DateTime))
+			case t.LastErrorTime != nil && (t.LastSuccess == nil || t.LastErrorTime.After(*t.LastSuccess)):
+				outcome = paint(ansiRed, "failing: "+t.LastError)
+			case t.LastSuccess == nil:
+				outcome = paint(ansiDim, "not updated yet")
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", t.Host, t.Provider, outcome)
+		}
+		w.Flush()
+	}
+
+	b.WriteString("\nRecent cycles\n")
+	if len(r.RecentCycles) == 0 {
+		fmt.Fprintf(&b, "  %s\n", paint(ansiDim, "none yet"))
+	}
+	w = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	for _, c := range r.RecentCycles {
+		outcome := paint(ansiGreen, "ok")
+		switch {
+		case c.Error != "":
+			outcome = paint(ansiRed, "failed: "+c.Error)
+		case c.Changed:
+			outcome = paint(ansiBlue, "changed")
+		}
+		duration := time.Duration(c.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", c.Start.Local().Format(time.TimeOnly), duration, outcome)
+	}
+	w.Flush()
+
+	if r.Canary != nil {
+		fmt.Fprintf(&b, "\nCanary: %d cycles, %d agreed, %d differences\n", r.Canary.Cycles, r.Canary.Agreements, len(r.Canary.Differences))
+	}
+	for _, d := range r.Deprecations {
+		fmt.Fprintf(&b, "\n%s %s\n", paint(ansiYellow, "Deprecated:"), d.Message)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// StatusPublisher writes a small JSON document describing the current
+// address of a host to an external location whenever the record changes.
+//
+// The URL may be an http(s) URL, which receives a PUT (suitable for
+// pre-signed S3/R2 URLs or a Worker in front of KV), or
+// kv://<account-id>/<namespace-id>/<key> to write directly to Workers KV
+// using the Cloudflare credentials the updater already has.
+//
+// Format is "signed" for the default document, or "cloudevents" to send a
+// CloudEvents 1.0 structured event with the payload as its data.
+type StatusPublisher struct {
+	URL    string
+	Format string
+	Token  string
+	Key    ed25519.PrivateKey
+	Client *http.Client
+}
+
+type statusPayload struct {
+	Host string `json:"host"`
+	// Names lists every record changed along with Host, when it has
+	// aliases.
+	Names     []string  `json:"names,omitempty"`
+	IP        string    `json:"ip"`
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+}
+
+// statusDocument wraps the payload bytes verbatim so consumers can verify
+// the signature against exactly what was signed.
+type statusDocument struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature,omitempty"`
+	Algorithm string          `json:"alg,omitempty"`
+}
+
+// loadSigningKey reads a PEM-encoded PKCS#8 Ed25519 private key, as
+// produced by `openssl genpkey -algorithm ed25519`.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found in signing key file")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edkey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not an Ed25519 key", key)
+	}
+	return edkey, nil
+}
+
+func (p *StatusPublisher) document(host, ip string, names []string) ([]byte, error) {
+	payload, err := json.Marshal(statusPayload{
+		Host:      host,
+		Names:     names,
+		IP:        ip,
+		Timestamp: time.Now().UTC(),
+		Version:   Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	signature := ""
+	if p.Key != nil {
+		signature = base64.StdEncoding.EncodeToString(ed25519.Sign(p.Key, payload))
+	}
+	if p.Format == "cloudevents" {
+		event, err := newCloudEvent(recordChangedEventType, host, payload)
+		if err != nil {
+			return nil, err
+		}
+		event.Signature = signature
+		return json.Marshal(event)
+	}
+	doc := statusDocument{Payload: payload, Signature: signature}
+	if signature != "" {
+		doc.Algorithm = "ed25519"
+	}
+	return json.Marshal(doc)
+}
+
+// Publish writes the status document for host to the configured location.
+// Names are the records changed in this update. kvAPI is only called to
+// write to Workers KV, so other locations work with any provider.
+func (p *StatusPublisher) Publish(ctx context.Context, kvAPI func() (*cloudflare.API, error), host, ip string, names []string) error {
+	body, err := p.document(host, ip, names)
+	if err != nil {
+		return err
+	}
+
+	u, err := parseStatusURL(p.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "kv":
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		api, err := kvAPI()
+		if err != nil {
+			return err
+		}
+		_, err = api.WriteWorkersKVEntry(ctx, cloudflare.AccountIdentifier(u.Host), cloudflare.WriteWorkersKVEntryParams{
+			NamespaceID: parts[0],
+			Key:         parts[1],
+			Value:       body,
+		})
+		return err
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if p.Format == "cloudevents" {
+			req.Header.Set("Content-Type", cloudEventsContentType)
+		}
+		req.Header.Set("User-Agent", fmt.Sprintf("cfdnsupdater/%s", Version))
+		if p.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+		res, err := p.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("unexpected HTTP status %s publishing status document", res.Status)
+		}
+		return nil
+	}
+	return nil
+}
+
+// parseStatusURL parses a status document URL and checks that Publish can
+// write to it.
+func parseStatusURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "kv":
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("KV status URL must be kv://<account-id>/<namespace-id>/<key>, got %s", raw)
+		}
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("status document URL has no host: %s", raw)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported status document URL scheme %q", u.Scheme)
+	}
+	return u, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+)
+
+// panicRestartDelay is how long a supervised loop is paused after a panic,
+// so a loop that panics every time doesn't spin.
+const panicRestartDelay = 10 * time.Second
+
+var loopPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_panics_total",
+	Help: "The number of panics recovered from, by loop",
+}, []string{"loop"})
+
+// supervise runs f, restarting it after a pause on clock whenever it
+// panics, until it returns normally or ctx is cancelled.
+func supervise(ctx context.Context, clock clock.Clock, name string, f func()) {
+	for runRecovered(name, f) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(panicRestartDelay):
+		}
+	}
+}
+
+// runRecovered runs f and reports whether it panicked.
+func runRecovered(name string, f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			loopPanics.WithLabelValues(name).Inc()
+			slog.Error("Recovered from panic, restarting",
+				"loop", name,
+				"error", fmt.Sprint(r),
+				"error.stack_trace", string(debug.Stack()),
+				"restart_delay", panicRestartDelay,
+			)
+		}
+	}()
+	f()
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+var (
+	targetUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_target_up",
+		Help: "Whether the last update of each record by its provider succeeded, 1 or 0",
+	}, []string{"provider", "zone", "host"})
+	targetLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_target_last_success_timestamp_seconds",
+		Help: "When each record was last updated or confirmed by its provider, as a Unix timestamp",
+	}, []string{"provider", "zone", "host"})
+)
+
+// target is a record kept pointing at the detected IP by one provider.
+// Each cycle updates every target, and one target failing or being rate
+// limited doesn't stop the others.
+type target struct {
+	recordName
+	updater *updater.Updater
+
+	mu            sync.Mutex
+	lastSuccess   time.Time
+	lastError     string
+	lastErrorTime time.Time
+	// limited is the last rate limit the provider reported, and the
+	// target is skipped until it has passed
+	limited *provider.RateLimitError
+}
+
+// TargetStatus is how updates of one record have gone.
+type TargetStatus struct {
+	Provider         string     `json:"provider"`
+	Zone             string     `json:"zone"`
+	Host             string     `json:"host"`
+	LastSuccess      *time.Time `json:"last_success,omitempty"`
+	LastError        string     `json:"last_error,omitempty"`
+	LastErrorTime    *time.Time `json:"last_error_time,omitempty"`
+	RateLimitedUntil *time.Time `json:"rate_limited_until,omitempty"`
+}
+
+// newTargets makes a target for the host and one for each alias. They
+// use p unless an alias names another provider, which is made from the
+// rest of config as the main provider is.
+func newTargets(config CFUpdateConfig, p provider.Provider) ([]*target, error) {
+	providers := map[string]provider.Provider{config.ProviderName: p}
+	var targets []*target
+	for _, name := range config.names() {
+		name.Provider = cmp.Or(name.Provider, config.ProviderName)
+		c := config.Config
+		c.Zone, c.Host = name.Zone, name.Host
+		if name.Zone != config.Zone || name.Provider != config.ProviderName {
+			// -zone-id only identifies the main zone
+			c.ZoneID = ""
+		}
+		tp, ok := providers[name.Provider]
+		if !ok {
+			pc := config
+			pc.ProviderName, pc.Zone, pc.ZoneID = name.Provider, name.Zone, ""
+			if err := checkZoneConfig(pc); err != nil {
+				return nil, fmt.Errorf("alias %s: %w", name, err)
+			}
+			var err error
+			if tp, err = newProvider(pc); err != nil {
+				return nil, fmt.Errorf("alias %s: %w", name, err)
+			}
+			providers[name.Provider] = tp
+		}
+		targets = append(targets, &target{
+			recordName: name,
+			updater:    updater.New(c, updater.WithProvider(tp), updater.WithClock(config.Clock)),
+		})
+	}
+	return targets, nil
+}
+
+// update points the target's record at ip, unless its provider's rate
+// limit is still in force.
+func (t *target) update(ctx context.Context, ip string, now time.Time) (*updater.Change, error) {
+	t.mu.Lock()
+	limited := t.limited
+	t.mu.Unlock()
+	if limited != nil && now.Before(limited.Until) {
+		slog.DebugContext(ctx, "Skipping update while rate limited", "fqdn", t.Host, "provider", t.Provider, "until", limited.Until)
+		return nil, limited
+	}
+	change, err := t.updater.UpdateHost(ctx, ip)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	labels := prometheus.Labels{"provider": t.Provider, "zone": t.Zone, "host": t.Host}
+	if err != nil {
+		t.lastError, t.lastErrorTime, t.limited = err.Error(), now, nil
+		errors.As(err, &t.limited)
+		targetUp.With(labels).Set(0)
+		return change, err
+	}
+	t.lastSuccess, t.limited = now, nil
+	targetUp.With(labels).Set(1)
+	targetLastSuccess.With(labels).Set(float64(now.Unix()))
+	return change, nil
+}
+
+func (t *target) status() TargetStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := TargetStatus{
+		Provider:      t.Provider,
+		Zone:          t.Zone,
+		Host:          t.Host,
+		LastSuccess:   optionalTime(t.lastSuccess),
+		LastError:     t.lastError,
+		LastErrorTime: optionalTime(t.lastErrorTime),
+	}
+	if t.limited != nil {
+		s.RateLimitedUntil = &t.limited.Until
+	}
+	return s
+}
+
+// cycleError joins the errors of the targets that failed in a cycle. With
+// several targets, a rate limit only holds back the target it applies to,
+// which skips updates until it has passed, so it is reported as a plain
+// failure rather than delaying the next cycle for every target.
+func cycleError(targets []*target, errs []error) error {
+	if len(targets) <= 1 {
+		return errors.Join(errs...)
+	}
+	for i, err := range errs {
+		var limited *provider.RateLimitError
+		if errors.As(err, &limited) {
+			errs[i] = errors.New(err.Error())
+		}
+	}
+	return errors.Join(errs...)
+}
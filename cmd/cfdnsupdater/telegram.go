@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// telegramNotifier sends messages to a chat with a Telegram bot.
+type telegramNotifier struct {
+	// url is the bot's sendMessage method
+	url      string
+	chatID   string
+	template *template.Template
+	client   *http.Client
+}
+
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// newTelegramNotifier returns a notifier for the bot with token, using the
+// Bot API server at apiURL.
+func newTelegramNotifier(apiURL, token, chatID, templateFile string, client *http.Client) (*telegramNotifier, error) {
+	if err := checkNotifyURL(apiURL); err != nil {
+		return nil, fmt.Errorf("Telegram API %w", err)
+	}
+	if chatID == "" {
+		return nil, errors.New("a chat ID is needed to send Telegram messages")
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &telegramNotifier{
+		url:      strings.TrimSuffix(apiURL, "/") + "/bot" + token + "/sendMessage",
+		chatID:   chatID,
+		template: t,
+		client:   client,
+	}, nil
+}
+
+func (t *telegramNotifier) name() string {
+	return "telegram"
+}
+
+func (t *telegramNotifier) notify(ctx context.Context, n notification) error {
+	text, err := renderMessage(t.template, n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(telegramMessage{ChatID: t.chatID, Text: text, DisableWebPagePreview: true})
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, t.client, t.url, "application/json", body, nil)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+)
+
+var (
+	tokenValid = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_api_token_valid",
+		Help: "Whether the Cloudflare API token was active when last verified",
+	})
+	tokenExpiryDays = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_api_token_expiry_days",
+		Help: "Days until the Cloudflare API token expires, if it has an expiry date",
+	})
+)
+
+// checkToken verifies the API token and reports its state, warning if it
+// is about to expire. It returns the problem with the token, if it is
+// inactive or expiring, for notifying.
+func checkToken(ctx context.Context, config CFUpdateConfig, warnWithin time.Duration) string {
+	api, err := cfprovider.NewAPI(config.Account)
+	if err != nil {
+		slog.Error("Failed to create API client to verify token", "error", err)
+		return ""
+	}
+	token, err := api.VerifyAPIToken(ctx)
+	if err != nil {
+		tokenValid.Set(0)
+		slog.Error("Failed to verify API token", "error", err)
+		return ""
+	}
+	if token.Status != "active" {
+		tokenValid.Set(0)
+		slog.Error("API token is not active, updates will fail", "status", token.Status, "token.id", token.ID)
+		return fmt.Sprintf("is %s, so updates will fail", token.Status)
+	}
+	tokenValid.Set(1)
+	if token.ExpiresOn.IsZero() {
+		slog.Debug("API token is active and doesn't expire", "token.id", token.ID)
+		return ""
+	}
+	remaining := token.ExpiresOn.Sub(config.Clock.Now())
+	tokenExpiryDays.Set(remaining.Hours() / 24)
+	if remaining < warnWithin {
+		slog.Warn("API token expires soon, replace it before updates start failing",
+			"token.id", token.ID,
+			"expires", token.ExpiresOn,
+			"event.action", "token_expiry_warning",
+		)
+		return fmt.Sprintf("expires at %s, replace it before updates start failing", token.ExpiresOn.UTC().Format(time.RFC3339))
+	}
+	slog.Debug("API token is active", "token.id", token.ID, "expires", token.ExpiresOn)
+	return ""
+}
+
+// monitorToken checks the API token now and then every interval until ctx
+// is cancelled, notifying when a problem with it is first found.
+func monitorToken(ctx context.Context, config CFUpdateConfig, interval, warnWithin time.Duration) {
+	go supervise(ctx, config.Clock, "token", func() {
+		notified := ""
+		for {
+			if problem := checkToken(ctx, config, warnWithin); problem != notified {
+				if problem != "" {
+					config.Notifiers.send(notification{Event: notifyToken, Zone: config.Zone, Host: config.Host, Error: problem})
+				}
+				notified = problem
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-config.Clock.After(interval):
+			}
+		}
+	})
+}
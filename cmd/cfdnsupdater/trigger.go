@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// TriggerResult is the outcome of an update cycle run by POST /trigger.
+type TriggerResult struct {
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	Changed  bool   `json:"changed"`
+	Duration string `json:"duration"`
+}
+
+// triggerHandler asks the update loop to run a cycle straight away, and
+// responds with its result once it has finished. If a cycle is already
+// running, the triggered one starts after it.
+type triggerHandler struct {
+	triggers chan<- chan<- error
+}
+
+func (h triggerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	// the cycle can take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.Debug("Failed to lift write deadline for trigger", "error", err)
+	}
+	reply := make(chan error, 1)
+	select {
+	case h.triggers <- reply:
+	case <-r.Context().Done():
+		return
+	}
+	// the loop starts the cycle as soon as it takes the trigger, perhaps
+	// after finishing one already in progress
+	start := time.Now()
+	var err error
+	select {
+	case err = <-reply:
+	case <-r.Context().Done():
+		return
+	}
+	result := TriggerResult{Success: err == nil, Duration: time.Since(start).Round(time.Millisecond).String()}
+	state.mu.Lock()
+	if state.ipDetected.After(start) {
+		result.IP = state.ip
+	}
+	result.Changed = state.lastChange.After(start)
+	state.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		result.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.Error("error when responding with trigger result", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// webhookNotifier POSTs a JSON document describing each notification to a
+// URL. The document is webhookPayload unless a template is given, wrapped
+// in a CloudEvent if the format is cloudevents.
+type webhookNotifier struct {
+	url        string
+	template   *template.Template
+	client     *http.Client
+	cloudEvent bool
+}
+
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	Zone      string    `json:"zone"`
+	Host      string    `json:"host"`
+	OldIP     string    `json:"old_ip,omitempty"`
+	NewIP     string    `json:"new_ip,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Version   string    `json:"version"`
+	Error     string    `json:"error,omitempty"`
+	Failures  int       `json:"failures,omitempty"`
+}
+
+// newWebhookNotifier checks the URL and format, json or cloudevents, and
+// loads the template file, if any.
+func newWebhookNotifier(rawURL, format, templateFile string, client *http.Client) (*webhookNotifier, error) {
+	if err := checkNotifyURL(rawURL); err != nil {
+		return nil, fmt.Errorf("webhook %w", err)
+	}
+	switch format {
+	case "json":
+	case "cloudevents":
+		if templateFile != "" {
+			return nil, errors.New("webhook template can't be used with the cloudevents format")
+		}
+	default:
+		return nil, fmt.Errorf("webhook format must be json or cloudevents (got %s)", format)
+	}
+	t, err := loadNotifyTemplate(templateFile)
+	if err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{url: rawURL, template: t, client: client, cloudEvent: format == "cloudevents"}, nil
+}
+
+// checkNotifyURL checks that a notifier was given an http(s) URL.
+func checkNotifyURL(rawURL string) error {
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("URL must be an http(s) URL (got %s)", rawURL)
+	}
+	return nil
+}
+
+func (w *webhookNotifier) name() string {
+	return "webhook"
+}
+
+func (w *webhookNotifier) notify(ctx context.Context, n notification) error {
+	var body []byte
+	if w.template != nil {
+		text, err := renderMessage(w.template, n)
+		if err != nil {
+			return err
+		}
+		body = []byte(text)
+	} else {
+		var err error
+		body, err = json.Marshal(webhookPayload{
+			Event:     n.Event,
+			Zone:      n.Zone,
+			Host:      n.Host,
+			OldIP:     n.OldIP,
+			NewIP:     n.NewIP,
+			Timestamp: n.Time,
+			Version:   n.Version,
+			Error:     n.Error,
+			Failures:  n.Failures,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if w.cloudEvent {
+		event, err := newCloudEvent(cloudEventTypes[n.Event], n.Host, body)
+		if err != nil {
+			return err
+		}
+		if body, err = json.Marshal(event); err != nil {
+			return err
+		}
+		return postNotification(ctx, w.client, w.url, cloudEventsContentType, body, nil)
+	}
+	return postNotification(ctx, w.client, w.url, "application/json", body, nil)
+}
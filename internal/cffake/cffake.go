@@ -0,0 +1,324 @@
+// Package cffake is an in-process fake of the parts of the Cloudflare API
+// that cfdnsupdater uses: zone lookup, token verification and listing,
+// creating, updating and deleting DNS records. It lets whole update cycles
+// run without a Cloudflare account:
+//
+//	fake := cffake.New()
+//	defer fake.Close()
+//	zoneID := fake.AddZone("example.com")
+//	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.example.com", Type: "A", Content: "192.0.2.1"})
+//	u := updater.New(updater.Config{Account: fake.Account("example.com"), Host: "home.example.com", RecordType: "A"})
+//	change, err := u.UpdateHost(ctx, "192.0.2.2")
+package cffake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+)
+
+// Error codes Cloudflare returns for a zone or record that doesn't exist.
+const (
+	codeNoRoute        = 7003
+	codeRecordNotFound = 81044
+)
+
+// Server is a fake Cloudflare API. It is safe for concurrent use.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	zones    []cloudflare.Zone
+	records  map[string][]cloudflare.DNSRecord
+	nextID   int
+	calls    []string
+	failures []failure
+}
+
+// failure is an error response queued by FailNext.
+type failure struct {
+	status  int
+	code    int
+	message string
+}
+
+// New starts a fake with no zones.
+func New() *Server {
+	s := &Server{records: map[string][]cloudflare.DNSRecord{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// BaseURL is the API endpoint, for Account.APIBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/client/v4"
+}
+
+// Account returns credentials for zone that talk to the fake.
+func (s *Server) Account(zone string) cfprovider.Account {
+	return cfprovider.Account{Zone: zone, ApiToken: "cffake", APIBaseURL: s.BaseURL()}
+}
+
+// AddZone creates a zone and returns its ID.
+func (s *Server) AddZone(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.newID("zone")
+	s.zones = append(s.zones, cloudflare.Zone{ID: id, Name: name})
+	return id
+}
+
+// RemoveZone deletes a zone and its records, as if it was deleted in the
+// dashboard.
+func (s *Server) RemoveZone(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, z := range s.zones {
+		if z.ID == id {
+			s.zones = append(s.zones[:i], s.zones[i+1:]...)
+			break
+		}
+	}
+	delete(s.records, id)
+}
+
+// AddRecord creates a record in the zone, filling in its ID and creation
+// time if they are empty, and returns it.
+func (s *Server) AddRecord(zoneID string, rec cloudflare.DNSRecord) cloudflare.DNSRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addRecord(zoneID, rec)
+}
+
+func (s *Server) addRecord(zoneID string, rec cloudflare.DNSRecord) cloudflare.DNSRecord {
+	if rec.ID == "" {
+		rec.ID = s.newID("record")
+	}
+	if rec.CreatedOn.IsZero() {
+		rec.CreatedOn = time.Now().UTC()
+	}
+	rec.ModifiedOn = rec.CreatedOn
+	s.records[zoneID] = append(s.records[zoneID], rec)
+	return rec
+}
+
+// Records returns the zone's records.
+func (s *Server) Records(zoneID string) []cloudflare.DNSRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]cloudflare.DNSRecord(nil), s.records[zoneID]...)
+}
+
+// Calls returns the requests made so far, as "METHOD /path".
+func (s *Server) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+// FailNext makes the next request fail with the given HTTP status and
+// Cloudflare error code. Calls queue up, failing successive requests.
+func (s *Server) FailNext(status, code int, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{status: status, code: code, message: message})
+}
+
+func (s *Server) newID(kind string) string {
+	s.nextID++
+	return fmt.Sprintf("%s%04d", kind, s.nextID)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, r.Method+" "+r.URL.Path)
+
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		writeError(w, f.status, f.code, f.message)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/client/v4/")
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNoRoute, "No route for that URI")
+		return
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "user/tokens/verify" && r.Method == http.MethodGet:
+		writeResult(w, map[string]string{"id": "cffake", "status": "active"})
+	case path == "zones" && r.Method == http.MethodGet:
+		s.listZones(w, r)
+	case len(parts) == 3 && parts[0] == "zones" && parts[2] == "dns_records":
+		if !s.hasZone(parts[1]) {
+			writeError(w, http.StatusNotFound, codeNoRoute, "Could not route to /zones/"+parts[1])
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.listRecords(w, r, parts[1])
+		case http.MethodPost:
+			s.createRecord(w, r, parts[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, 10000, "Method not allowed")
+		}
+	case len(parts) == 4 && parts[0] == "zones" && parts[2] == "dns_records":
+		if !s.hasZone(parts[1]) {
+			writeError(w, http.StatusNotFound, codeNoRoute, "Could not route to /zones/"+parts[1])
+			return
+		}
+		s.record(w, r, parts[1], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, codeNoRoute, "No route for that URI")
+	}
+}
+
+func (s *Server) hasZone(id string) bool {
+	for _, z := range s.zones {
+		if z.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) listZones(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	zones := []cloudflare.Zone{}
+	for _, z := range s.zones {
+		if name == "" || z.Name == name {
+			zones = append(zones, z)
+		}
+	}
+	writeList(w, zones, len(zones))
+}
+
+// listRecords filters by name and type, and ignores pagination since the
+// fake never has more than a page of records.
+func (s *Server) listRecords(w http.ResponseWriter, r *http.Request, zoneID string) {
+	q := r.URL.Query()
+	records := []cloudflare.DNSRecord{}
+	for _, rec := range s.records[zoneID] {
+		if (q.Get("name") == "" || rec.Name == q.Get("name")) && (q.Get("type") == "" || rec.Type == q.Get("type")) {
+			records = append(records, rec)
+		}
+	}
+	writeList(w, records, len(records))
+}
+
+func (s *Server) createRecord(w http.ResponseWriter, r *http.Request, zoneID string) {
+	var rec cloudflare.DNSRecord
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		writeError(w, http.StatusBadRequest, 9207, "Request body is invalid: "+err.Error())
+		return
+	}
+	if rec.Name == "" || rec.Type == "" || rec.Content == "" {
+		writeError(w, http.StatusBadRequest, 9000, "DNS records need a name, type and content")
+		return
+	}
+	rec.ID = ""
+	rec.CreatedOn = time.Time{}
+	writeResult(w, s.addRecord(zoneID, rec))
+}
+
+// record handles a single record. Updates only change the fields present
+// in the request, as Cloudflare's PATCH does.
+func (s *Server) record(w http.ResponseWriter, r *http.Request, zoneID, id string) {
+	records := s.records[zoneID]
+	i := 0
+	for i < len(records) && records[i].ID != id {
+		i++
+	}
+	if i == len(records) {
+		writeError(w, http.StatusNotFound, codeRecordNotFound, "Record does not exist.")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeResult(w, records[i])
+	case http.MethodPatch, http.MethodPut:
+		var changes map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeError(w, http.StatusBadRequest, 9207, "Request body is invalid: "+err.Error())
+			return
+		}
+		current, _ := json.Marshal(records[i])
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(current, &fields)
+		for k, v := range changes {
+			if string(v) != "null" {
+				fields[k] = v
+			}
+		}
+		merged, _ := json.Marshal(fields)
+		var updated cloudflare.DNSRecord
+		if err := json.Unmarshal(merged, &updated); err != nil {
+			writeError(w, http.StatusBadRequest, 9207, "Request body is invalid: "+err.Error())
+			return
+		}
+		updated.ID = id
+		updated.ModifiedOn = time.Now().UTC()
+		records[i] = updated
+		writeResult(w, updated)
+	case http.MethodDelete:
+		s.records[zoneID] = append(records[:i], records[i+1:]...)
+		writeResult(w, map[string]string{"id": id})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, 10000, "Method not allowed")
+	}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":  true,
+		"errors":   []any{},
+		"messages": []any{},
+		"result":   result,
+	})
+}
+
+// writeList writes a single page of count results.
+func writeList(w http.ResponseWriter, result any, count int) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"success":  true,
+		"errors":   []any{},
+		"messages": []any{},
+		"result":   result,
+		"result_info": map[string]int{
+			"page":        1,
+			"per_page":    100,
+			"count":       count,
+			"total_count": count,
+			"total_pages": 1,
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeJSON(w, status, map[string]any{
+		"success":  false,
+		"errors":   []map[string]any{{"code": code, "message": message}},
+		"messages": []any{},
+		"result":   nil,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
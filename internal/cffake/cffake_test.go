@@ -0,0 +1,295 @@
+package cffake_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/cffake"
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/updater"
+)
+
+// Zone IDs are cached by name for the life of the process, so each test
+// uses a zone of its own.
+
+func newUpdater(fake *cffake.Server, zone, host, multiple string) *updater.Updater {
+	return updater.New(updater.Config{
+		Account:         fake.Account(zone),
+		Host:            host,
+		RecordType:      "A",
+		MultipleRecords: multiple,
+	})
+}
+
+func contents(records []cloudflare.DNSRecord) []string {
+	var ips []string
+	for _, rec := range records {
+		ips = append(ips, rec.Content)
+	}
+	slices.Sort(ips)
+	return ips
+}
+
+func countCalls(calls []string, call string) int {
+	n := 0
+	for _, c := range calls {
+		if c == call {
+			n++
+		}
+	}
+	return n
+}
+
+func TestCreate(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("create.example")
+
+	u := newUpdater(fake, "create.example", "home.create.example", "")
+	change, err := u.UpdateHost(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := updater.Change{Zone: "create.example", Host: "home.create.example", NewIP: "192.0.2.1"}
+	if change == nil || *change != want {
+		t.Errorf("change = %+v, want %+v", change, want)
+	}
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].Name != "home.create.example" || records[0].Type != "A" || records[0].Content != "192.0.2.1" {
+		t.Errorf("records = %+v, want one A record for 192.0.2.1", records)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("update.example")
+	rec := fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.update.example", Type: "A", Content: "192.0.2.1", TTL: 120})
+
+	u := newUpdater(fake, "update.example", "home.update.example", "")
+	change, err := u.UpdateHost(context.Background(), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := updater.Change{Zone: "update.example", Host: "home.update.example", OldIP: "192.0.2.1", NewIP: "192.0.2.2"}
+	if change == nil || *change != want {
+		t.Errorf("change = %+v, want %+v", change, want)
+	}
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].ID != rec.ID || records[0].Content != "192.0.2.2" {
+		t.Fatalf("records = %+v, want %s updated to 192.0.2.2", records, rec.ID)
+	}
+	if records[0].TTL != 120 {
+		t.Errorf("TTL = %d, want 120 kept", records[0].TTL)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("unchanged.example")
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.unchanged.example", Type: "A", Content: "192.0.2.1"})
+
+	u := newUpdater(fake, "unchanged.example", "home.unchanged.example", "")
+	for range 2 {
+		change, err := u.UpdateHost(context.Background(), "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change != nil {
+			t.Errorf("change = %+v, want none", change)
+		}
+	}
+	for _, call := range fake.Calls() {
+		if call != "GET /client/v4/zones" && call != "GET /client/v4/zones/"+zoneID+"/dns_records" {
+			t.Errorf("unexpected call %s for an unchanged record", call)
+		}
+	}
+}
+
+func TestZoneRecreated(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	oldID := fake.AddZone("recreated.example")
+
+	u := newUpdater(fake, "recreated.example", "home.recreated.example", "")
+	if _, err := u.UpdateHost(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	fake.RemoveZone(oldID)
+	newID := fake.AddZone("recreated.example")
+
+	change, err := u.UpdateHost(context.Background(), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil || change.NewIP != "192.0.2.2" {
+		t.Errorf("change = %+v, want the record created again", change)
+	}
+	if got := contents(fake.Records(newID)); !slices.Equal(got, []string{"192.0.2.2"}) {
+		t.Errorf("records in the new zone = %v, want [192.0.2.2]", got)
+	}
+	if n := countCalls(fake.Calls(), "GET /client/v4/zones"); n != 2 {
+		t.Errorf("zone looked up %d times, want 2", n)
+	}
+}
+
+func TestRecordGoneKeepsZone(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("gone.example")
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.gone.example", Type: "A", Content: "192.0.2.1"})
+
+	u := updater.New(updater.Config{
+		Account:          fake.Account("gone.example"),
+		Host:             "home.gone.example",
+		RecordType:       "A",
+		RecordRevalidate: time.Hour,
+	})
+	if _, err := u.UpdateHost(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// the update of the cached record finds it gone, so it is listed
+	// again, but the zone ID is still good
+	fake.FailNext(http.StatusNotFound, 81044, "Record does not exist.")
+	for _, ip := range []string{"192.0.2.2", "192.0.2.3"} {
+		if _, err := u.UpdateHost(context.Background(), ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := contents(fake.Records(zoneID)); !slices.Equal(got, []string{"192.0.2.3"}) {
+		t.Errorf("records = %v, want [192.0.2.3]", got)
+	}
+	if n := countCalls(fake.Calls(), "GET /client/v4/zones"); n != 1 {
+		t.Errorf("zone looked up %d times, want 1", n)
+	}
+}
+
+func TestMultipleRecordsError(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("error.example")
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.error.example", Type: "A", Content: "192.0.2.1"})
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.error.example", Type: "A", Content: "192.0.2.2"})
+
+	u := newUpdater(fake, "error.example", "home.error.example", "error")
+	_, err := u.UpdateHost(context.Background(), "192.0.2.3")
+	if !errors.Is(err, updater.ErrTooManyRecords) {
+		t.Errorf("error = %v, want ErrTooManyRecords", err)
+	}
+	if got := contents(fake.Records(zoneID)); !slices.Equal(got, []string{"192.0.2.1", "192.0.2.2"}) {
+		t.Errorf("records = %v, want them left alone", got)
+	}
+}
+
+func TestMultipleRecordsUpdateAll(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("all.example")
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.all.example", Type: "A", Content: "192.0.2.1"})
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.all.example", Type: "A", Content: "192.0.2.2"})
+	correct := fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.all.example", Type: "A", Content: "192.0.2.3"})
+
+	u := newUpdater(fake, "all.example", "home.all.example", "update-all")
+	change, err := u.UpdateHost(context.Background(), "192.0.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil || change.NewIP != "192.0.2.3" {
+		t.Errorf("change = %+v, want a change to 192.0.2.3", change)
+	}
+	if got := contents(fake.Records(zoneID)); !slices.Equal(got, []string{"192.0.2.3", "192.0.2.3", "192.0.2.3"}) {
+		t.Errorf("records = %v, want all three pointing at 192.0.2.3", got)
+	}
+	if n := countCalls(fake.Calls(), "PATCH /client/v4/zones/"+zoneID+"/dns_records/"+correct.ID); n != 0 {
+		t.Errorf("the already correct record was updated %d times", n)
+	}
+}
+
+func TestMultipleRecordsConsolidate(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("consolidate.example")
+	now := time.Now().UTC()
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.consolidate.example", Type: "A", Content: "192.0.2.1", CreatedOn: now})
+	oldest := fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.consolidate.example", Type: "A", Content: "192.0.2.2", CreatedOn: now.Add(-time.Hour)})
+
+	u := newUpdater(fake, "consolidate.example", "home.consolidate.example", "consolidate")
+	change, err := u.UpdateHost(context.Background(), "192.0.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change == nil || change.OldIP != "192.0.2.2" || change.NewIP != "192.0.2.3" {
+		t.Errorf("change = %+v, want the oldest record changed from 192.0.2.2", change)
+	}
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].ID != oldest.ID || records[0].Content != "192.0.2.3" {
+		t.Errorf("records = %+v, want only %s, pointing at 192.0.2.3", records, oldest.ID)
+	}
+}
+
+func TestConsolidateKeepsCorrectRecord(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	zoneID := fake.AddZone("keep.example")
+	now := time.Now().UTC()
+	fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.keep.example", Type: "A", Content: "192.0.2.1", CreatedOn: now.Add(-time.Hour)})
+	correct := fake.AddRecord(zoneID, cloudflare.DNSRecord{Name: "home.keep.example", Type: "A", Content: "192.0.2.2", CreatedOn: now})
+
+	u := newUpdater(fake, "keep.example", "home.keep.example", "consolidate")
+	change, err := u.UpdateHost(context.Background(), "192.0.2.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		t.Errorf("change = %+v, want none since a record was already correct", change)
+	}
+	records := fake.Records(zoneID)
+	if len(records) != 1 || records[0].ID != correct.ID {
+		t.Errorf("records = %+v, want only %s", records, correct.ID)
+	}
+}
+
+func TestZoneIDAliasRecreated(t *testing.T) {
+	fake := cffake.New()
+	defer fake.Close()
+	mainID := fake.AddZone("main.example")
+	oldID := fake.AddZone("alias.example")
+
+	account := fake.Account("main.example")
+	account.ZoneID = mainID
+	p, err := cfprovider.New(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliasAccount := fake.Account("alias.example")
+	alias := updater.New(updater.Config{Account: aliasAccount, Host: "home.alias.example", RecordType: "A"}, updater.WithProvider(p))
+	if _, err := alias.UpdateHost(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	fake.RemoveZone(oldID)
+	newID := fake.AddZone("alias.example")
+
+	// the configured zone ID only covers the main zone, so the alias's
+	// zone is still resolved again
+	if _, err := alias.UpdateHost(context.Background(), "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(fake.Records(newID)); !slices.Equal(got, []string{"192.0.2.2"}) {
+		t.Errorf("records in the new alias zone = %v, want [192.0.2.2]", got)
+	}
+
+	host := updater.New(updater.Config{Account: account, Host: "home.main.example", RecordType: "A"}, updater.WithProvider(p))
+	if _, err := host.UpdateHost(context.Background(), "192.0.2.2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(fake.Records(mainID)); !slices.Equal(got, []string{"192.0.2.2"}) {
+		t.Errorf("records in the main zone = %v, want [192.0.2.2]", got)
+	}
+}
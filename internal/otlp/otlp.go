@@ -0,0 +1,163 @@
+// Package otlp exports traces and metrics to an OpenTelemetry collector
+// using OTLP over HTTP with JSON encoding.
+package otlp
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultEndpoint is the standard local collector address for OTLP over
+// HTTP.
+const defaultEndpoint = "http://localhost:4318"
+
+// Exporter sends one OpenTelemetry signal to a collector using OTLP over
+// HTTP with JSON encoding, configured by the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+type Exporter struct {
+	url     string
+	headers http.Header
+	client  *http.Client
+	version string
+}
+
+// NewExporter configures an exporter for signal ("metrics" or "traces")
+// from a program at version, preferring the signal specific variables as
+// the specification requires.
+func NewExporter(signal string, client *http.Client, version string) (*Exporter, error) {
+	upper := strings.ToUpper(signal)
+	protocol := cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_"+upper+"_PROTOCOL"), os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"), "http/json")
+	if protocol != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %s is not supported, only http/json", protocol)
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_" + upper + "_ENDPOINT")
+	if endpoint == "" {
+		endpoint = strings.TrimSuffix(cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), defaultEndpoint), "/") + "/v1/" + signal
+	}
+	if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("OTLP endpoint must be an http(s) URL (got %s)", endpoint)
+	}
+	headers := http.Header{}
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_" + upper + "_HEADERS"} {
+		pairs, err := keyValues(os.Getenv(env))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", env, err)
+		}
+		for _, kv := range pairs {
+			headers.Set(kv[0], kv[1])
+		}
+	}
+	if timeout, err := strconv.Atoi(cmp.Or(os.Getenv("OTEL_EXPORTER_OTLP_"+upper+"_TIMEOUT"), os.Getenv("OTEL_EXPORTER_OTLP_TIMEOUT"))); err == nil {
+		client.Timeout = time.Duration(timeout) * time.Millisecond
+	} else {
+		client.Timeout = 10 * time.Second
+	}
+	return &Exporter{url: endpoint, headers: headers, client: client, version: version}, nil
+}
+
+// keyValues parses the comma separated key=value lists used by the OTEL_*
+// variables, whose values are URL encoded.
+func keyValues(s string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not key=value", item)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(key), value})
+	}
+	return pairs, nil
+}
+
+// Timeout returns how long an export may take.
+func (e *Exporter) Timeout() time.Duration {
+	return e.client.Timeout
+}
+
+// Export posts an OTLP JSON request body.
+func (e *Exporter) Export(ctx context.Context, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("cfdnsupdater/%s", e.version))
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected HTTP status %s from OTLP collector", res.Status)
+	}
+	return nil
+}
+
+// OTLP JSON types, covering just what we send.
+type (
+	AnyValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	KeyValue struct {
+		Key   string   `json:"key"`
+		Value AnyValue `json:"value"`
+	}
+	Resource struct {
+		Attributes []KeyValue `json:"attributes"`
+	}
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+)
+
+// Attribute returns a string valued attribute.
+func Attribute(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: value}}
+}
+
+// ResourceAttributes describes this process, from OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES.
+func (e *Exporter) ResourceAttributes() Resource {
+	attrs := map[string]string{"service.version": e.version}
+	// errors were already reported when the exporter was set up
+	pairs, _ := keyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	for _, kv := range pairs {
+		attrs[kv[0]] = kv[1]
+	}
+	attrs["service.name"] = cmp.Or(os.Getenv("OTEL_SERVICE_NAME"), attrs["service.name"], "cfdnsupdater")
+	var resource Resource
+	for key, value := range attrs {
+		resource.Attributes = append(resource.Attributes, Attribute(key, value))
+	}
+	return resource
+}
+
+// Time formats a time as the string encoded nanoseconds OTLP JSON uses for
+// 64 bit integers.
+func Time(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
@@ -0,0 +1,181 @@
+package otlp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// OTLP span kinds and status codes.
+const (
+	SpanKindInternal = 1
+	SpanKindClient   = 3
+	statusError      = 2
+)
+
+// traceExportTimeout bounds exporting the spans of one cycle.
+const traceExportTimeout = 10 * time.Second
+
+// Tracing is set when traces are exported over OTLP. Spans are only
+// recorded if it is.
+var Tracing *Tracer
+
+// Tracer collects finished spans and exports each trace once its root span
+// ends, which is the end of an update cycle.
+type Tracer struct {
+	exporter *Exporter
+	pending  sync.WaitGroup
+
+	mu    sync.Mutex
+	spans map[string][]span
+}
+
+func NewTracer(exporter *Exporter) *Tracer {
+	return &Tracer{exporter: exporter, spans: map[string][]span{}}
+}
+
+type (
+	status struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	span struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []KeyValue `json:"attributes,omitempty"`
+		Status            status     `json:"status"`
+	}
+	scopeSpans struct {
+		Scope Scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+	resourceSpans struct {
+		Resource   Resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	tracesRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+)
+
+// Span is an operation being traced. A nil Span is valid and does nothing,
+// so callers needn't check whether tracing is enabled.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	id       string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+	attrs    []KeyValue
+}
+
+type spanKey struct{}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// StartSpan starts a span as a child of the one in ctx, if any, and
+// returns a context carrying it. Attributes are given as key, value pairs.
+func StartSpan(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	return StartSpanKind(ctx, name, SpanKindInternal, attrs...)
+}
+
+func StartSpanKind(ctx context.Context, name string, kind int, attrs ...string) (context.Context, *Span) {
+	if Tracing == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: Tracing, id: randomHex(8), name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.id
+	} else {
+		s.traceID = randomHex(16)
+	}
+	s.Set(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Set adds key, value attribute pairs to the span.
+func (s *Span) Set(attrs ...string) {
+	if s == nil {
+		return
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs = append(s.attrs, Attribute(attrs[i], attrs[i+1]))
+	}
+}
+
+// End finishes the span, marking it failed if err is not nil. Ending the
+// root span exports the whole trace.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	done := span{
+		TraceID:           s.traceID,
+		SpanID:            s.id,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: Time(s.start),
+		EndTimeUnixNano:   Time(time.Now()),
+		Attributes:        s.attrs,
+	}
+	if err != nil {
+		done.Status = status{Code: statusError, Message: err.Error()}
+	}
+	t := s.tracer
+	t.mu.Lock()
+	t.spans[s.traceID] = append(t.spans[s.traceID], done)
+	var trace []span
+	if s.parentID == "" {
+		trace = t.spans[s.traceID]
+		delete(t.spans, s.traceID)
+	}
+	t.mu.Unlock()
+	if trace != nil {
+		t.pending.Add(1)
+		go func() {
+			defer t.pending.Done()
+			t.export(trace)
+		}()
+	}
+}
+
+// Flushed returns a channel that is closed once traces being exported have
+// been sent.
+func (t *Tracer) Flushed() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		t.pending.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func (t *Tracer) export(spans []span) {
+	ctx, cancel := context.WithTimeout(context.Background(), traceExportTimeout)
+	defer cancel()
+	err := t.exporter.Export(ctx, tracesRequest{ResourceSpans: []resourceSpans{{
+		Resource: t.exporter.ResourceAttributes(),
+		ScopeSpans: []scopeSpans{{
+			Scope: Scope{Name: "cfdnsupdater", Version: t.exporter.version},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		slog.Warn("Failed to export trace over OTLP", "error", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Package redact scrubs the credentials we were configured with from log
+// output, errors and the audit log.
+package redact
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// redactor replaces the credentials we were configured with. It is set up
+// before logging starts and never changed afterwards.
+var redactor = strings.NewReplacer()
+
+// minSecretLength is the shortest value redacted. Shorter ones can't be
+// real credentials and would mangle every log line they appear in.
+const minSecretLength = 6
+
+// Secrets arranges for secrets to be scrubbed from log output and cycle
+// errors.
+func Secrets(secrets ...string) {
+	var pairs []string
+	for _, s := range secrets {
+		if len(s) >= minSecretLength {
+			pairs = append(pairs, s, redacted)
+		}
+	}
+	redactor = strings.NewReplacer(pairs...)
+}
+
+// String returns s with any secrets replaced.
+func String(s string) string {
+	return redactor.Replace(s)
+}
+
+// Attr is a slog ReplaceAttr function scrubbing secrets from string, error
+// and other values rendered as text.
+func Attr(_ []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(String(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(String(v.Error()))
+		case fmt.Stringer:
+			a.Value = slog.StringValue(String(v.String()))
+		}
+	}
+	return a
+}
+
+// redactedError scrubs secrets from the message of an error, which may
+// quote a request or response that contained one, while still unwrapping
+// to it.
+type redactedError struct {
+	err error
+}
+
+// Error wraps err so that its message has secrets scrubbed.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err}
+}
+
+func (e *redactedError) Error() string {
+	return String(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,98 @@
+// Package cfprovider implements provider.Provider for Cloudflare, holding
+// off while Cloudflare is rate limiting us and caching zone IDs.
+package cfprovider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Account holds the Cloudflare credentials, how to reach the API and the
+// zone to work in.
+type Account struct {
+	Zone   string
+	Email  string
+	ApiKey string
+	// ApiToken is a scoped API token, used instead of Email and ApiKey.
+	ApiToken string
+	// ApiTokenFile is the path of a file holding the API token, read
+	// whenever an API client is made so a replaced token is picked up.
+	ApiTokenFile string
+	// Proxy is an http(s):// or socks5:// URL used for all outbound
+	// requests. If empty, the standard proxy environment variables apply.
+	Proxy string
+	// APIBaseURL overrides the Cloudflare API endpoint, for mock servers
+	// in tests, egress proxies or the China network.
+	APIBaseURL string
+	// ZoneID skips looking Zone up by name, for tokens that can't list
+	// zones. AccountID restricts the lookup to one account.
+	ZoneID    string
+	AccountID string
+}
+
+// ProxyTransport returns a copy of the default transport using proxy, or
+// the proxy environment variables if it is empty. The URL is assumed to
+// have been validated already.
+func ProxyTransport(proxy string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, _ := url.Parse(proxy)
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport
+}
+
+// NewAPI creates a Cloudflare API client for the account's credentials,
+// going through its proxy if there is one, and holding off while
+// Cloudflare is rate limiting us.
+func NewAPI(account Account) (*cloudflare.API, error) {
+	client := &http.Client{Transport: rateLimitTransport{next: timedTransport{next: ProxyTransport(account.Proxy)}}}
+	opts := []cloudflare.Option{cloudflare.HTTPClient(client)}
+	if account.APIBaseURL != "" {
+		opts = append(opts, cloudflare.BaseURL(strings.TrimSuffix(account.APIBaseURL, "/")))
+	}
+	if account.ApiTokenFile != "" {
+		token, err := ReadTokenFile(account.ApiTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return cloudflare.NewWithAPIToken(token, opts...)
+	}
+	if account.ApiToken != "" {
+		return cloudflare.NewWithAPIToken(account.ApiToken, opts...)
+	}
+	return cloudflare.New(account.ApiKey, account.Email, opts...)
+}
+
+// ReadTokenFile reads an API token from a file, ignoring surrounding
+// whitespace.
+func ReadTokenFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading API token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// recordNotFoundErrorCode is returned when updating a record that was
+// deleted behind our back.
+const recordNotFoundErrorCode = 81044
+
+// IsRecordNotFound reports whether err means the record no longer exists.
+func IsRecordNotFound(err error) bool {
+	var cfErr *cloudflare.Error
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	return cfErr.Type == cloudflare.ErrorTypeNotFound || cfErr.InternalErrorCodeIs(recordNotFoundErrorCode)
+}
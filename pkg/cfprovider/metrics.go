@@ -0,0 +1,70 @@
+package cfprovider
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+)
+
+var cloudflareDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_cloudflare_request_duration_seconds",
+	Help:    "How long Cloudflare API requests take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// cloudflareOperation names the API operation a request performs, keeping
+// IDs out of the metric labels.
+func cloudflareOperation(method, path string) string {
+	switch {
+	case strings.HasSuffix(path, "/tokens/verify"):
+		return "verify_token"
+	case strings.Contains(path, "/storage/kv/"):
+		return "write_kv"
+	case strings.HasSuffix(path, "/zones"):
+		return "list_zones"
+	case strings.HasSuffix(path, "/dns_records"):
+		if method == http.MethodPost {
+			return "create_record"
+		}
+		return "list_records"
+	case strings.Contains(path, "/dns_records/"):
+		switch method {
+		case http.MethodPatch, http.MethodPut:
+			return "update_record"
+		case http.MethodDelete:
+			return "delete_record"
+		}
+		return "get_record"
+	}
+	return "other"
+}
+
+// timedTransport records how long each Cloudflare API request takes.
+type timedTransport struct {
+	next http.RoundTripper
+}
+
+func (t timedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation := cloudflareOperation(req.Method, req.URL.Path)
+	_, span := otlp.StartSpanKind(req.Context(), "cloudflare "+operation, otlp.SpanKindClient,
+		"http.request.method", req.Method, "url.path", req.URL.Path)
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	cloudflareDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err == nil {
+		span.Set("http.response.status_code", strconv.Itoa(res.StatusCode))
+		if res.StatusCode >= http.StatusBadRequest {
+			span.End(errors.New(res.Status))
+			return res, err
+		}
+	}
+	span.End(err)
+	return res, err
+}
@@ -0,0 +1,121 @@
+package cfprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// Provider manages records through the Cloudflare API.
+type Provider struct {
+	api     *cloudflare.API
+	account Account
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// New returns a Provider using the account's credentials, see NewAPI.
+func New(account Account) (*Provider, error) {
+	api, err := NewAPI(account)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{api: api, account: account}, nil
+}
+
+// ResolveZone returns the configured zone ID for the account's zone, and
+// otherwise looks the zone up by name, caching the result.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	if p.account.ZoneID != "" && zone == p.account.Zone {
+		return p.account.ZoneID, nil
+	}
+	return cachedZoneID(ctx, p.api, zone, p.account.AccountID)
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	records, _, err := p.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{Name: name, Type: recordType})
+	if err != nil {
+		return nil, p.zoneError(zoneID, err)
+	}
+	recs := make([]provider.Record, len(records))
+	for i, r := range records {
+		recs[i] = convertRecord(r)
+	}
+	return recs, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	created, err := p.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams{
+		Name:    rec.Name,
+		Type:    rec.Type,
+		Content: rec.Content,
+		Comment: rec.Comment,
+	})
+	if err != nil {
+		return provider.Record{}, p.zoneError(zoneID, err)
+	}
+	return convertRecord(created), nil
+}
+
+// UpdateRecord changes the record's content and comment. Everything else
+// about a record read by GetRecord is copied across unchanged.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	params := cloudflare.UpdateDNSRecordParams{
+		ID:      rec.ID,
+		Type:    rec.Type,
+		Name:    rec.Name,
+		Content: rec.Content,
+		Comment: &rec.Comment,
+	}
+	if orig, ok := rec.Extra.(cloudflare.DNSRecord); ok {
+		params.TTL = orig.TTL
+		params.Proxied = orig.Proxied
+		params.Tags = orig.Tags
+		params.Settings = orig.Settings
+	}
+	updated, err := p.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), params)
+	if IsRecordNotFound(err) {
+		err = fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	if err != nil {
+		return provider.Record{}, p.zoneError(zoneID, err)
+	}
+	return convertRecord(updated), nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	err := p.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), rec.ID)
+	if err != nil {
+		return p.zoneError(zoneID, err)
+	}
+	return nil
+}
+
+// zoneError marks err with provider.ErrZoneChanged if it means the zone ID
+// we looked up is no longer valid, and forgets that ID so the zone is
+// resolved again. The configured zone ID is never looked up, so errors
+// using it are returned as they are, but other zones are still resolved.
+func (p *Provider) zoneError(zoneID string, err error) error {
+	if !IsInvalidZoneError(err) || zoneID == p.account.ZoneID {
+		return err
+	}
+	forgetZoneIDs(zoneID)
+	return fmt.Errorf("%w: %w", provider.ErrZoneChanged, err)
+}
+
+// convertRecord returns rec as a provider.Record, keeping the original in
+// Extra for updates.
+func convertRecord(rec cloudflare.DNSRecord) provider.Record {
+	return provider.Record{
+		ID:      rec.ID,
+		Name:    rec.Name,
+		Type:    rec.Type,
+		Content: rec.Content,
+		Comment: rec.Comment,
+		Created: rec.CreatedOn,
+		Extra:   rec,
+	}
+}
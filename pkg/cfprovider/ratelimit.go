@@ -0,0 +1,77 @@
+package cfprovider
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// defaultRateLimitDelay is how long we leave the API alone after a 429
+// response without a usable Retry-After header.
+const defaultRateLimitDelay = time.Minute
+
+var rateLimitedCalls = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "cfdnsupdater_cloudflare_rate_limited_total",
+	Help: "The number of Cloudflare API calls rejected by rate limiting",
+})
+
+var (
+	rateLimitMu      sync.Mutex
+	rateLimitedUntil time.Time
+)
+
+// RateLimited returns a provider.RateLimitError if we are still inside a period
+// Cloudflare asked us to back off for.
+func RateLimited() error {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	if time.Now().Before(rateLimitedUntil) {
+		return &provider.RateLimitError{Service: "Cloudflare", Until: rateLimitedUntil}
+	}
+	return nil
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return defaultRateLimitDelay
+}
+
+// rateLimitTransport notices 429 responses from the Cloudflare API and
+// fails any further requests without sending them until the Retry-After
+// time has passed. cloudflare-go retries 429s itself after fixed delays,
+// so this also stops those retries from making matters worse.
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := RateLimited(); err != nil {
+		return nil, err
+	}
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusTooManyRequests {
+		return res, err
+	}
+	now := time.Now()
+	until := now.Add(retryAfter(res.Header.Get("Retry-After"), now))
+	rateLimitMu.Lock()
+	rateLimitedUntil = until
+	rateLimitMu.Unlock()
+	rateLimitedCalls.Inc()
+	slog.WarnContext(req.Context(), "Rate limited by Cloudflare", "url.path", req.URL.Path, "until", until)
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package cfprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// Cloudflare error codes returned when a request names a zone ID that no
+// longer exists.
+var invalidZoneErrorCodes = []int{
+	1001, // Invalid zone identifier
+	7003, // Could not route to ..., perhaps your object identifier is invalid?
+}
+
+var (
+	zoneIDsMu sync.Mutex
+	zoneIDs   = map[string]string{}
+)
+
+// ResolveZoneID returns the account's zone ID, or looks the zone up by
+// name within the account, if one is given.
+func ResolveZoneID(ctx context.Context, api *cloudflare.API, account Account) (string, error) {
+	if account.ZoneID != "" {
+		return account.ZoneID, nil
+	}
+	return cachedZoneID(ctx, api, account.Zone, account.AccountID)
+}
+
+// cachedZoneID returns the ID of the named zone, looking it up only if it
+// isn't already known.
+func cachedZoneID(ctx context.Context, api *cloudflare.API, zone, accountID string) (string, error) {
+	zoneIDsMu.Lock()
+	defer zoneIDsMu.Unlock()
+	if id, ok := zoneIDs[zone]; ok {
+		return id, nil
+	}
+	id, err := zoneIDByName(ctx, api, zone, accountID)
+	if err != nil {
+		return "", err
+	}
+	zoneIDs[zone] = id
+	return id, nil
+}
+
+// zoneIDByName is api.ZoneIDByName, but honouring ctx. If accountID is
+// set, only zones in that account are searched.
+func zoneIDByName(ctx context.Context, api *cloudflare.API, zone, accountID string) (string, error) {
+	res, err := api.ListZonesContext(ctx, cloudflare.WithZoneFilters(zone, accountID, ""))
+	if err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	switch len(res.Result) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+	case 1:
+		return res.Result[0].ID, nil
+	default:
+		return "", fmt.Errorf("zone name %s is ambiguous", zone)
+	}
+}
+
+// forgetZoneIDs drops the cached zones with the given ID, so the next lookup
+// resolves them again.
+func forgetZoneIDs(id string) {
+	zoneIDsMu.Lock()
+	defer zoneIDsMu.Unlock()
+	for zone, cached := range zoneIDs {
+		if cached == id {
+			delete(zoneIDs, zone)
+		}
+	}
+}
+
+// IsInvalidZoneError reports whether err means the zone ID we used is no
+// longer valid. Other not found errors, such as for a record that has
+// gone, don't count.
+func IsInvalidZoneError(err error) bool {
+	var cfErr *cloudflare.Error
+	if !errors.As(err, &cfErr) {
+		return false
+	}
+	for _, code := range invalidZoneErrorCodes {
+		if cfErr.InternalErrorCodeIs(code) {
+			return true
+		}
+	}
+	return false
+}
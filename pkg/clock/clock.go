@@ -0,0 +1,22 @@
+// Package clock lets the time be replaced, so that intervals, backoff and
+// timeouts can be driven by tests instead of waiting for real time to pass.
+package clock
+
+import "time"
+
+// Clock tells the time and waits.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// System is the real time.
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+func (System) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
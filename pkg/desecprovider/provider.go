@@ -0,0 +1,352 @@
+// Package desecprovider implements provider.Provider for deSEC.
+package desecprovider
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+const (
+	defaultBaseURL = "https://desec.io/api/v1"
+	// defaultTTL is the TTL of records we create if none is configured,
+	// which is the lowest deSEC allows for most domains.
+	defaultTTL = 3600
+	// maxThrottleWait is the longest Retry-After we wait out before
+	// retrying a throttled request. deSEC throttles bursts for a second
+	// or two, but its hourly and daily limits ask for much longer, which
+	// we leave to the update loop.
+	maxThrottleWait = 10 * time.Second
+	// maxThrottleRetries is how many times we retry a throttled request.
+	maxThrottleRetries = 3
+	// defaultThrottleDelay is how long we wait after a 429 response
+	// without a usable Retry-After header.
+	defaultThrottleDelay = time.Second
+)
+
+var (
+	desecDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cfdnsupdater_desec_request_duration_seconds",
+		Help:    "How long deSEC API requests take, by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	throttledCalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cfdnsupdater_desec_rate_limited_total",
+		Help: "The number of deSEC API calls rejected by rate limiting",
+	})
+)
+
+// Config holds the API token.
+type Config struct {
+	Token string
+	// TTL is the TTL of records we create, an hour if zero.
+	TTL int
+	// BaseURL overrides the API endpoint, for mock servers.
+	BaseURL string
+	// HTTPClient makes API requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Clock times waits for throttled requests, the real time if nil.
+	Clock clock.Clock
+}
+
+// Provider manages records through the deSEC API, which works on whole
+// record sets. A zone's ID is its domain name and a record's ID is its name
+// and type; each value of a record set is a separate provider.Record. deSEC
+// records have no comments, so any comment is dropped.
+//
+// deSEC rate limits its API strictly. Throttled requests are retried after
+// short waits, and otherwise fail with a provider.RateLimitError.
+type Provider struct {
+	config Config
+	client *http.Client
+	clock  clock.Clock
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// APIError is an error returned by the deSEC API.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("deSEC returned %s", e.Status)
+	}
+	return fmt.Sprintf("deSEC returned %s: %s", e.Status, e.Message)
+}
+
+// New returns a Provider using the configured token.
+func New(config Config) (*Provider, error) {
+	if config.Token == "" {
+		return nil, errors.New("deSEC needs an API token")
+	}
+	p := &Provider{config: config, client: cmp.Or(config.HTTPClient, http.DefaultClient), clock: config.Clock}
+	if p.clock == nil {
+		p.clock = clock.System{}
+	}
+	return p, nil
+}
+
+// rrset is the API's form of a record set. Names are relative to the
+// domain, with an empty name for the apex. Updates send only the fields
+// being changed.
+type rrset struct {
+	Subname string   `json:"subname,omitempty"`
+	Type    string   `json:"type,omitempty"`
+	TTL     int      `json:"ttl,omitempty"`
+	Records []string `json:"records"`
+}
+
+// ResolveZone checks that the account holds the domain, which is its own
+// ID.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	err := p.call(ctx, "get_domain", http.MethodGet, "/domains/"+url.PathEscape(zone)+"/", nil, nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	return zone, nil
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	set, err := p.getRRSet(ctx, zoneID, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var recs []provider.Record
+	for _, value := range set.Records {
+		recs = append(recs, convertRecord(zoneID, name, recordType, set, value))
+	}
+	return recs, nil
+}
+
+// getRRSet returns the record set, which is empty if there isn't one.
+func (p *Provider) getRRSet(ctx context.Context, zoneID, name, recordType string) (rrset, error) {
+	var set rrset
+	err := p.call(ctx, "get_record", http.MethodGet, rrsetPath(zoneID, name, recordType), nil, &set)
+	if isNotFound(err) {
+		return rrset{}, nil
+	}
+	return set, err
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set := rrset{
+		Subname: subname(rec.Name, zoneID),
+		Type:    rec.Type,
+		TTL:     cmp.Or(p.config.TTL, defaultTTL),
+		Records: []string{encodeValue(rec.Type, rec.Content)},
+	}
+	if err := p.call(ctx, "create_record", http.MethodPost, "/domains/"+url.PathEscape(zoneID)+"/rrsets/", set, &set); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(zoneID, rec.Name, rec.Type, set, set.Records[0]), nil
+}
+
+// UpdateRecord replaces the record set's values with the record's
+// content, keeping its TTL.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set := rrset{Records: []string{encodeValue(rec.Type, rec.Content)}}
+	err := p.call(ctx, "update_record", http.MethodPatch, rrsetPath(zoneID, rec.Name, rec.Type), set, &set)
+	if isNotFound(err) {
+		return provider.Record{}, fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	if err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(zoneID, rec.Name, rec.Type, set, set.Records[0]), nil
+}
+
+// DeleteRecord removes the record's value from its record set, which
+// deletes the set if it was the last one. The set is read again first,
+// since other values may have been removed since rec was read.
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	set, err := p.getRRSet(ctx, zoneID, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(set.Records, rec.Content)
+	if i < 0 {
+		return fmt.Errorf("%w: %s %s %s", provider.ErrRecordNotFound, rec.Name, rec.Type, rec.Content)
+	}
+	update := rrset{Records: slices.Delete(set.Records, i, i+1)}
+	err = p.call(ctx, "delete_record", http.MethodPatch, rrsetPath(zoneID, rec.Name, rec.Type), update, nil)
+	if isNotFound(err) {
+		return fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	return err
+}
+
+// call makes a request to the API for operation, sending in as JSON if it
+// isn't nil and decoding the JSON response into result if that isn't nil.
+// Throttled requests are retried if deSEC asks us to wait only briefly.
+func (p *Provider) call(ctx context.Context, operation, method, path string, in, result any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		b, wait, err := p.do(ctx, operation, method, path, body)
+		if wait > 0 {
+			throttledCalls.Inc()
+			if wait > maxThrottleWait || attempt > maxThrottleRetries {
+				until := p.clock.Now().Add(wait)
+				slog.WarnContext(ctx, "Rate limited by deSEC", "url.path", path, "until", until)
+				return &provider.RateLimitError{Service: "deSEC", Until: until}
+			}
+			slog.DebugContext(ctx, "Throttled by deSEC, waiting", "url.path", path, "delay", wait)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-p.clock.After(wait):
+			}
+			continue
+		}
+		if err != nil || result == nil || len(b) == 0 {
+			return err
+		}
+		if err := json.Unmarshal(b, result); err != nil {
+			return fmt.Errorf("invalid deSEC response: %w", err)
+		}
+		return nil
+	}
+}
+
+// do makes a single request, returning the response body, or how long to
+// wait if it was throttled.
+func (p *Provider) do(ctx context.Context, operation, method, path string, body []byte) ([]byte, time.Duration, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cmp.Or(p.config.BaseURL, defaultBaseURL), "/")+path, r)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Authorization", "Token "+p.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	_, span := otlp.StartSpanKind(ctx, "desec "+operation, otlp.SpanKindClient, "http.request.method", method)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	desecDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err == nil && (res.StatusCode < 200 || res.StatusCode > 299) {
+		err = parseError(res, b)
+	}
+	span.End(err)
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, retryAfter(res.Header.Get("Retry-After")), err
+	}
+	return b, 0, err
+}
+
+// parseError decodes an error response, whose message is in detail, or for
+// validation errors a list of messages by field.
+func parseError(res *http.Response, body []byte) error {
+	var doc struct {
+		Detail string `json:"detail"`
+	}
+	message := ""
+	if json.Unmarshal(body, &doc) == nil && doc.Detail != "" {
+		message = doc.Detail
+	} else if len(body) > 0 && len(body) < 1000 {
+		message = strings.TrimSpace(string(body))
+	}
+	return &APIError{StatusCode: res.StatusCode, Status: res.Status, Message: message}
+}
+
+// retryAfter parses a Retry-After header, which deSEC sends as a number of
+// seconds.
+func retryAfter(header string) time.Duration {
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultThrottleDelay
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// rrsetPath is the API path of a record set, which names the apex @.
+func rrsetPath(zone, name, recordType string) string {
+	return fmt.Sprintf("/domains/%s/rrsets/%s/%s/", url.PathEscape(zone), url.PathEscape(cmp.Or(subname(name, zone), "@")), url.PathEscape(recordType))
+}
+
+// subname returns name relative to zone, as the API names records, with
+// the apex as an empty string.
+func subname(name, zone string) string {
+	name, zone = strings.TrimSuffix(name, "."), strings.TrimSuffix(zone, ".")
+	if strings.EqualFold(name, zone) {
+		return ""
+	}
+	if len(name) > len(zone) && strings.EqualFold(name[len(name)-len(zone)-1:], "."+zone) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// encodeValue quotes TXT record content, splitting it into strings of at
+// most 255 characters, unless it is already quoted, as deSEC keeps it.
+// Other types are written as they are.
+func encodeValue(recordType, content string) string {
+	if recordType != "TXT" || strings.HasPrefix(content, `"`) {
+		return content
+	}
+	var parts []string
+	for {
+		n := min(len(content), 255)
+		part := strings.ReplaceAll(content[:n], `\`, `\\`)
+		parts = append(parts, `"`+strings.ReplaceAll(part, `"`, `\"`)+`"`)
+		content = content[n:]
+		if content == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// convertRecord returns one value of the record set as a provider.Record,
+// keeping the set in Extra.
+func convertRecord(zone, name, recordType string, set rrset, value string) provider.Record {
+	return provider.Record{
+		ID:      cmp.Or(subname(name, zone), "@") + " " + recordType,
+		Name:    strings.TrimSuffix(name, "."),
+		Type:    recordType,
+		Content: value,
+		Extra:   set,
+	}
+}
@@ -0,0 +1,267 @@
+// Package dyndnsprovider implements provider.Provider for dynamic DNS
+// services using the dyndns2 update protocol, and for DuckDNS.
+package dyndnsprovider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+const (
+	defaultDuckDNSURL = "https://www.duckdns.org/update"
+	// serverErrorDelay is how long the dyndns2 protocol asks clients to
+	// wait after the server reports a problem of its own.
+	serverErrorDelay = 30 * time.Minute
+)
+
+var dyndnsDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_dyndns_request_duration_seconds",
+	Help:    "How long dynamic DNS update requests take, by protocol",
+	Buckets: prometheus.DefBuckets,
+}, []string{"protocol"})
+
+// Config describes the update service. If DuckDNSToken is set, DuckDNS is
+// updated, and otherwise the dyndns2 server at UpdateURL.
+type Config struct {
+	// UpdateURL is the dyndns2 update URL, such as
+	// https://members.dyndns.org/nic/update. For DuckDNS it overrides the
+	// default, for mock servers.
+	UpdateURL string
+	Username  string
+	Password  string
+	// DuckDNSToken is the DuckDNS account token.
+	DuckDNSToken string
+	// UserAgent identifies us to the server, which the dyndns2 protocol
+	// requires.
+	UserAgent string
+	// HTTPClient makes update requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Clock times the wait after server errors, the real time if nil.
+	Clock clock.Clock
+}
+
+// Provider updates A and AAAA records with simple update requests. The
+// protocols can't read records back, so GetRecord returns what we last
+// sent, and the first update after starting is always sent. A zone's ID is
+// its name, and only affects how DuckDNS names are shortened. Records have
+// no comments, TTLs or IDs of their own.
+//
+// The dyndns2 protocol forbids clients to retry updates the server has
+// refused, so after a refusal every update fails without being sent until
+// the daemon is restarted.
+type Provider struct {
+	config Config
+	client *http.Client
+	clock  clock.Clock
+
+	mu sync.Mutex
+	// sent holds the last record sent for each name and type
+	sent map[string]provider.Record
+	// refused is the server's refusal, once it has refused an update
+	refused error
+}
+
+// UpdateError is a refusal from the update server.
+type UpdateError struct {
+	// Code is the server's return code, such as badauth or nohost.
+	Code string
+}
+
+func (e *UpdateError) Error() string {
+	switch e.Code {
+	case "badauth":
+		return "update refused: bad username or password"
+	case "nohost", "!yours":
+		return "update refused: the host doesn't exist or belongs to another account"
+	case "notfqdn":
+		return "update refused: the host isn't a fully qualified domain name"
+	case "numhost":
+		return "update refused: too many hosts"
+	case "abuse":
+		return "update refused: the host is blocked for abuse"
+	case "badagent":
+		return "update refused: the user agent was rejected"
+	case "KO":
+		return "update refused: bad DuckDNS token or domain"
+	}
+	return fmt.Sprintf("update refused: %s", e.Code)
+}
+
+// New returns a Provider for the configured service.
+func New(config Config) (*Provider, error) {
+	if config.DuckDNSToken == "" && (config.UpdateURL == "" || config.Username == "" || config.Password == "") {
+		return nil, errors.New("dyndns2 needs an update URL, username and password")
+	}
+	p := &Provider{
+		config: config,
+		client: cmp.Or(config.HTTPClient, http.DefaultClient),
+		clock:  config.Clock,
+		sent:   map[string]provider.Record{},
+	}
+	if p.clock == nil {
+		p.clock = clock.System{}
+	}
+	return p, nil
+}
+
+// ResolveZone returns the zone's name, which is its ID.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	return zone, nil
+}
+
+// GetRecord returns the record we last sent for the name and type, if
+// any.
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rec, ok := p.sent[recordID(name, recordType)]; ok {
+		return []provider.Record{rec}, nil
+	}
+	return nil, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	return p.update(ctx, zoneID, rec)
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	return p.update(ctx, zoneID, rec)
+}
+
+// update sends an update request for rec.
+func (p *Provider) update(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	if rec.Type != "A" && rec.Type != "AAAA" {
+		return provider.Record{}, fmt.Errorf("%s records can't be set by dynamic DNS updates", rec.Type)
+	}
+	p.mu.Lock()
+	refused := p.refused
+	p.mu.Unlock()
+	if refused != nil {
+		return provider.Record{}, fmt.Errorf("not sending update after earlier refusal: %w", refused)
+	}
+	var err error
+	if p.config.DuckDNSToken != "" {
+		err = p.updateDuckDNS(ctx, zoneID, rec)
+	} else {
+		err = p.updateDyndns2(ctx, rec)
+	}
+	var updateErr *UpdateError
+	if errors.As(err, &updateErr) {
+		p.mu.Lock()
+		p.refused = err
+		p.mu.Unlock()
+	}
+	if err != nil {
+		return provider.Record{}, err
+	}
+	rec = provider.Record{ID: recordID(rec.Name, rec.Type), Name: rec.Name, Type: rec.Type, Content: rec.Content}
+	p.mu.Lock()
+	p.sent[rec.ID] = rec
+	p.mu.Unlock()
+	return rec, nil
+}
+
+// updateDyndns2 sends a dyndns2 update, which is answered with a return
+// code followed by the address for each host.
+func (p *Provider) updateDyndns2(ctx context.Context, rec provider.Record) error {
+	u, err := url.Parse(p.config.UpdateURL)
+	if err != nil {
+		return fmt.Errorf("invalid dyndns2 update URL: %w", err)
+	}
+	q := u.Query()
+	q.Set("hostname", strings.TrimSuffix(rec.Name, "."))
+	q.Set("myip", rec.Content)
+	u.RawQuery = q.Encode()
+	body, err := p.get(ctx, "dyndns2", u.String(), true)
+	if err != nil {
+		return err
+	}
+	code, _, _ := strings.Cut(strings.TrimSpace(body), " ")
+	switch code {
+	case "good", "nochg":
+		return nil
+	case "911", "dnserr":
+		return &provider.RateLimitError{Service: "dyndns2 server", Until: p.clock.Now().Add(serverErrorDelay)}
+	case "":
+		return errors.New("empty response from dyndns2 server")
+	}
+	return &UpdateError{Code: code}
+}
+
+// updateDuckDNS sends a DuckDNS update, which is answered OK or KO.
+// DuckDNS names are given without the duckdns.org suffix.
+func (p *Provider) updateDuckDNS(ctx context.Context, zone string, rec provider.Record) error {
+	u, err := url.Parse(cmp.Or(p.config.UpdateURL, defaultDuckDNSURL))
+	if err != nil {
+		return fmt.Errorf("invalid DuckDNS update URL: %w", err)
+	}
+	name := strings.TrimSuffix(rec.Name, ".")
+	name = strings.TrimSuffix(name, "."+strings.TrimSuffix(zone, "."))
+	q := u.Query()
+	q.Set("domains", name)
+	q.Set("token", p.config.DuckDNSToken)
+	if rec.Type == "AAAA" {
+		q.Set("ipv6", rec.Content)
+	} else {
+		q.Set("ip", rec.Content)
+	}
+	u.RawQuery = q.Encode()
+	body, err := p.get(ctx, "duckdns", u.String(), false)
+	if err != nil {
+		return err
+	}
+	if code := strings.TrimSpace(body); code != "OK" {
+		return &UpdateError{Code: code}
+	}
+	return nil
+}
+
+// get makes an update request, returning the response body.
+func (p *Provider) get(ctx context.Context, protocol, rawURL string, basicAuth bool) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if basicAuth {
+		req.SetBasicAuth(p.config.Username, p.config.Password)
+	}
+	if p.config.UserAgent != "" {
+		req.Header.Set("User-Agent", p.config.UserAgent)
+	}
+	_, span := otlp.StartSpanKind(ctx, protocol+" update", otlp.SpanKindClient, "http.request.method", http.MethodGet)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	dyndnsDuration.WithLabelValues(protocol).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		err = &UpdateError{Code: "badauth"}
+	} else if err == nil && (res.StatusCode < 200 || res.StatusCode > 299) {
+		err = fmt.Errorf("update server returned %s", res.Status)
+	}
+	span.End(err)
+	return string(b), err
+}
+
+func recordID(name, recordType string) string {
+	return strings.TrimSuffix(name, ".") + " " + recordType
+}
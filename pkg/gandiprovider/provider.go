@@ -0,0 +1,271 @@
+// Package gandiprovider implements provider.Provider for Gandi LiveDNS.
+package gandiprovider
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+const (
+	defaultBaseURL = "https://api.gandi.net/v5/livedns"
+	// defaultTTL is the TTL of records we create if none is configured.
+	// It is also the lowest LiveDNS allows.
+	defaultTTL = 300
+)
+
+var gandiDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_gandi_request_duration_seconds",
+	Help:    "How long Gandi LiveDNS API requests take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// Config holds the personal access token.
+type Config struct {
+	Token string
+	// TTL is the TTL of records we create, five minutes if zero.
+	TTL int
+	// BaseURL overrides the API endpoint, for mock servers.
+	BaseURL string
+	// HTTPClient makes API requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Provider manages records through the LiveDNS API, which works on whole
+// record sets. A zone's ID is its name and a record's ID is its name and
+// type; each value of a record set is a separate provider.Record. LiveDNS
+// records have no comments, so any comment is dropped.
+type Provider struct {
+	config Config
+	client *http.Client
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// APIError is an error returned by the LiveDNS API.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Gandi LiveDNS returned %s", e.Status)
+	}
+	return fmt.Sprintf("Gandi LiveDNS returned %s: %s", e.Status, e.Message)
+}
+
+// New returns a Provider using the configured token.
+func New(config Config) (*Provider, error) {
+	if config.Token == "" {
+		return nil, errors.New("Gandi LiveDNS needs a personal access token")
+	}
+	return &Provider{config: config, client: cmp.Or(config.HTTPClient, http.DefaultClient)}, nil
+}
+
+// rrset is the API's form of a record set. Names are relative to the
+// domain, with @ for the apex.
+type rrset struct {
+	Name   string   `json:"rrset_name,omitempty"`
+	Type   string   `json:"rrset_type,omitempty"`
+	TTL    int      `json:"rrset_ttl,omitempty"`
+	Values []string `json:"rrset_values"`
+}
+
+// ResolveZone checks that LiveDNS serves the domain, which is its own ID.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	err := p.call(ctx, "get_domain", http.MethodGet, "/domains/"+url.PathEscape(zone), nil, nil)
+	if isNotFound(err) {
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	return zone, nil
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	set, err := p.getRRSet(ctx, zoneID, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var recs []provider.Record
+	for _, value := range set.Values {
+		recs = append(recs, convertRecord(zoneID, name, recordType, set, value))
+	}
+	return recs, nil
+}
+
+// getRRSet returns the record set, which is empty if there isn't one.
+func (p *Provider) getRRSet(ctx context.Context, zoneID, name, recordType string) (rrset, error) {
+	var set rrset
+	err := p.call(ctx, "get_record", http.MethodGet, rrsetPath(zoneID, name, recordType), nil, &set)
+	if isNotFound(err) {
+		return rrset{}, nil
+	}
+	return set, err
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set := rrset{TTL: cmp.Or(p.config.TTL, defaultTTL), Values: []string{encodeValue(rec.Type, rec.Content)}}
+	if err := p.call(ctx, "create_record", http.MethodPost, rrsetPath(zoneID, rec.Name, rec.Type), set, nil); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(zoneID, rec.Name, rec.Type, set, set.Values[0]), nil
+}
+
+// UpdateRecord replaces the record set's values with the record's
+// content, keeping its TTL.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set := rrset{TTL: cmp.Or(p.config.TTL, defaultTTL)}
+	if orig, ok := rec.Extra.(rrset); ok && orig.TTL != 0 {
+		set.TTL = orig.TTL
+	}
+	set.Values = []string{encodeValue(rec.Type, rec.Content)}
+	if err := p.call(ctx, "update_record", http.MethodPut, rrsetPath(zoneID, rec.Name, rec.Type), set, nil); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(zoneID, rec.Name, rec.Type, set, set.Values[0]), nil
+}
+
+// DeleteRecord removes the record's value from its record set, deleting
+// the set if it was the last one. The set is read again first, since other
+// values may have been removed since rec was read.
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	set, err := p.getRRSet(ctx, zoneID, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	i := slices.Index(set.Values, rec.Content)
+	if i < 0 {
+		return fmt.Errorf("%w: %s %s %s", provider.ErrRecordNotFound, rec.Name, rec.Type, rec.Content)
+	}
+	set.Values = slices.Delete(set.Values, i, i+1)
+	path := rrsetPath(zoneID, rec.Name, rec.Type)
+	if len(set.Values) == 0 {
+		err = p.call(ctx, "delete_record", http.MethodDelete, path, nil, nil)
+	} else {
+		err = p.call(ctx, "delete_record", http.MethodPut, path, rrset{TTL: set.TTL, Values: set.Values}, nil)
+	}
+	if isNotFound(err) {
+		return fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	return err
+}
+
+// call makes a request to the API for operation, sending in as JSON if it
+// isn't nil and decoding the JSON response into result if that isn't nil.
+func (p *Provider) call(ctx context.Context, operation, method, path string, in, result any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cmp.Or(p.config.BaseURL, defaultBaseURL), "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	_, span := otlp.StartSpanKind(ctx, "gandi "+operation, otlp.SpanKindClient, "http.request.method", method)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	gandiDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err == nil && (res.StatusCode < 200 || res.StatusCode > 299) {
+		var doc struct {
+			Message string `json:"message"`
+			Cause   string `json:"cause"`
+		}
+		_ = json.Unmarshal(b, &doc)
+		err = &APIError{StatusCode: res.StatusCode, Status: res.Status, Message: cmp.Or(doc.Message, doc.Cause)}
+	}
+	span.End(err)
+	if err != nil || result == nil {
+		return err
+	}
+	if err := json.Unmarshal(b, result); err != nil {
+		return fmt.Errorf("invalid Gandi LiveDNS response: %w", err)
+	}
+	return nil
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// rrsetPath is the API path of a record set.
+func rrsetPath(zone, name, recordType string) string {
+	return fmt.Sprintf("/domains/%s/records/%s/%s", url.PathEscape(zone), url.PathEscape(relativeName(name, zone)), url.PathEscape(recordType))
+}
+
+// relativeName returns name relative to zone, as the API names records.
+func relativeName(name, zone string) string {
+	name, zone = strings.TrimSuffix(name, "."), strings.TrimSuffix(zone, ".")
+	if strings.EqualFold(name, zone) {
+		return "@"
+	}
+	if len(name) > len(zone) && strings.EqualFold(name[len(name)-len(zone)-1:], "."+zone) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// encodeValue quotes TXT record content, splitting it into strings of at
+// most 255 characters, unless it is already quoted, as LiveDNS keeps it.
+// Other types are written as they are.
+func encodeValue(recordType, content string) string {
+	if recordType != "TXT" || strings.HasPrefix(content, `"`) {
+		return content
+	}
+	var parts []string
+	for {
+		n := min(len(content), 255)
+		part := strings.ReplaceAll(content[:n], `\`, `\\`)
+		parts = append(parts, `"`+strings.ReplaceAll(part, `"`, `\"`)+`"`)
+		content = content[n:]
+		if content == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// convertRecord returns one value of the record set as a provider.Record,
+// keeping the set in Extra for updates.
+func convertRecord(zone, name, recordType string, set rrset, value string) provider.Record {
+	name = strings.TrimSuffix(name, ".")
+	return provider.Record{
+		ID:      relativeName(name, zone) + " " + recordType,
+		Name:    name,
+		Type:    recordType,
+		Content: value,
+		Extra:   set,
+	}
+}
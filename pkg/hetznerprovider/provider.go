@@ -0,0 +1,349 @@
+// Package hetznerprovider implements provider.Provider for Hetzner DNS.
+package hetznerprovider
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+const (
+	defaultBaseURL = "https://dns.hetzner.com/api/v1"
+	// defaultTTL is the TTL of records we create if none is configured.
+	defaultTTL = 300
+	// createdLayout is how the API formats times.
+	createdLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+)
+
+var hetznerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_hetzner_request_duration_seconds",
+	Help:    "How long Hetzner DNS API requests take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// Config holds the API token and describes the zone.
+type Config struct {
+	Token string
+	// Zone is the zone's name. ZoneID, if set, is its ID, so that it isn't
+	// looked up by name.
+	Zone   string
+	ZoneID string
+	// TTL is the TTL of records we create, five minutes if zero.
+	TTL int
+	// BaseURL overrides the API endpoint, for mock servers.
+	BaseURL string
+	// HTTPClient makes API requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Provider manages records through the Hetzner DNS API. Hetzner records
+// have no comments, so any comment is dropped.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu sync.Mutex
+	// zoneIDs and zoneNames map between zone names and IDs we have seen
+	zoneIDs   map[string]string
+	zoneNames map[string]string
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// APIError is an error returned by the Hetzner DNS API.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Hetzner DNS returned %s", e.Status)
+	}
+	return fmt.Sprintf("Hetzner DNS returned %s: %s", e.Status, e.Message)
+}
+
+// New returns a Provider using the configured token.
+func New(config Config) (*Provider, error) {
+	if config.Token == "" {
+		return nil, errors.New("Hetzner DNS needs an API token")
+	}
+	p := &Provider{
+		config:    config,
+		client:    cmp.Or(config.HTTPClient, http.DefaultClient),
+		zoneIDs:   map[string]string{},
+		zoneNames: map[string]string{},
+	}
+	if config.ZoneID != "" {
+		p.zoneNames[config.ZoneID] = config.Zone
+	}
+	return p, nil
+}
+
+// record is the API's form of a record. Names are relative to the zone,
+// with @ for the apex.
+type record struct {
+	ID       string `json:"id,omitempty"`
+	ZoneID   string `json:"zone_id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Value    string `json:"value"`
+	TTL      int    `json:"ttl,omitempty"`
+	Created  string `json:"created,omitempty"`
+	Modified string `json:"modified,omitempty"`
+}
+
+type zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ResolveZone returns the configured zone ID for the configured zone, and
+// otherwise looks the zone up by name, caching the result.
+func (p *Provider) ResolveZone(ctx context.Context, name string) (string, error) {
+	if p.config.ZoneID != "" && name == p.config.Zone {
+		return p.config.ZoneID, nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := p.zoneIDs[name]; ok {
+		return id, nil
+	}
+	var res struct {
+		Zones []zone `json:"zones"`
+	}
+	err := p.call(ctx, "list_zones", http.MethodGet, "/zones?name="+url.QueryEscape(name), nil, &res)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", name, err)
+	}
+	for _, z := range res.Zones {
+		if strings.EqualFold(z.Name, name) {
+			p.zoneIDs[name], p.zoneNames[z.ID] = z.ID, z.Name
+			return z.ID, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, name)
+}
+
+// zoneName returns the name of the zone with the given ID, which records
+// are named relative to.
+func (p *Provider) zoneName(ctx context.Context, zoneID string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if name, ok := p.zoneNames[zoneID]; ok {
+		return name, nil
+	}
+	var res struct {
+		Zone zone `json:"zone"`
+	}
+	if err := p.call(ctx, "get_zone", http.MethodGet, "/zones/"+url.PathEscape(zoneID), nil, &res); err != nil {
+		return "", err
+	}
+	p.zoneNames[zoneID] = res.Zone.Name
+	return res.Zone.Name, nil
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	zoneName, err := p.zoneName(ctx, zoneID)
+	if err != nil {
+		return nil, p.zoneError(zoneID, err)
+	}
+	var res struct {
+		Records []record `json:"records"`
+	}
+	if err := p.call(ctx, "list_records", http.MethodGet, "/records?zone_id="+url.QueryEscape(zoneID), nil, &res); err != nil {
+		return nil, p.zoneError(zoneID, err)
+	}
+	relative := relativeName(name, zoneName)
+	var recs []provider.Record
+	for _, r := range res.Records {
+		if strings.EqualFold(r.Name, relative) && r.Type == recordType {
+			recs = append(recs, convertRecord(r, zoneName))
+		}
+	}
+	return recs, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	zoneName, err := p.zoneName(ctx, zoneID)
+	if err != nil {
+		return provider.Record{}, p.zoneError(zoneID, err)
+	}
+	r := record{
+		ZoneID: zoneID,
+		Name:   relativeName(rec.Name, zoneName),
+		Type:   rec.Type,
+		Value:  rec.Content,
+		TTL:    cmp.Or(p.config.TTL, defaultTTL),
+	}
+	var res struct {
+		Record record `json:"record"`
+	}
+	if err := p.call(ctx, "create_record", http.MethodPost, "/records", r, &res); err != nil {
+		return provider.Record{}, p.zoneError(zoneID, err)
+	}
+	return convertRecord(res.Record, zoneName), nil
+}
+
+// UpdateRecord changes the record's value, keeping its TTL.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	zoneName, err := p.zoneName(ctx, zoneID)
+	if err != nil {
+		return provider.Record{}, p.zoneError(zoneID, err)
+	}
+	r := record{
+		ZoneID: zoneID,
+		Name:   relativeName(rec.Name, zoneName),
+		Type:   rec.Type,
+		Value:  rec.Content,
+	}
+	if orig, ok := rec.Extra.(record); ok {
+		r.TTL = orig.TTL
+	}
+	var res struct {
+		Record record `json:"record"`
+	}
+	err = p.call(ctx, "update_record", http.MethodPut, "/records/"+url.PathEscape(rec.ID), r, &res)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return provider.Record{}, fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	if err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(res.Record, zoneName), nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	err := p.call(ctx, "delete_record", http.MethodDelete, "/records/"+url.PathEscape(rec.ID), nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	return err
+}
+
+// zoneError marks a not found error from a zone we looked up with
+// provider.ErrZoneChanged, and forgets the zone so it is looked up again.
+// A configured zone ID is never looked up, so errors using it are returned
+// as they are.
+func (p *Provider) zoneError(zoneID string, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || p.config.ZoneID != "" {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, id := range p.zoneIDs {
+		if id == zoneID {
+			delete(p.zoneIDs, name)
+		}
+	}
+	delete(p.zoneNames, zoneID)
+	return fmt.Errorf("%w: %w", provider.ErrZoneChanged, err)
+}
+
+// call makes a request to the API for operation, sending in as JSON if it
+// isn't nil and decoding the JSON response into result.
+func (p *Provider) call(ctx context.Context, operation, method, path string, in, result any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(cmp.Or(p.config.BaseURL, defaultBaseURL), "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Auth-API-Token", p.config.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	_, span := otlp.StartSpanKind(ctx, "hetzner "+operation, otlp.SpanKindClient, "http.request.method", method)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	hetznerDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err == nil && (res.StatusCode < 200 || res.StatusCode > 299) {
+		err = parseError(res, b)
+	}
+	span.End(err)
+	if err != nil || result == nil {
+		return err
+	}
+	if err := json.Unmarshal(b, result); err != nil {
+		return fmt.Errorf("invalid Hetzner DNS response: %w", err)
+	}
+	return nil
+}
+
+// parseError decodes an error response, whose message is in one of two
+// places depending on the endpoint.
+func parseError(res *http.Response, body []byte) error {
+	var doc struct {
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &doc)
+	return &APIError{StatusCode: res.StatusCode, Status: res.Status, Message: cmp.Or(doc.Error.Message, doc.Message)}
+}
+
+// relativeName returns name relative to zone, as the API names records.
+func relativeName(name, zone string) string {
+	name, zone = strings.TrimSuffix(name, "."), strings.TrimSuffix(zone, ".")
+	if strings.EqualFold(name, zone) {
+		return "@"
+	}
+	if len(name) > len(zone) && strings.EqualFold(name[len(name)-len(zone)-1:], "."+zone) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// convertRecord returns r as a provider.Record, keeping the original in
+// Extra for updates.
+func convertRecord(r record, zone string) provider.Record {
+	name := zone
+	if r.Name != "@" {
+		name = r.Name + "." + zone
+	}
+	created, _ := time.Parse(createdLayout, r.Created)
+	return provider.Record{
+		ID:      r.ID,
+		Name:    name,
+		Type:    r.Type,
+		Content: r.Value,
+		Created: created,
+		Extra:   r,
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package ipsource
+
+import "syscall"
+
+// bindToDevice returns a socket control function that binds the socket to
+// the named interface, so traffic leaves through it regardless of routing.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package ipsource
+
+import (
+	"errors"
+	"syscall"
+)
+
+// bindToDevice is only supported on Linux; elsewhere use -source-address
+// or rely on -interface selecting the source address.
+func bindToDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errors.New("binding to a device is only supported on Linux")
+	}
+}
@@ -0,0 +1,75 @@
+package ipsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Without a name and server, the dns source asks OpenDNS, whose resolvers
+// answer this name with the address of whoever asked.
+const (
+	defaultDNSName   = "myip.opendns.com"
+	defaultDNSServer = "resolver1.opendns.com"
+)
+
+// dnsSource asks a nameserver that answers a special name with the address
+// the query came from, as an A or AAAA record, or failing that a TXT record
+// as Google's o-o.myaddr.l.google.com@ns1.google.com does.
+type dnsSource struct {
+	name   string
+	server string
+}
+
+func newDNSSource(spec string) (IPSource, error) {
+	if spec == "dns" {
+		spec = "dns:" + defaultDNSName + "@" + defaultDNSServer
+	}
+	name, server, ok := strings.Cut(strings.TrimPrefix(spec, "dns:"), "@")
+	if name == "" || !ok || server == "" {
+		return nil, errors.New("dns needs a name and a server, as dns:name@server[:port]")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return dnsSource{name: strings.TrimSuffix(name, ".") + ".", server: server}, nil
+}
+
+func (s dnsSource) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	udpNetwork := strings.Replace(config.Network, "tcp", "udp", 1)
+	if tcpAddr, ok := localAddr.(*net.TCPAddr); ok {
+		localAddr = &net.UDPAddr{IP: tcpAddr.IP}
+	}
+	dialer := ipDialer(config, localAddr)
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialer.DialContext(ctx, udpNetwork, s.server)
+		},
+	}
+
+	ipNetwork := "ip4"
+	if config.RecordType == "AAAA" {
+		ipNetwork = "ip6"
+	}
+	ips, err := resolver.LookupIP(ctx, ipNetwork, s.name)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return "", err
+	}
+	if len(ips) > 0 {
+		return ips[0].String(), nil
+	}
+	txts, err := resolver.LookupTXT(ctx, s.name)
+	if err != nil {
+		return "", err
+	}
+	for _, txt := range txts {
+		if net.ParseIP(txt) != nil {
+			return txt, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s has no address records and no TXT record holding an address", ErrBadIPResponse, s.name)
+}
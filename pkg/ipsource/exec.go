@@ -0,0 +1,55 @@
+package ipsource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// execTimeout limits how long an exec source may run.
+const execTimeout = 30 * time.Second
+
+// execSource runs a command with the system shell and takes the first
+// line it prints as the address, for routers and setups nothing else
+// understands. CFDNSUPDATER_RECORD_TYPE tells it which family is wanted.
+type execSource struct {
+	command string
+}
+
+func newExecSource(spec string) (IPSource, error) {
+	command := strings.TrimPrefix(spec, "exec:")
+	if strings.TrimSpace(command) == "" || command == spec {
+		return nil, errors.New("exec needs a command, as exec:command")
+	}
+	return execSource{command: command}, nil
+}
+
+func (s execSource) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, execTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", s.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", s.command)
+	}
+	cmd.Env = append(os.Environ(), "CFDNSUPDATER_RECORD_TYPE="+config.RecordType)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(line), nil
+}
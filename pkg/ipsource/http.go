@@ -0,0 +1,162 @@
+package ipsource
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// proxyTransport returns a copy of the default transport using the
+// configured proxy, or the proxy environment variables if none is set.
+func proxyTransport(proxy string) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		u, _ := url.Parse(proxy)
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return transport
+}
+
+// httpSource asks an echo service for our address: url, or the configured
+// Service if that is empty.
+type httpSource struct {
+	url string
+}
+
+func newHTTPSource(spec string) (IPSource, error) {
+	if spec == "http" {
+		return httpSource{}, nil
+	}
+	if _, err := url.Parse(spec); err != nil {
+		return nil, err
+	}
+	return httpSource{url: spec}, nil
+}
+
+func (s httpSource) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	if s.url != "" {
+		// the headers and TLS settings are credentials for the configured
+		// Service, and mustn't be sent to anyone else
+		config.Service = s.url
+		config.Headers, config.TLS = nil, nil
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = echoClient(config, localAddr)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", config.Service, nil)
+	if err != nil {
+		return "", err
+	}
+	if config.UserAgent != "" {
+		req.Header.Set("User-Agent", config.UserAgent)
+	}
+	for name, values := range config.Headers {
+		req.Header[name] = values
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: unexpected HTTP status %s", ErrBadIPResponse, res.Status)
+	}
+
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return parseIPResponse(b, config.Format, config.Field)
+}
+
+// echoClient returns a client for echo service requests that dials from
+// localAddr over the configured network, through any configured proxy.
+func echoClient(config Config, localAddr net.Addr) *http.Client {
+	dialer := ipDialer(config, localAddr)
+	transport := proxyTransport(config.Proxy)
+	if config.TLS != nil {
+		transport.TLSClientConfig = config.TLS
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, config.Network, addr)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// parseIPResponse extracts the address from an IP service response body.
+// Text responses are the bare address; JSON responses are searched for the
+// dotted field path, where numeric elements index into arrays.
+func parseIPResponse(body []byte, format, field string) (string, error) {
+	if format != "json" {
+		return strings.TrimSpace(string(body)), nil
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("%w: invalid JSON: %w", ErrBadIPResponse, err)
+	}
+	for _, part := range strings.Split(field, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = node[part]; !ok {
+				return "", fmt.Errorf("%w: field %s not found", ErrBadIPResponse, field)
+			}
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("%w: field %s not found", ErrBadIPResponse, field)
+			}
+			v = node[i]
+		default:
+			return "", fmt.Errorf("%w: field %s not found", ErrBadIPResponse, field)
+		}
+	}
+	ip, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: field %s is not a string", ErrBadIPResponse, field)
+	}
+	return strings.TrimSpace(ip), nil
+}
+
+// TLSConfig builds the TLS settings for echo service requests, or returns
+// nil if the defaults should be used.
+func TLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate and key must be given together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
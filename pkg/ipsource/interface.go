@@ -0,0 +1,109 @@
+package ipsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	interfaceIPPresent = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_interface_ip_present",
+		Help: "Whether the last detection through the interface returned an IP",
+	}, []string{"interface"})
+	interfaceLastChange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_interface_last_change_timestamp_seconds",
+		Help: "The time the IP detected through the interface last changed",
+	}, []string{"interface"})
+	interfaceFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cfdnsupdater_interface_detection_failures_total",
+		Help: "The number of failed IP detections through the interface",
+	}, []string{"interface"})
+)
+
+var (
+	interfaceIPsMu sync.Mutex
+	interfaceIPs   = map[string]string{}
+)
+
+// interfaceAddr returns a local TCP address on the named interface in the
+// family matching network, for use as the dialer's source address.
+func interfaceAddr(name, network string) (net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		is4 := ipnet.IP.To4() != nil
+		if (network == "tcp4" && !is4) || (network == "tcp6" && is4) {
+			continue
+		}
+		return &net.TCPAddr{IP: ipnet.IP}, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable %s address", name, network)
+}
+
+// interfaceSource reads the address straight off a local interface, for
+// hosts with a public address of their own.
+type interfaceSource struct {
+	name string
+}
+
+func newInterfaceSource(spec string) (IPSource, error) {
+	name := strings.TrimPrefix(spec, "interface:")
+	if name == "" || name == spec {
+		return nil, errors.New("interface needs a name, as interface:name")
+	}
+	return interfaceSource{name: name}, nil
+}
+
+func (s interfaceSource) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	addr, err := interfaceAddr(s.name, defaultNetworks[config.RecordType])
+	if err != nil {
+		return "", err
+	}
+	return addr.(*net.TCPAddr).IP.String(), nil
+}
+
+// ipDialer returns a dialer for IP detection traffic, using localAddr as
+// the source and binding to the interface if configured.
+func ipDialer(config Config, localAddr net.Addr) *net.Dialer {
+	dialer := &net.Dialer{LocalAddr: localAddr}
+	if config.BindToDevice {
+		dialer.Control = bindToDevice(config.Interface)
+	}
+	return dialer
+}
+
+// recordInterfaceDetection updates the per-interface metrics with the
+// outcome of a detection made at now.
+func recordInterfaceDetection(name, ip string, err error, now time.Time) {
+	if err != nil {
+		interfaceIPPresent.WithLabelValues(name).Set(0)
+		interfaceFailures.WithLabelValues(name).Inc()
+		return
+	}
+	interfaceIPPresent.WithLabelValues(name).Set(1)
+
+	interfaceIPsMu.Lock()
+	defer interfaceIPsMu.Unlock()
+	if interfaceIPs[name] != ip {
+		interfaceIPs[name] = ip
+		interfaceLastChange.WithLabelValues(name).Set(float64(now.Unix()))
+	}
+}
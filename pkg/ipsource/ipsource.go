@@ -0,0 +1,123 @@
+// Package ipsource detects our public IP address, from HTTP echo services,
+// DNS, STUN servers, the local UPnP gateway, an interface or a command,
+// trying each configured source in priority order.
+package ipsource
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+)
+
+// DefaultService is the echo service used when none is configured.
+const DefaultService = "https://ip.shee.sh/"
+
+// Config says how to detect the IP.
+type Config struct {
+	// RecordType is A or AAAA; addresses of the other family are rejected.
+	RecordType string
+	// Network is the dial network for lookups, see Network.
+	Network string
+	// Service is the URL of the echo service used by the "http" source.
+	Service string
+	// Format is text or json; for json, Field is the dotted path of the
+	// address within the response.
+	Format string
+	Field  string
+	// Headers are added to requests to Service, after the User-Agent so
+	// they can override it.
+	Headers http.Header
+	// TLS overrides the TLS settings for Service, for private CAs and
+	// client certificates.
+	TLS *tls.Config
+	// Proxy is an http(s):// or socks5:// URL for echo service requests.
+	// If empty, the standard proxy environment variables apply.
+	Proxy     string
+	UserAgent string
+	// Interface is the network interface to detect the IP through.
+	// SourceAddress overrides the address taken from it, and BindToDevice
+	// additionally binds sockets to it.
+	Interface     string
+	SourceAddress net.IP
+	BindToDevice  bool
+	// HTTPClient, if set, makes echo service requests instead of a client
+	// built from the settings above.
+	HTTPClient *http.Client
+	// Clock decides when unhealthy sources are retried and when the
+	// interface address changed, the real time if nil.
+	Clock clock.Clock
+}
+
+func (config Config) clock() clock.Clock {
+	if config.Clock == nil {
+		return clock.System{}
+	}
+	return config.Clock
+}
+
+// ErrBadIPResponse is returned when a source answers, but not with a usable
+// address.
+var ErrBadIPResponse = errors.New("bad IP lookup response")
+
+// defaultNetworks maps each supported record type to the network lookups
+// are made over, so the service sees the address family we want.
+var defaultNetworks = map[string]string{
+	"A":    "tcp4",
+	"AAAA": "tcp6",
+}
+
+// Network returns the dial network to use for lookups. An explicit
+// override of tcp4 or tcp6 wins; auto leaves the choice to the dialer.
+func Network(recordType, override string) (string, error) {
+	switch override {
+	case "":
+		network, ok := defaultNetworks[recordType]
+		if !ok {
+			return "", fmt.Errorf("unsupported record type %s", recordType)
+		}
+		return network, nil
+	case "auto":
+		return "tcp", nil
+	case "tcp4", "tcp6":
+		return override, nil
+	default:
+		return "", fmt.Errorf("IP network must be tcp4, tcp6 or auto (got %s)", override)
+	}
+}
+
+// checkIPFamily verifies that ip is an address suitable for recordType.
+func checkIPFamily(ip, recordType string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return fmt.Errorf("%w: %q is not an IP address", ErrBadIPResponse, ip)
+	}
+	if (parsed.To4() != nil) != (recordType == "A") {
+		return fmt.Errorf("%w: %s can't be used for an %s record", ErrBadIPResponse, ip, recordType)
+	}
+	return nil
+}
+
+// Detect looks up our current address for the configured record type,
+// from the configured source address or interface if there is one.
+func (sources Sources) Detect(ctx context.Context, config Config) (string, error) {
+	var localAddr net.Addr
+	var err error
+	if config.SourceAddress != nil {
+		localAddr = &net.TCPAddr{IP: config.SourceAddress}
+	} else if config.Interface != "" {
+		localAddr, err = interfaceAddr(config.Interface, config.Network)
+	}
+	ip := ""
+	if err == nil {
+		ip, err = sources.detect(ctx, config, localAddr)
+	}
+	if config.Interface != "" {
+		recordInterfaceDetection(config.Interface, ip, err, config.clock().Now())
+	}
+	return ip, err
+}
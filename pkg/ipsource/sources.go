@@ -0,0 +1,206 @@
+package ipsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// sourceRecheckInterval is how long an unhealthy source is skipped before
+// it is probed again.
+const sourceRecheckInterval = 10 * time.Minute
+
+var (
+	lookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cfdnsupdater_ip_lookup_duration_seconds",
+		Help:    "How long IP lookups take, by source",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"source"})
+	sourceHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_ip_source_healthy",
+		Help: "Whether the IP source succeeded the last time it was tried",
+	}, []string{"source"})
+	sourceSelected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cfdnsupdater_ip_source_selected",
+		Help: "Whether the IP source provided the most recently detected IP",
+	}, []string{"source"})
+	sourceFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cfdnsupdater_ip_source_failures_total",
+		Help: "The number of failed lookups per IP source",
+	}, []string{"source"})
+)
+
+// IPSource finds our public address by one particular method. localAddr,
+// if set, is the local address to send any requests from.
+type IPSource interface {
+	Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error)
+}
+
+// Factory creates an IPSource from its spec, which starts with the scheme
+// it was registered under.
+type Factory func(spec string) (IPSource, error)
+
+var factories = map[string]Factory{
+	"http":      newHTTPSource,
+	"https":     newHTTPSource,
+	"stun":      newSTUNSource,
+	"upnp":      newUPnPSource,
+	"dns":       newDNSSource,
+	"interface": newInterfaceSource,
+	"exec":      newExecSource,
+}
+
+// Register makes specs starting with scheme create sources with factory,
+// replacing any existing factory for it. It must be called before New,
+// typically from an init function.
+func Register(scheme string, factory Factory) {
+	factories[scheme] = factory
+}
+
+// Source is one configured way of detecting the IP, with its health.
+type Source struct {
+	Name   string
+	source IPSource
+
+	mu        sync.Mutex
+	healthy   bool
+	failures  int
+	lastError error
+	lastCheck time.Time
+}
+
+// Status is a snapshot of an IP source's health.
+type Status struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheck           time.Time `json:"last_check"`
+}
+
+// Sources are tried in order, so the first entry has the highest priority.
+type Sources []*Source
+
+// New creates a source from a spec of the form scheme[:detail]: "http" for
+// the configured Service URL, an http(s) URL, stun:host[:port], upnp,
+// dns[:name[@server[:port]]], interface:name or exec:command.
+func New(spec string) (*Source, error) {
+	scheme, _, _ := strings.Cut(spec, ":")
+	factory, ok := factories[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown IP source %q", spec)
+	}
+	source, err := factory(spec)
+	if err != nil {
+		return nil, fmt.Errorf("IP source %q: %w", spec, err)
+	}
+	return &Source{Name: spec, source: source, healthy: true}, nil
+}
+
+func (s *Source) due(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.healthy || now.Sub(s.lastCheck) >= sourceRecheckInterval
+}
+
+func (s *Source) record(now time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastCheck = now
+	s.lastError = err
+	if err != nil {
+		s.healthy = false
+		s.failures++
+		sourceHealthy.WithLabelValues(s.Name).Set(0)
+		sourceFailures.WithLabelValues(s.Name).Inc()
+		return
+	}
+	s.healthy = true
+	s.failures = 0
+	sourceHealthy.WithLabelValues(s.Name).Set(1)
+}
+
+// Status returns a snapshot of the source's health.
+func (s *Source) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{
+		Name:                s.Name,
+		Healthy:             s.healthy,
+		ConsecutiveFailures: s.failures,
+		LastCheck:           s.lastCheck,
+	}
+	if s.lastError != nil {
+		status.LastError = s.lastError.Error()
+	}
+	return status
+}
+
+// Status returns the health of every source in priority order.
+func (sources Sources) Status() []Status {
+	statuses := make([]Status, len(sources))
+	for i, s := range sources {
+		statuses[i] = s.Status()
+	}
+	return statuses
+}
+
+func (sources Sources) try(ctx context.Context, config Config, localAddr net.Addr, s *Source) (string, error) {
+	clock := config.clock()
+	start := clock.Now()
+	ip, err := s.source.Lookup(ctx, config, localAddr)
+	end := clock.Now()
+	lookupDuration.WithLabelValues(s.Name).Observe(end.Sub(start).Seconds())
+	if err == nil {
+		err = checkIPFamily(ip, config.RecordType)
+	}
+	s.record(end, err)
+	if err != nil {
+		slog.WarnContext(ctx, "IP source failed", "source", s.Name, "error", err)
+		return "", err
+	}
+	for _, other := range sources {
+		selected := 0.0
+		if other == s {
+			selected = 1
+		}
+		sourceSelected.WithLabelValues(other.Name).Set(selected)
+	}
+	return ip, nil
+}
+
+// detect returns the IP from the highest priority healthy source. Sources
+// that recently failed are skipped until their recheck is due, unless
+// every other source fails too.
+func (sources Sources) detect(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	var errs []error
+	var skipped []*Source
+	now := config.clock().Now()
+	for _, s := range sources {
+		if !s.due(now) {
+			skipped = append(skipped, s)
+			continue
+		}
+		ip, err := sources.try(ctx, config, localAddr, s)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+	}
+	for _, s := range skipped {
+		ip, err := sources.try(ctx, config, localAddr, s)
+		if err == nil {
+			return ip, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.Name, err))
+	}
+	return "", errors.Join(errs...)
+}
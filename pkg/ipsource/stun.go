@@ -0,0 +1,155 @@
+package ipsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingSuccess  = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunMappedAddress   = 0x0001
+	stunXorMappedAddr   = 0x0020
+	stunHeaderLength    = 20
+	stunRequestTimeout  = 5 * time.Second
+	stunFamilyIPv4      = 0x01
+	stunFamilyIPv6      = 0x02
+	stunMaxResponseSize = 1500
+)
+
+// stunSource asks a STUN server (RFC 5389) which address our binding
+// request came from.
+type stunSource struct {
+	server string
+}
+
+func newSTUNSource(spec string) (IPSource, error) {
+	server := strings.TrimPrefix(spec, "stun:")
+	if server == "" || server == spec {
+		return nil, errors.New("stun needs a server, as stun:host[:port]")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "3478")
+	}
+	return stunSource{server: server}, nil
+}
+
+func (s stunSource) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	udpNetwork := strings.Replace(config.Network, "tcp", "udp", 1)
+	if tcpAddr, ok := localAddr.(*net.TCPAddr); ok {
+		localAddr = &net.UDPAddr{IP: tcpAddr.IP}
+	}
+	dialer := ipDialer(config, localAddr)
+	dialer.Timeout = stunRequestTimeout
+	conn, err := dialer.DialContext(ctx, udpNetwork, s.server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	req := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(req[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:], stunMagicCookie)
+	txid := req[8:20]
+	if _, err := rand.Read(txid); err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(stunRequestTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	// the deadline doesn't notice ctx being cancelled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+	res := make([]byte, stunMaxResponseSize)
+	n, err := conn.Read(res)
+	if err != nil {
+		return "", err
+	}
+	return parseSTUNResponse(res[:n], txid)
+}
+
+func parseSTUNResponse(res, txid []byte) (string, error) {
+	if len(res) < stunHeaderLength {
+		return "", fmt.Errorf("%w: short STUN response", ErrBadIPResponse)
+	}
+	if binary.BigEndian.Uint16(res[0:]) != stunBindingSuccess {
+		return "", fmt.Errorf("%w: STUN server did not return a binding success", ErrBadIPResponse)
+	}
+	if !bytes.Equal(res[8:20], txid) {
+		return "", fmt.Errorf("%w: STUN response transaction ID mismatch", ErrBadIPResponse)
+	}
+	length := int(binary.BigEndian.Uint16(res[2:]))
+	attrs := res[stunHeaderLength:]
+	if len(attrs) < length {
+		return "", fmt.Errorf("%w: truncated STUN response", ErrBadIPResponse)
+	}
+	attrs = attrs[:length]
+
+	var mapped net.IP
+	for len(attrs) >= 4 {
+		atype := binary.BigEndian.Uint16(attrs[0:])
+		alen := int(binary.BigEndian.Uint16(attrs[2:]))
+		if len(attrs) < 4+alen {
+			break
+		}
+		value := attrs[4 : 4+alen]
+		switch atype {
+		case stunXorMappedAddr:
+			if ip := stunAddress(value, res[4:20]); ip != nil {
+				return ip.String(), nil
+			}
+		case stunMappedAddress:
+			mapped = stunAddress(value, nil)
+		}
+		// attributes are padded to a multiple of four bytes
+		attrs = attrs[4+(alen+3)&^3:]
+	}
+	if mapped != nil {
+		return mapped.String(), nil
+	}
+	return "", fmt.Errorf("%w: STUN response contained no mapped address", ErrBadIPResponse)
+}
+
+// stunAddress decodes a (XOR-)MAPPED-ADDRESS value. The key is the magic
+// cookie and transaction ID for XOR-MAPPED-ADDRESS, or nil.
+func stunAddress(value, key []byte) net.IP {
+	if len(value) < 4 {
+		return nil
+	}
+	var size int
+	switch value[1] {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	if len(value) < 4+size {
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	for i := range key {
+		if i < size {
+			ip[i] ^= key[i]
+		}
+	}
+	return ip
+}
@@ -0,0 +1,210 @@
+package ipsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ssdpAddress      = "239.255.255.250:1900"
+	ssdpSearchTarget = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	upnpTimeout      = 3 * time.Second
+)
+
+// upnpGateway asks the local Internet Gateway Device for its external
+// address. The control URL is discovered once and reused until it fails.
+type upnpGateway struct {
+	mu          sync.Mutex
+	controlURL  string
+	serviceType string
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+func newUPnPSource(spec string) (IPSource, error) {
+	if spec != "upnp" {
+		return nil, errors.New("upnp takes no arguments")
+	}
+	return &upnpGateway{}, nil
+}
+
+func (g *upnpGateway) Lookup(ctx context.Context, config Config, localAddr net.Addr) (string, error) {
+	if config.RecordType != "A" {
+		return "", errors.New("UPnP only provides an IPv4 address")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.controlURL == "" {
+		if err := g.discover(ctx, config, localAddr); err != nil {
+			return "", err
+		}
+	}
+	ip, err := g.externalIP(ctx)
+	if err != nil {
+		// the gateway may have moved or restarted, so rediscover next time
+		g.controlURL = ""
+	}
+	return ip, err
+}
+
+func (g *upnpGateway) discover(ctx context.Context, config Config, localAddr net.Addr) error {
+	laddr := ":0"
+	if tcpAddr, ok := localAddr.(*net.TCPAddr); ok {
+		laddr = net.JoinHostPort(tcpAddr.IP.String(), "0")
+	}
+	listener := net.ListenConfig{Control: ipDialer(config, nil).Control}
+	conn, err := listener.ListenPacket(ctx, "udp4", laddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	search := strings.Join([]string{
+		"M-SEARCH * HTTP/1.1",
+		"HOST: " + ssdpAddress,
+		`MAN: "ssdp:discover"`,
+		"MX: 2",
+		"ST: " + ssdpSearchTarget,
+		"", "",
+	}, "\r\n")
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(upnpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	// the deadline doesn't notice ctx being cancelled
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("no UPnP gateway found: %w", err)
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\r\n") {
+			name, value, ok := strings.Cut(line, ":")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "location") {
+				if err := g.describe(ctx, strings.TrimSpace(value)); err == nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// describe fetches the device description and finds the WAN connection
+// service's control URL.
+func (g *upnpGateway) describe(ctx context.Context, location string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: upnpTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var root upnpRoot
+	if err := xml.NewDecoder(res.Body).Decode(&root); err != nil {
+		return err
+	}
+	service := findWANService(root.Device)
+	if service == nil {
+		return errors.New("gateway has no WAN connection service")
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return err
+		}
+	}
+	control, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return err
+	}
+	g.controlURL = control.String()
+	g.serviceType = service.ServiceType
+	return nil
+}
+
+func findWANService(device upnpDevice) *upnpService {
+	for i, s := range device.Services {
+		if strings.Contains(s.ServiceType, "WANIPConnection") || strings.Contains(s.ServiceType, "WANPPPConnection") {
+			return &device.Services[i]
+		}
+	}
+	for _, d := range device.Devices {
+		if s := findWANService(d); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (g *upnpGateway) externalIP(ctx context.Context) (string, error) {
+	body := fmt.Sprintf(`<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:GetExternalIPAddress xmlns:u="%s"/></s:Body>
+</s:Envelope>`, g.serviceType)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.controlURL, bytes.NewBufferString(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#GetExternalIPAddress"`, g.serviceType))
+	client := http.Client{Timeout: upnpTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: unexpected HTTP status %s from UPnP gateway", ErrBadIPResponse, res.Status)
+	}
+	var envelope struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&envelope); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBadIPResponse, err)
+	}
+	if envelope.IP == "" {
+		return "", fmt.Errorf("%w: UPnP gateway returned no external address", ErrBadIPResponse)
+	}
+	return envelope.IP, nil
+}
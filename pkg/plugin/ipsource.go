@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+)
+
+func init() {
+	ipsource.Register("plugin", newIPSource)
+}
+
+// ipSource is an ipsource.IPSource implemented by a plugin.
+type ipSource struct {
+	command command
+}
+
+func newIPSource(spec string) (ipsource.IPSource, error) {
+	commandLine, ok := strings.CutPrefix(spec, "plugin:")
+	if !ok {
+		return nil, errors.New("plugin needs a command, as plugin:command")
+	}
+	c, err := parseCommand(commandLine)
+	if err != nil {
+		return nil, err
+	}
+	return ipSource{command: c}, nil
+}
+
+func (s ipSource) Lookup(ctx context.Context, config ipsource.Config, localAddr net.Addr) (string, error) {
+	var result struct {
+		IP string `json:"ip"`
+	}
+	params := map[string]string{"record_type": config.RecordType, "network": config.Network}
+	err := s.command.call(ctx, "lookup", params, &result)
+	return result.IP, err
+}
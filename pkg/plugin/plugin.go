@@ -0,0 +1,154 @@
+// Package plugin runs executables implementing DNS providers or IP sources,
+// so they can be added without changing cfdnsupdater.
+//
+// A plugin is run once per call. It reads a JSON request from stdin,
+//
+//	{"method": "get_record", "params": {"zone_id": "...", "name": "home.example.com", "type": "A"}}
+//
+// and writes a JSON response to stdout, holding either the result or an
+// error:
+//
+//	{"result": [{"id": "1", "name": "home.example.com", "type": "A", "content": "192.0.2.1"}]}
+//	{"error": {"code": "record_not_found", "message": "record 1 has gone"}}
+//
+// Anything written to stderr is included in the error if the plugin fails.
+//
+// Provider plugins implement these methods:
+//
+//	resolve_zone   {"zone"}                     -> {"zone_id"}
+//	get_record     {"zone_id", "name", "type"}  -> [record, ...]
+//	create_record  {"zone_id", "record"}        -> record
+//	update_record  {"zone_id", "record"}        -> record
+//	delete_record  {"zone_id", "record"}        -> null
+//
+// where a record is {"id", "name", "type", "content", "comment",
+// "created", "extra"}. The extra field is any JSON the plugin likes, and is
+// passed back unchanged when the record is updated or deleted. The error
+// codes record_not_found, zone_changed and zone_not_found have the meanings
+// of provider.ErrRecordNotFound, provider.ErrZoneChanged and
+// provider.ErrZoneNotFound.
+//
+// An IP source plugin's bad_response error code has the meaning of
+// ipsource.ErrBadIPResponse.
+//
+// IP source plugins implement one method:
+//
+//	lookup  {"record_type", "network"}  -> {"ip"}
+//
+// and are configured as the IP source plugin:command.
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// callTimeout limits how long a plugin may take to answer, unless the
+// context has an earlier deadline.
+const callTimeout = 30 * time.Second
+
+// Error codes a plugin can return that map onto the provider package's
+// errors.
+const (
+	codeRecordNotFound = "record_not_found"
+	codeZoneChanged    = "zone_changed"
+	codeZoneNotFound   = "zone_not_found"
+	codeBadResponse    = "bad_response"
+)
+
+type request struct {
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// Error is an error reported by a plugin.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// Unwrap maps the plugin's error code onto the provider and ipsource
+// errors.
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case codeRecordNotFound:
+		return provider.ErrRecordNotFound
+	case codeZoneChanged:
+		return provider.ErrZoneChanged
+	case codeZoneNotFound:
+		return provider.ErrZoneNotFound
+	case codeBadResponse:
+		return ipsource.ErrBadIPResponse
+	}
+	return nil
+}
+
+// command is a plugin executable and its arguments.
+type command []string
+
+// parseCommand splits a plugin command line on spaces. There is no
+// quoting, so the executable's path can't contain spaces.
+func parseCommand(s string) (command, error) {
+	args := strings.Fields(s)
+	if len(args) == 0 {
+		return nil, errors.New("plugin needs a command")
+	}
+	return command(args), nil
+}
+
+// call runs the plugin with a request for method, decoding its result into
+// result, which may be nil to ignore it.
+func (c command) call(ctx context.Context, method string, params, result any) error {
+	in, err := json.Marshal(request{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c[0], c[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("plugin %s %s: %w: %s", c[0], method, err, msg)
+		}
+		return fmt.Errorf("plugin %s %s: %w", c[0], method, err)
+	}
+	var res response
+	if err := json.Unmarshal(out, &res); err != nil {
+		return fmt.Errorf("plugin %s %s returned invalid JSON: %w", c[0], method, err)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(res.Result, result); err != nil {
+		return fmt.Errorf("plugin %s %s returned an invalid result: %w", c[0], method, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// Provider is a provider.Provider implemented by a plugin.
+type Provider struct {
+	command command
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// NewProvider returns a Provider that runs the plugin command line.
+func NewProvider(commandLine string) (*Provider, error) {
+	c, err := parseCommand(commandLine)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{command: c}, nil
+}
+
+// record is the JSON form of a provider.Record.
+type record struct {
+	ID      string          `json:"id,omitempty"`
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	Content string          `json:"content"`
+	Comment string          `json:"comment,omitempty"`
+	Created time.Time       `json:"created,omitzero"`
+	Extra   json.RawMessage `json:"extra,omitempty"`
+}
+
+func toRecord(rec provider.Record) record {
+	extra, _ := rec.Extra.(json.RawMessage)
+	return record{ID: rec.ID, Name: rec.Name, Type: rec.Type, Content: rec.Content, Comment: rec.Comment, Created: rec.Created, Extra: extra}
+}
+
+func (r record) provider() provider.Record {
+	rec := provider.Record{ID: r.ID, Name: r.Name, Type: r.Type, Content: r.Content, Comment: r.Comment, Created: r.Created}
+	if len(r.Extra) > 0 {
+		rec.Extra = r.Extra
+	}
+	return rec
+}
+
+type recordParams struct {
+	ZoneID string `json:"zone_id"`
+	Record record `json:"record"`
+}
+
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	var result struct {
+		ZoneID string `json:"zone_id"`
+	}
+	err := p.command.call(ctx, "resolve_zone", map[string]string{"zone": zone}, &result)
+	return result.ZoneID, err
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	var result []record
+	params := map[string]string{"zone_id": zoneID, "name": name, "type": recordType}
+	if err := p.command.call(ctx, "get_record", params, &result); err != nil {
+		return nil, err
+	}
+	recs := make([]provider.Record, len(result))
+	for i, r := range result {
+		recs[i] = r.provider()
+	}
+	return recs, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	var result record
+	err := p.command.call(ctx, "create_record", recordParams{ZoneID: zoneID, Record: toRecord(rec)}, &result)
+	return result.provider(), err
+}
+
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	var result record
+	err := p.command.call(ctx, "update_record", recordParams{ZoneID: zoneID, Record: toRecord(rec)}, &result)
+	return result.provider(), err
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	return p.command.call(ctx, "delete_record", recordParams{ZoneID: zoneID, Record: toRecord(rec)}, nil)
+}
@@ -0,0 +1,260 @@
+// Package porkbunprovider implements provider.Provider for Porkbun DNS.
+package porkbunprovider
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+const (
+	defaultBaseURL = "https://api.porkbun.com/api/json/v3"
+	// defaultTTL is the TTL of records we create if none is configured.
+	// It is also the lowest Porkbun allows.
+	defaultTTL = 600
+	// domainsPageSize is how many domains listAll returns at once.
+	domainsPageSize = 1000
+)
+
+var porkbunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_porkbun_request_duration_seconds",
+	Help:    "How long Porkbun API requests take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// Config holds the API key pair.
+type Config struct {
+	APIKey       string
+	SecretAPIKey string
+	// TTL is the TTL of records we create, ten minutes if zero.
+	TTL int
+	// BaseURL overrides the API endpoint, for mock servers.
+	BaseURL string
+	// HTTPClient makes API requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// Provider manages records through the Porkbun API. A zone's ID is its
+// domain name, which must have API access turned on in Porkbun's domain
+// settings. Record notes are used as comments.
+type Provider struct {
+	config Config
+	client *http.Client
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// APIError is an error returned by the Porkbun API, which reports errors
+// in the response body and not always in the HTTP status.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Porkbun returned %s", e.Status)
+	}
+	return fmt.Sprintf("Porkbun returned %s: %s", e.Status, e.Message)
+}
+
+// New returns a Provider using the configured keys.
+func New(config Config) (*Provider, error) {
+	if config.APIKey == "" || config.SecretAPIKey == "" {
+		return nil, errors.New("Porkbun needs an API key and a secret API key")
+	}
+	return &Provider{config: config, client: cmp.Or(config.HTTPClient, http.DefaultClient)}, nil
+}
+
+// record is the API's form of a record. Names are fully qualified, and
+// numbers are strings.
+type record struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     string `json:"ttl"`
+	Prio    string `json:"prio"`
+	Notes   string `json:"notes"`
+}
+
+// ResolveZone checks that the account holds the domain, which is its own
+// ID.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	for start := 0; ; start += domainsPageSize {
+		var res struct {
+			Domains []struct {
+				Domain string `json:"domain"`
+			} `json:"domains"`
+		}
+		if err := p.call(ctx, "list_domains", "/domain/listAll", map[string]any{"start": strconv.Itoa(start)}, &res); err != nil {
+			return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+		}
+		for _, d := range res.Domains {
+			if strings.EqualFold(d.Domain, zone) {
+				return d.Domain, nil
+			}
+		}
+		if len(res.Domains) < domainsPageSize {
+			return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+		}
+	}
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	path := "/dns/retrieveByNameType/" + url.PathEscape(zoneID) + "/" + url.PathEscape(recordType)
+	if sub := subdomain(name, zoneID); sub != "" {
+		path += "/" + url.PathEscape(sub)
+	}
+	var res struct {
+		Records []record `json:"records"`
+	}
+	if err := p.call(ctx, "list_records", path, nil, &res); err != nil {
+		return nil, err
+	}
+	var recs []provider.Record
+	for _, r := range res.Records {
+		recs = append(recs, convertRecord(r))
+	}
+	return recs, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	r := record{
+		Name:    strings.TrimSuffix(rec.Name, "."),
+		Type:    rec.Type,
+		Content: rec.Content,
+		TTL:     strconv.Itoa(cmp.Or(p.config.TTL, defaultTTL)),
+		Notes:   rec.Comment,
+	}
+	var res struct {
+		ID json.Number `json:"id"`
+	}
+	if err := p.call(ctx, "create_record", "/dns/create/"+url.PathEscape(zoneID), recordFields(r, zoneID), &res); err != nil {
+		return provider.Record{}, err
+	}
+	r.ID = res.ID.String()
+	return convertRecord(r), nil
+}
+
+// UpdateRecord changes the record's content and notes, keeping its TTL and
+// priority.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	r := record{TTL: strconv.Itoa(cmp.Or(p.config.TTL, defaultTTL))}
+	if orig, ok := rec.Extra.(record); ok {
+		r = orig
+	}
+	r.ID, r.Name, r.Type, r.Content, r.Notes = rec.ID, strings.TrimSuffix(rec.Name, "."), rec.Type, rec.Content, rec.Comment
+	if err := p.call(ctx, "update_record", "/dns/edit/"+url.PathEscape(zoneID)+"/"+url.PathEscape(rec.ID), recordFields(r, zoneID), nil); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(r), nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	return p.call(ctx, "delete_record", "/dns/delete/"+url.PathEscape(zoneID)+"/"+url.PathEscape(rec.ID), nil, nil)
+}
+
+// recordFields returns the request fields to create or edit r, which
+// names it relative to the zone.
+func recordFields(r record, zone string) map[string]any {
+	fields := map[string]any{
+		"name":    subdomain(r.Name, zone),
+		"type":    r.Type,
+		"content": r.Content,
+		"ttl":     r.TTL,
+		"notes":   r.Notes,
+	}
+	if r.Prio != "" {
+		fields["prio"] = r.Prio
+	}
+	return fields
+}
+
+// call POSTs fields to the API for operation, with the keys added, and
+// decodes the JSON response into result if that isn't nil.
+func (p *Provider) call(ctx context.Context, operation, path string, fields map[string]any, result any) error {
+	in := map[string]any{"apikey": p.config.APIKey, "secretapikey": p.config.SecretAPIKey}
+	for k, v := range fields {
+		in[k] = v
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(cmp.Or(p.config.BaseURL, defaultBaseURL), "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, span := otlp.StartSpanKind(ctx, "porkbun "+operation, otlp.SpanKindClient, "http.request.method", http.MethodPost)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	porkbunDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	defer res.Body.Close()
+	b, err = io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err == nil {
+		var doc struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(b, &doc)
+		if res.StatusCode < 200 || res.StatusCode > 299 || doc.Status != "SUCCESS" {
+			err = &APIError{StatusCode: res.StatusCode, Status: res.Status, Message: doc.Message}
+		}
+	}
+	span.End(err)
+	if err != nil || result == nil {
+		return err
+	}
+	if err := json.Unmarshal(b, result); err != nil {
+		return fmt.Errorf("invalid Porkbun response: %w", err)
+	}
+	return nil
+}
+
+// subdomain returns name relative to zone, as the API names records, with
+// the apex as an empty string.
+func subdomain(name, zone string) string {
+	name, zone = strings.TrimSuffix(name, "."), strings.TrimSuffix(zone, ".")
+	if strings.EqualFold(name, zone) {
+		return ""
+	}
+	if len(name) > len(zone) && strings.EqualFold(name[len(name)-len(zone)-1:], "."+zone) {
+		return name[:len(name)-len(zone)-1]
+	}
+	return name
+}
+
+// convertRecord returns r as a provider.Record, keeping the original in
+// Extra for updates.
+func convertRecord(r record) provider.Record {
+	return provider.Record{
+		ID:      r.ID,
+		Name:    r.Name,
+		Type:    r.Type,
+		Content: r.Content,
+		Comment: r.Notes,
+		Extra:   r,
+	}
+}
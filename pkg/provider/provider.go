@@ -0,0 +1,68 @@
+// Package provider defines the interface between the updater and the DNS
+// service hosting the zone.
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrRecordNotFound is returned when a record we read earlier has
+	// since been deleted.
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrZoneChanged is returned when the zone ID is no longer valid,
+	// usually because the zone was deleted and recreated. Resolving the
+	// zone again gives its new ID.
+	ErrZoneChanged = errors.New("zone ID is no longer valid")
+	// ErrZoneNotFound is returned when no zone has the configured name.
+	ErrZoneNotFound = errors.New("zone not found")
+)
+
+// RateLimitError means the DNS service told us to stop making requests
+// until a particular time.
+type RateLimitError struct {
+	Service string
+	Until   time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited by %s until %s", e.Service, e.Until.Format(time.RFC3339))
+}
+
+// Record is a DNS record.
+type Record struct {
+	ID      string
+	Name    string
+	Type    string
+	Content string
+	Comment string
+	Created time.Time
+	// Extra holds provider-specific details of the record, such as
+	// Cloudflare's proxy setting, which are passed back unchanged when it
+	// is updated.
+	Extra any
+}
+
+// Provider manages records in a DNS service.
+type Provider interface {
+	// ResolveZone returns the ID of the named zone.
+	ResolveZone(ctx context.Context, zone string) (string, error)
+	// GetRecord returns the records in the zone with the given name and
+	// type. There may be none, or more than one.
+	GetRecord(ctx context.Context, zoneID, name, recordType string) ([]Record, error)
+	// CreateRecord creates rec, ignoring its ID, and returns the record
+	// as created.
+	CreateRecord(ctx context.Context, zoneID string, rec Record) (Record, error)
+	// UpdateRecord replaces the record with rec's ID by rec, and returns
+	// the record as updated.
+	UpdateRecord(ctx context.Context, zoneID string, rec Record) (Record, error)
+}
+
+// RecordDeleter is implemented by providers that can delete records, which
+// is needed to consolidate duplicates.
+type RecordDeleter interface {
+	DeleteRecord(ctx context.Context, zoneID string, rec Record) error
+}
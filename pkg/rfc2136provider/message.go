@@ -0,0 +1,259 @@
+package rfc2136provider
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+)
+
+const (
+	opcodeUpdate = 5
+	// classNONE marks a record to delete in an update.
+	classNONE      = 254
+	requestTimeout = 10 * time.Second
+)
+
+var rfc2136Duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_rfc2136_request_duration_seconds",
+	Help:    "How long RFC 2136 queries and updates take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// change is a record to add or delete in an update.
+type change struct {
+	name       string
+	recordType string
+	content    string
+	ttl        uint32
+}
+
+// query asks the server for the records with the name and type, returning
+// the answers.
+func (p *Provider) query(ctx context.Context, operation, name string, rrType dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(canonicalName(name))
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: newID()})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: rrType, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	return p.exchange(ctx, operation, msg)
+}
+
+// update sends the server an update for zone deleting and adding records.
+func (p *Provider) update(ctx context.Context, operation, zone string, deletes, adds []change) error {
+	zname, err := dnsmessage.NewName(canonicalName(zone))
+	if err != nil {
+		return err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: newID(), OpCode: opcodeUpdate})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return err
+	}
+	if err := b.Question(dnsmessage.Question{Name: zname, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return err
+	}
+	if err := b.StartAuthorities(); err != nil {
+		return err
+	}
+	for _, c := range deletes {
+		if err := addResource(&b, c, classNONE, 0); err != nil {
+			return err
+		}
+	}
+	for _, c := range adds {
+		if err := addResource(&b, c, dnsmessage.ClassINET, c.ttl); err != nil {
+			return err
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return err
+	}
+	_, err = p.exchange(ctx, operation, msg)
+	return err
+}
+
+// addResource adds c to the update section, with the given class and TTL.
+func addResource(b *dnsmessage.Builder, c change, class dnsmessage.Class, ttl uint32) error {
+	name, err := dnsmessage.NewName(canonicalName(c.name))
+	if err != nil {
+		return err
+	}
+	rrType, err := parseType(c.recordType)
+	if err != nil {
+		return err
+	}
+	h := dnsmessage.ResourceHeader{Name: name, Type: rrType, Class: class, TTL: ttl}
+	ip := net.ParseIP(c.content)
+	switch {
+	case rrType == dnsmessage.TypeA && ip.To4() != nil:
+		return b.AResource(h, dnsmessage.AResource{A: [4]byte(ip.To4())})
+	case rrType == dnsmessage.TypeAAAA && ip != nil && ip.To4() == nil:
+		return b.AAAAResource(h, dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+	case rrType == dnsmessage.TypeTXT:
+		return b.TXTResource(h, dnsmessage.TXTResource{TXT: txtStrings(c.content)})
+	}
+	return fmt.Errorf("invalid %s record content %q", c.recordType, c.content)
+}
+
+// txtStrings splits TXT record content into strings of at most 255
+// characters. Content in quotes, as Cloudflare shows it, is unquoted
+// first.
+func txtStrings(content string) []string {
+	if strings.HasPrefix(content, `"`) {
+		content = unquoteTXT(content)
+	}
+	var txt []string
+	for {
+		n := min(len(content), 255)
+		txt = append(txt, content[:n])
+		if content = content[n:]; content == "" {
+			return txt
+		}
+	}
+}
+
+// unquoteTXT joins the quoted strings in content, dropping anything
+// outside quotes.
+func unquoteTXT(content string) string {
+	var b strings.Builder
+	quoted, escaped := false, false
+	for i := 0; i < len(content); i++ {
+		switch ch := content[i]; {
+		case escaped:
+			b.WriteByte(ch)
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// exchange sends msg to the server over TCP, signed if we have a key, and
+// returns the answers in its response, which must be signed if the
+// request was.
+func (p *Provider) exchange(ctx context.Context, operation string, msg []byte) ([]dnsmessage.Resource, error) {
+	var requestMAC []byte
+	if p.key != nil {
+		msg, requestMAC = p.key.sign(msg, p.clock.Now())
+	}
+	spanCtx, span := otlp.StartSpanKind(ctx, "rfc2136 "+operation, otlp.SpanKindClient, "server.address", p.server)
+	start := time.Now()
+	res, err := p.roundTrip(spanCtx, msg)
+	rfc2136Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	var answers []dnsmessage.Resource
+	if err == nil {
+		answers, err = p.parseResponse(msg, res, requestMAC)
+	}
+	span.End(err)
+	return answers, err
+}
+
+// roundTrip sends msg and reads the response.
+func (p *Provider) roundTrip(ctx context.Context, msg []byte) ([]byte, error) {
+	dialer := net.Dialer{Timeout: requestTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", p.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(requestTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// the deadline doesn't notice ctx being cancelled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	res := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// parseResponse checks that res answers req, verifies its signature, and
+// returns its answers, or a ResponseError if it reports an error.
+func (p *Provider) parseResponse(req, res, requestMAC []byte) ([]dnsmessage.Resource, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if header.ID != binary.BigEndian.Uint16(req) || !header.Response {
+		return nil, errors.New("DNS response doesn't match the request")
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if err := parser.SkipAllAuthorities(); err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	additionals, err := parser.AllAdditionals()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if p.key != nil {
+		if len(additionals) == 0 {
+			if header.RCode != dnsmessage.RCodeSuccess {
+				// servers don't sign errors for requests they can't parse
+				return nil, &ResponseError{RCode: header.RCode}
+			}
+			return nil, errors.New("DNS response isn't signed")
+		}
+		if err := p.key.verify(res, additionals[len(additionals)-1], requestMAC, p.clock.Now()); err != nil {
+			return nil, err
+		}
+	}
+	if header.RCode != dnsmessage.RCodeSuccess {
+		return nil, &ResponseError{RCode: header.RCode}
+	}
+	return answers, nil
+}
+
+func newID() uint16 {
+	var b [2]byte
+	_, _ = rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
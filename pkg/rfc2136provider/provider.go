@@ -0,0 +1,201 @@
+// Package rfc2136provider implements provider.Provider for authoritative
+// servers that accept DNS UPDATE messages (RFC 2136), such as BIND and
+// Knot, optionally authenticated with a TSIG key.
+package rfc2136provider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// defaultTTL is the TTL of records we create if none is configured.
+const defaultTTL = 300
+
+// Config describes the server and key.
+type Config struct {
+	// Server is the primary server for the zone, as host[:port].
+	Server string
+	// KeyName, KeyAlgorithm and KeySecret are the TSIG key, with the
+	// secret base64 encoded as in BIND's key files. The algorithm is
+	// hmac-sha256 if empty. Requests are unsigned if KeyName is empty.
+	KeyName      string
+	KeyAlgorithm string
+	KeySecret    string
+	// TTL is the TTL of records we create, five minutes if zero.
+	TTL int
+	// Clock signs requests, the real time if nil.
+	Clock clock.Clock
+}
+
+// Provider manages A, AAAA and TXT records by querying the server and
+// sending it updates, over TCP. A zone's ID is its name, and a record's ID
+// is its name, type and content. DNS records have no comments, so any
+// comment is dropped.
+type Provider struct {
+	config Config
+	server string
+	key    *tsigKey
+	clock  clock.Clock
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// ResponseError is an error response from the server.
+type ResponseError struct {
+	RCode dnsmessage.RCode
+	// TSIGError is the error the server found with our signature, if any.
+	TSIGError uint16
+}
+
+var (
+	rcodeNames = map[dnsmessage.RCode]string{
+		0: "NOERROR", 1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP", 5: "REFUSED",
+		6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE",
+	}
+	tsigErrorNames = map[uint16]string{16: "BADSIG", 17: "BADKEY", 18: "BADTIME", 22: "BADTRUNC"}
+)
+
+func (e *ResponseError) Error() string {
+	rcode := cmp.Or(rcodeNames[e.RCode], fmt.Sprintf("RCODE %d", e.RCode))
+	if e.TSIGError != 0 {
+		return fmt.Sprintf("DNS server returned %s, TSIG error %s", rcode, cmp.Or(tsigErrorNames[e.TSIGError], fmt.Sprint(e.TSIGError)))
+	}
+	return fmt.Sprintf("DNS server returned %s", rcode)
+}
+
+// New returns a Provider for the configured server.
+func New(config Config) (*Provider, error) {
+	if config.Server == "" {
+		return nil, errors.New("RFC 2136 updates need a server")
+	}
+	p := &Provider{config: config, server: config.Server, clock: config.Clock}
+	if _, _, err := net.SplitHostPort(p.server); err != nil {
+		p.server = net.JoinHostPort(p.server, "53")
+	}
+	if config.KeyName != "" {
+		var err error
+		if p.key, err = newTSIGKey(config.KeyName, cmp.Or(config.KeyAlgorithm, "hmac-sha256"), config.KeySecret); err != nil {
+			return nil, err
+		}
+	}
+	if p.clock == nil {
+		p.clock = clock.System{}
+	}
+	return p, nil
+}
+
+// ResolveZone checks that the server has the zone's SOA record, and
+// returns its name as its ID.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	answers, err := p.query(ctx, "get_zone", zone, dnsmessage.TypeSOA)
+	var resErr *ResponseError
+	if errors.As(err, &resErr) && resErr.RCode == dnsmessage.RCodeNameError {
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	for _, rr := range answers {
+		if rr.Header.Type == dnsmessage.TypeSOA && canonicalName(rr.Header.Name.String()) == canonicalName(zone) {
+			return strings.TrimSuffix(zone, "."), nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	rrType, err := parseType(recordType)
+	if err != nil {
+		return nil, err
+	}
+	answers, err := p.query(ctx, "get_record", name, rrType)
+	var resErr *ResponseError
+	if errors.As(err, &resErr) && resErr.RCode == dnsmessage.RCodeNameError {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recs []provider.Record
+	for _, rr := range answers {
+		if rr.Header.Type != rrType || canonicalName(rr.Header.Name.String()) != canonicalName(name) {
+			continue
+		}
+		var content string
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			content = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			content = net.IP(body.AAAA[:]).String()
+		case *dnsmessage.TXTResource:
+			content = strings.Join(body.TXT, "")
+		}
+		recs = append(recs, convertRecord(name, recordType, content, rr.Header.TTL))
+	}
+	return recs, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	ttl := uint32(cmp.Or(p.config.TTL, defaultTTL))
+	add := change{name: rec.Name, recordType: rec.Type, content: rec.Content, ttl: ttl}
+	if err := p.update(ctx, "create_record", zoneID, nil, []change{add}); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(rec.Name, rec.Type, rec.Content, ttl), nil
+}
+
+// UpdateRecord deletes the record's old content, named in its ID, and adds
+// the new content with the same TTL, in a single update.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	ttl, ok := rec.Extra.(uint32)
+	if !ok {
+		ttl = uint32(cmp.Or(p.config.TTL, defaultTTL))
+	}
+	var deletes []change
+	if fields := strings.SplitN(rec.ID, " ", 3); len(fields) == 3 {
+		deletes = append(deletes, change{name: rec.Name, recordType: rec.Type, content: fields[2]})
+	}
+	add := change{name: rec.Name, recordType: rec.Type, content: rec.Content, ttl: ttl}
+	if err := p.update(ctx, "update_record", zoneID, deletes, []change{add}); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(rec.Name, rec.Type, rec.Content, ttl), nil
+}
+
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	del := change{name: rec.Name, recordType: rec.Type, content: rec.Content}
+	return p.update(ctx, "delete_record", zoneID, []change{del}, nil)
+}
+
+// parseType returns the DNS type of the record types we support.
+func parseType(recordType string) (dnsmessage.Type, error) {
+	switch recordType {
+	case "A":
+		return dnsmessage.TypeA, nil
+	case "AAAA":
+		return dnsmessage.TypeAAAA, nil
+	case "TXT":
+		return dnsmessage.TypeTXT, nil
+	}
+	return 0, fmt.Errorf("%s records are not supported by RFC 2136 updates", recordType)
+}
+
+func convertRecord(name, recordType, content string, ttl uint32) provider.Record {
+	name = strings.TrimSuffix(name, ".")
+	return provider.Record{
+		ID:      name + " " + recordType + " " + content,
+		Name:    name,
+		Type:    recordType,
+		Content: content,
+		Extra:   ttl,
+	}
+}
@@ -0,0 +1,217 @@
+package rfc2136provider
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	typeTSIG  = 250
+	classANY  = 255
+	tsigFudge = 300
+)
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1.":   sha1.New,
+	"hmac-sha224.": sha256.New224,
+	"hmac-sha256.": sha256.New,
+	"hmac-sha384.": sha512.New384,
+	"hmac-sha512.": sha512.New,
+}
+
+// tsigKey signs requests and checks responses with a shared secret, as
+// described in RFC 8945.
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+// newTSIGKey returns a key with the given name, algorithm, such as
+// hmac-sha256, and base64 encoded secret, as found in BIND's key files.
+func newTSIGKey(name, algorithm, secret string) (*tsigKey, error) {
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, ".")) + "."
+	if _, ok := tsigAlgorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unsupported TSIG algorithm %s", strings.TrimSuffix(algorithm, "."))
+	}
+	b, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret: %w", err)
+	}
+	return &tsigKey{name: canonicalName(name), algorithm: algorithm, secret: b}, nil
+}
+
+// sign returns msg with a TSIG record added, and the MAC that the
+// response's must be computed with.
+func (k *tsigKey) sign(msg []byte, now time.Time) ([]byte, []byte) {
+	timeSigned := uint64(now.Unix())
+	mac := k.mac(nil, msg, k.variables(timeSigned, tsigFudge, 0, nil))
+
+	rdata := appendName(nil, k.algorithm)
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...) // original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+	rdata = binary.BigEndian.AppendUint16(rdata, 0)
+
+	signed := append([]byte(nil), msg...)
+	signed = appendName(signed, k.name)
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = binary.BigEndian.AppendUint16(signed, classANY)
+	signed = binary.BigEndian.AppendUint32(signed, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, mac
+}
+
+// verify checks the TSIG record of res, a response to a request signed
+// with requestMAC. tsig is the record, which must be the last additional
+// one. A TSIG error reported by the server is returned as a
+// ResponseError.
+func (k *tsigKey) verify(res []byte, tsig dnsmessage.Resource, requestMAC []byte, now time.Time) error {
+	unknown, ok := tsig.Body.(*dnsmessage.UnknownResource)
+	if !ok || tsig.Header.Type != typeTSIG {
+		return errors.New("response isn't signed")
+	}
+	if canonicalName(tsig.Header.Name.String()) != k.name {
+		return fmt.Errorf("response is signed with unknown key %s", tsig.Header.Name)
+	}
+	r, err := parseTSIG(unknown.Data)
+	if err != nil {
+		return err
+	}
+	if r.error != 0 {
+		var header dnsmessage.Header
+		var p dnsmessage.Parser
+		header, _ = p.Start(res)
+		return &ResponseError{RCode: header.RCode, TSIGError: r.error}
+	}
+	if r.algorithm != k.algorithm {
+		return fmt.Errorf("response is signed with algorithm %s", r.algorithm)
+	}
+	// the TSIG record is the last thing in the message, and its owner
+	// name is never compressed
+	size := len(appendName(nil, k.name)) + 10 + len(unknown.Data)
+	if size > len(res) {
+		return errors.New("malformed TSIG record")
+	}
+	stripped := append([]byte(nil), res[:len(res)-size]...)
+	binary.BigEndian.PutUint16(stripped[0:], r.originalID)
+	binary.BigEndian.PutUint16(stripped[10:], binary.BigEndian.Uint16(stripped[10:])-1)
+	prefix := binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC)))
+	prefix = append(prefix, requestMAC...)
+	mac := k.mac(prefix, stripped, k.variables(r.timeSigned, r.fudge, r.error, r.other))
+	if !hmac.Equal(mac, r.mac) {
+		return errors.New("response has a bad TSIG signature")
+	}
+	if skew := now.Unix() - int64(r.timeSigned); skew > int64(r.fudge) || -skew > int64(r.fudge) {
+		return fmt.Errorf("response was signed %d seconds away from our clock", skew)
+	}
+	return nil
+}
+
+// mac returns the HMAC of the concatenation of parts.
+func (k *tsigKey) mac(parts ...[]byte) []byte {
+	h := hmac.New(tsigAlgorithms[k.algorithm], k.secret)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// variables returns the TSIG variables that are signed along with a
+// message.
+func (k *tsigKey) variables(timeSigned uint64, fudge, tsigError uint16, other []byte) []byte {
+	b := appendName(nil, k.name)
+	b = binary.BigEndian.AppendUint16(b, classANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = appendName(b, k.algorithm)
+	b = appendUint48(b, timeSigned)
+	b = binary.BigEndian.AppendUint16(b, fudge)
+	b = binary.BigEndian.AppendUint16(b, tsigError)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(other)))
+	return append(b, other...)
+}
+
+type tsigRecord struct {
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	error      uint16
+	other      []byte
+}
+
+// parseTSIG decodes the data of a TSIG record.
+func parseTSIG(b []byte) (tsigRecord, error) {
+	var r tsigRecord
+	malformed := errors.New("malformed TSIG record")
+	var labels []string
+	for {
+		if len(b) == 0 || int(b[0]) >= len(b) || b[0]&0xc0 != 0 {
+			return r, malformed
+		}
+		n := int(b[0])
+		labels = append(labels, strings.ToLower(string(b[1:1+n])))
+		b = b[1+n:]
+		if n == 0 {
+			break
+		}
+	}
+	r.algorithm = strings.Join(labels, ".")
+	if len(b) < 10 {
+		return r, malformed
+	}
+	r.timeSigned = uint64(binary.BigEndian.Uint16(b))<<32 | uint64(binary.BigEndian.Uint32(b[2:]))
+	r.fudge = binary.BigEndian.Uint16(b[6:])
+	n := int(binary.BigEndian.Uint16(b[8:]))
+	b = b[10:]
+	if len(b) < n+6 {
+		return r, malformed
+	}
+	r.mac, b = b[:n], b[n:]
+	r.originalID = binary.BigEndian.Uint16(b)
+	r.error = binary.BigEndian.Uint16(b[2:])
+	n = int(binary.BigEndian.Uint16(b[4:]))
+	if len(b) < n+6 {
+		return r, malformed
+	}
+	r.other = b[6 : 6+n]
+	return r, nil
+}
+
+// canonicalName returns name in lower case with a trailing dot.
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+// appendName appends name, which must be canonical, in uncompressed wire
+// format.
+func appendName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		if label != "" {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0)
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
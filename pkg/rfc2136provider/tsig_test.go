@@ -0,0 +1,177 @@
+package rfc2136provider
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// The expected messages below were assembled by hand from the layout in
+// RFC 8945 and signed with a plain HMAC-SHA256, independently of tsig.go.
+// The request is an UPDATE for example.com with ID 0x1234 and the
+// response echoes it back.
+
+const (
+	testRequest  = "123428000001000000000000076578616d706c6503636f6d0000060001"
+	testSigned   = "123428000001000000000001076578616d706c6503636f6d0000060001076b65796e616d650000fa00ff00000000003d0b686d61632d7368613235360000006553f100012c00203330f77822b5f75248ce1acf63e2f4dc4c724d1723a84cd5f9a28d9a9bf4368d123400000000"
+	testMAC      = "3330f77822b5f75248ce1acf63e2f4dc4c724d1723a84cd5f9a28d9a9bf4368d"
+	testResponse = "1234a8000001000000000001076578616d706c6503636f6d0000060001076b65796e616d650000fa00ff00000000003d0b686d61632d7368613235360000006553f100012c00207d82ab4b426ea287368d024e92d1e9c86d6440cdca7df35e22e33fe12f6b256d123400000000"
+)
+
+var testTime = time.Unix(1700000000, 0)
+
+func testKey(t *testing.T) *tsigKey {
+	t.Helper()
+	// the secret is "secretsecretsecret"
+	k, err := newTSIGKey("KeyName", "HMAC-SHA256", "c2VjcmV0c2VjcmV0c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// lastAdditional returns the last additional record of msg, as exchange
+// does before verifying.
+func lastAdditional(t *testing.T, msg []byte) dnsmessage.Resource {
+	t.Helper()
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SkipAllAnswers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		t.Fatal(err)
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(additionals) == 0 {
+		t.Fatal("message has no additional records")
+	}
+	return additionals[len(additionals)-1]
+}
+
+func TestTSIGSign(t *testing.T) {
+	signed, mac := testKey(t).sign(mustDecode(t, testRequest), testTime)
+	if got := hex.EncodeToString(mac); got != testMAC {
+		t.Errorf("MAC is %s, want %s", got, testMAC)
+	}
+	if got := hex.EncodeToString(signed); got != testSigned {
+		t.Errorf("signed message is\n%s\nwant\n%s", got, testSigned)
+	}
+}
+
+func TestTSIGVerify(t *testing.T) {
+	k := testKey(t)
+	res := mustDecode(t, testResponse)
+	requestMAC := mustDecode(t, testMAC)
+	if err := k.verify(res, lastAdditional(t, res), requestMAC, testTime); err != nil {
+		t.Fatal(err)
+	}
+	// verify must not change the response it was given
+	if got := hex.EncodeToString(res); got != testResponse {
+		t.Errorf("verify modified the response: %s", got)
+	}
+}
+
+func TestTSIGVerifyTampered(t *testing.T) {
+	k := testKey(t)
+	requestMAC := mustDecode(t, testMAC)
+	tests := []struct {
+		name   string
+		tamper func(res, requestMAC []byte)
+	}{
+		{"response code", func(res, _ []byte) { res[3] |= 0x05 }},
+		{"question", func(res, _ []byte) { res[13] = 'E' }},
+		{"time signed", func(res, _ []byte) { res[len(res)-47]++ }},
+		{"MAC", func(res, _ []byte) { res[len(res)-10] ^= 0xff }},
+		{"request MAC", func(_, requestMAC []byte) { requestMAC[0] ^= 0xff }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := mustDecode(t, testResponse)
+			reqMAC := bytes.Clone(requestMAC)
+			tt.tamper(res, reqMAC)
+			err := k.verify(res, lastAdditional(t, res), reqMAC, testTime)
+			if err == nil || err.Error() != "response has a bad TSIG signature" {
+				t.Errorf("verify returned %v, want a bad signature", err)
+			}
+		})
+	}
+}
+
+func TestTSIGVerifyClockSkew(t *testing.T) {
+	k := testKey(t)
+	res := mustDecode(t, testResponse)
+	requestMAC := mustDecode(t, testMAC)
+	for _, now := range []time.Time{testTime.Add(-301 * time.Second), testTime.Add(301 * time.Second)} {
+		err := k.verify(res, lastAdditional(t, res), requestMAC, now)
+		if err == nil || !strings.Contains(err.Error(), "away from our clock") {
+			t.Errorf("verify at %v returned %v, want a clock skew error", now.Sub(testTime), err)
+		}
+	}
+	if err := k.verify(res, lastAdditional(t, res), requestMAC, testTime.Add(300*time.Second)); err != nil {
+		t.Errorf("verify within the fudge returned %v", err)
+	}
+}
+
+func TestTSIGVerifyError(t *testing.T) {
+	k := testKey(t)
+	// a NOTAUTH response with BADKEY (17) carries an empty MAC and isn't verifiable
+	res := mustDecode(t, "1234a8090001000000000001076578616d706c6503636f6d0000060001"+
+		"076b65796e616d650000fa00ff00000000001d"+
+		"0b686d61632d7368613235360000006553f100012c0000123400110000")
+	err := k.verify(res, lastAdditional(t, res), mustDecode(t, testMAC), testTime)
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) {
+		t.Fatalf("verify returned %v, want a ResponseError", err)
+	}
+	if resErr.RCode != 9 || resErr.TSIGError != 17 {
+		t.Errorf("verify returned rcode %v and TSIG error %d", resErr.RCode, resErr.TSIGError)
+	}
+}
+
+func TestParseTSIGTruncated(t *testing.T) {
+	res := mustDecode(t, testResponse)
+	data := lastAdditional(t, res).Body.(*dnsmessage.UnknownResource).Data
+	if _, err := parseTSIG(data); err != nil {
+		t.Fatalf("parsing the whole record: %v", err)
+	}
+	for n := range len(data) {
+		if _, err := parseTSIG(data[:n]); err == nil || err.Error() != "malformed TSIG record" {
+			t.Errorf("parsing %d of %d bytes returned %v", n, len(data), err)
+		}
+	}
+	// a compression pointer in the algorithm name isn't allowed
+	if _, err := parseTSIG(append([]byte{0xc0, 0x0c}, data[13:]...)); err == nil {
+		t.Error("parsing a compressed algorithm name succeeded")
+	}
+	// a MAC or other data length running past the end of the record
+	for _, off := range []int{len(data) - 40, len(data) - 2} {
+		b := bytes.Clone(data)
+		b[off] = 0xff
+		if _, err := parseTSIG(b); err == nil || err.Error() != "malformed TSIG record" {
+			t.Errorf("parsing with a bad length at %d returned %v", off, err)
+		}
+	}
+}
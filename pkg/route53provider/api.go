@@ -0,0 +1,166 @@
+package route53provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+)
+
+// Route 53 is a global service, signed for us-east-1.
+const (
+	defaultEndpoint = "https://route53.amazonaws.com"
+	signingRegion   = "us-east-1"
+	signingService  = "route53"
+	apiVersion      = "2013-04-01"
+	apiNamespace    = "https://route53.amazonaws.com/doc/2013-04-01/"
+)
+
+// APIError is an error returned by the Route 53 API, or by STS.
+type APIError struct {
+	Status  string
+	Type    string
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("AWS returned %s", e.Status)
+	}
+	return fmt.Sprintf("AWS returned %s: %s: %s", e.Status, e.Code, e.Message)
+}
+
+// parseError decodes an AWS XML error response. Rejected changes come back
+// as an InvalidChangeBatch document listing the reasons instead.
+func parseError(status string, body []byte) error {
+	var doc struct {
+		XMLName  xml.Name
+		Type     string   `xml:"Error>Type"`
+		Code     string   `xml:"Error>Code"`
+		Message  string   `xml:"Error>Message"`
+		Messages []string `xml:"Messages>Message"`
+	}
+	_ = xml.Unmarshal(body, &doc)
+	apiErr := &APIError{Status: status, Type: doc.Type, Code: doc.Code, Message: doc.Message}
+	if doc.XMLName.Local == "InvalidChangeBatch" {
+		apiErr.Code, apiErr.Message = doc.XMLName.Local, strings.Join(doc.Messages, "; ")
+	}
+	return apiErr
+}
+
+// resourceRecordSet is a Route 53 record set. The fields are in the order
+// the API requires. Routing settings we don't use are kept as raw XML so
+// they are written back unchanged.
+type resourceRecordSet struct {
+	Name                    string           `xml:"Name"`
+	Type                    string           `xml:"Type"`
+	SetIdentifier           string           `xml:"SetIdentifier,omitempty"`
+	Weight                  *int64           `xml:"Weight,omitempty"`
+	Region                  string           `xml:"Region,omitempty"`
+	GeoLocation             *rawXML          `xml:"GeoLocation,omitempty"`
+	Failover                string           `xml:"Failover,omitempty"`
+	MultiValueAnswer        *bool            `xml:"MultiValueAnswer,omitempty"`
+	TTL                     int64            `xml:"TTL,omitempty"`
+	ResourceRecords         []resourceRecord `xml:"ResourceRecords>ResourceRecord,omitempty"`
+	AliasTarget             *rawXML          `xml:"AliasTarget,omitempty"`
+	HealthCheckID           string           `xml:"HealthCheckId,omitempty"`
+	TrafficPolicyInstanceID string           `xml:"TrafficPolicyInstanceId,omitempty"`
+	CidrRoutingConfig       *rawXML          `xml:"CidrRoutingConfig,omitempty"`
+	GeoProximityLocation    *rawXML          `xml:"GeoProximityLocation,omitempty"`
+}
+
+type resourceRecord struct {
+	Value string `xml:"Value"`
+}
+
+type rawXML struct {
+	Inner string `xml:",innerxml"`
+}
+
+type change struct {
+	Action            string            `xml:"Action"`
+	ResourceRecordSet resourceRecordSet `xml:"ResourceRecordSet"`
+}
+
+type changeRequest struct {
+	XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Comment string   `xml:"ChangeBatch>Comment,omitempty"`
+	Changes []change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type hostedZone struct {
+	ID          string `xml:"Id"`
+	Name        string `xml:"Name"`
+	PrivateZone bool   `xml:"Config>PrivateZone"`
+}
+
+var route53Duration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "cfdnsupdater_route53_request_duration_seconds",
+	Help:    "How long Route 53 API requests take, by operation",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation"})
+
+// call makes a signed request to the API for operation, encoding in as the
+// XML body if it isn't nil and decoding the XML response into result.
+func (p *Provider) call(ctx context.Context, operation, method, path string, query url.Values, in, result any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = xml.Marshal(in); err != nil {
+			return err
+		}
+		body = append([]byte(xml.Header), body...)
+	}
+	u := strings.TrimSuffix(p.endpoint, "/") + "/" + apiVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	creds, err := p.credentials.get(ctx, p.clock.Now())
+	if err != nil {
+		return err
+	}
+	signRequest(req, body, creds, signingRegion, signingService, p.clock.Now())
+	_, span := otlp.StartSpanKind(ctx, "route53 "+operation, otlp.SpanKindClient, "http.request.method", method)
+	start := time.Now()
+	res, err := p.client.Do(req)
+	route53Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err == nil && res.StatusCode != http.StatusOK {
+		err = parseError(res.Status, b)
+	}
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return nil
+	}
+	if err := xml.NewDecoder(bytes.NewReader(b)).Decode(result); err != nil {
+		return fmt.Errorf("invalid Route 53 response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package route53provider
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeouts for the credential sources which are only reachable on AWS, so
+// that looking for them elsewhere fails quickly.
+const (
+	metadataTimeout = 2 * time.Second
+	// credentialsRefreshWindow is how long before they expire temporary
+	// credentials are replaced.
+	credentialsRefreshWindow = 5 * time.Minute
+)
+
+// Endpoints for the container and EC2 instance metadata credentials.
+const (
+	containerCredentialsHost = "http://169.254.170.2"
+	instanceMetadataHost     = "http://169.254.169.254"
+)
+
+// Credentials are AWS access keys, with a session token and expiry if they
+// are temporary.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// credentialSource resolves credentials the way the AWS SDKs do, trying in
+// turn the environment, a web identity token, the shared credentials file,
+// the container credentials endpoint and the EC2 instance metadata service.
+// Temporary credentials are cached until shortly before they expire.
+type credentialSource struct {
+	client *http.Client
+
+	mu     sync.Mutex
+	cached Credentials
+}
+
+func (s *credentialSource) get(ctx context.Context, now time.Time) (Credentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached.AccessKeyID != "" && (s.cached.Expires.IsZero() || now.Add(credentialsRefreshWindow).Before(s.cached.Expires)) {
+		return s.cached, nil
+	}
+	creds, err := s.resolve(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("resolving AWS credentials: %w", err)
+	}
+	s.cached = creds
+	return creds, nil
+}
+
+func (s *credentialSource) resolve(ctx context.Context) (Credentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if secret == "" {
+			return Credentials{}, errors.New("AWS_ACCESS_KEY_ID is set but AWS_SECRET_ACCESS_KEY is not")
+		}
+		return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return s.webIdentity(ctx, tokenFile, os.Getenv("AWS_ROLE_ARN"))
+	}
+	creds, found, err := sharedCredentials()
+	if found || err != nil {
+		return creds, err
+	}
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return s.container(ctx)
+	}
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return Credentials{}, errors.New("no credentials found in the environment or the shared credentials file")
+	}
+	creds, err = s.instance(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("no credentials found in the environment or the shared credentials file, and none from the instance metadata service: %w", err)
+	}
+	return creds, nil
+}
+
+// sharedCredentials reads the profile named by AWS_PROFILE, or the default
+// one, from the shared credentials file. found is false if there is no
+// such file or profile.
+func sharedCredentials() (creds Credentials, found bool, err error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, false, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Credentials{}, false, nil
+	} else if err != nil {
+		return Credentials{}, false, err
+	}
+	defer f.Close()
+	profile := cmp.Or(os.Getenv("AWS_PROFILE"), "default")
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		found = true
+		key, value, _ := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, false, err
+	}
+	if found && (creds.AccessKeyID == "" || creds.SecretAccessKey == "") {
+		return Credentials{}, true, fmt.Errorf("profile %s in %s has no access keys; only static keys are supported", profile, path)
+	}
+	return creds, found, nil
+}
+
+// webIdentity exchanges an OIDC token, as given to Kubernetes service
+// accounts, for temporary credentials for roleARN.
+func (s *credentialSource) webIdentity(ctx context.Context, tokenFile, roleARN string) (Credentials, error) {
+	if roleARN == "" {
+		return Credentials{}, errors.New("AWS_WEB_IDENTITY_TOKEN_FILE is set but AWS_ROLE_ARN is not")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	endpoint := "https://sts.amazonaws.com/"
+	if region := cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")); region != "" {
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", region)
+	}
+	endpoint = cmp.Or(os.Getenv("AWS_ENDPOINT_URL_STS"), endpoint)
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {cmp.Or(os.Getenv("AWS_ROLE_SESSION_NAME"), "cfdnsupdater")},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return Credentials{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return Credentials{}, parseError(res.Status, body)
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return Credentials{}, fmt.Errorf("invalid STS response: %w", err)
+	}
+	c := result.Credentials
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+// metadataCredentials is the JSON form of credentials from the container
+// and instance metadata endpoints.
+type metadataCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// container fetches the task role's credentials on ECS, or the pod
+// identity's on EKS.
+func (s *credentialSource) container(ctx context.Context) (Credentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = containerCredentialsHost + relative
+	}
+	header := http.Header{}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return Credentials{}, err
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		header.Set("Authorization", token)
+	}
+	var c metadataCredentials
+	if err := s.metadata(ctx, http.MethodGet, endpoint, header, &c); err != nil {
+		return Credentials{}, fmt.Errorf("container credentials: %w", err)
+	}
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}, nil
+}
+
+// instance fetches the EC2 instance profile's credentials using IMDSv2.
+func (s *credentialSource) instance(ctx context.Context) (Credentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	var token string
+	header := http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}}
+	if err := s.metadata(ctx, http.MethodPut, instanceMetadataHost+"/latest/api/token", header, &token); err != nil {
+		return Credentials{}, err
+	}
+	header = http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	var roles string
+	if err := s.metadata(ctx, http.MethodGet, instanceMetadataHost+"/latest/meta-data/iam/security-credentials/", header, &roles); err != nil {
+		return Credentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return Credentials{}, errors.New("the instance has no IAM role")
+	}
+	var c metadataCredentials
+	if err := s.metadata(ctx, http.MethodGet, instanceMetadataHost+"/latest/meta-data/iam/security-credentials/"+role, header, &c); err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}, nil
+}
+
+// metadata makes a request to a metadata endpoint, decoding the JSON
+// response into result, or storing it as it is if result is a *string.
+// These endpoints are link-local, so any proxy is bypassed.
+func (s *credentialSource) metadata(ctx context.Context, method, endpoint string, header http.Header, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	res, err := metadataClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %s from %s", res.Status, endpoint)
+	}
+	if text, ok := result.(*string); ok {
+		*text = string(body)
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// metadataClient never uses a proxy.
+var metadataClient = &http.Client{Transport: &http.Transport{Proxy: nil}}
@@ -0,0 +1,290 @@
+// Package route53provider implements provider.Provider for AWS Route 53.
+// Requests are signed here rather than with the AWS SDK, and credentials
+// are found the way the SDKs find them: from the environment, a web
+// identity token, the shared credentials file, or the container or EC2
+// instance metadata services.
+package route53provider
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// defaultTTL is the TTL of records we create if none is configured.
+const defaultTTL = 300
+
+// Config describes the hosted zone and how to reach Route 53.
+type Config struct {
+	// Zone is the hosted zone's name. ZoneID, if set, is its ID, so that
+	// it isn't looked up by name, which also picks a private zone.
+	Zone   string
+	ZoneID string
+	// TTL is the TTL of records we create, five minutes if zero.
+	TTL int
+	// Endpoint overrides the API endpoint, for mock servers.
+	Endpoint string
+	// HTTPClient makes API requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Clock signs requests and expires credentials, the real time if nil.
+	Clock clock.Clock
+}
+
+// Provider manages records through the Route 53 API. Route 53 records
+// have no IDs or comments; a record's ID is its name and type, and its set
+// identifier if it has one. Each value of a record set is a separate
+// provider.Record.
+type Provider struct {
+	config      Config
+	endpoint    string
+	client      *http.Client
+	clock       clock.Clock
+	credentials *credentialSource
+
+	mu    sync.Mutex
+	zones map[string]string
+}
+
+var _ provider.RecordDeleter = (*Provider)(nil)
+
+// New returns a Provider for the configured zone. Unless Endpoint is set,
+// the API endpoint can be overridden with AWS_ENDPOINT_URL_ROUTE_53 or
+// AWS_ENDPOINT_URL, as with the AWS SDKs.
+func New(config Config) *Provider {
+	p := &Provider{
+		config:   config,
+		endpoint: cmp.Or(config.Endpoint, os.Getenv("AWS_ENDPOINT_URL_ROUTE_53"), os.Getenv("AWS_ENDPOINT_URL"), defaultEndpoint),
+		client:   config.HTTPClient,
+		clock:    config.Clock,
+		zones:    map[string]string{},
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+	if p.clock == nil {
+		p.clock = clock.System{}
+	}
+	p.credentials = &credentialSource{client: p.client}
+	return p
+}
+
+// ResolveZone returns the configured zone ID for the configured zone, and
+// otherwise looks up the public hosted zone with that name, caching the
+// result.
+func (p *Provider) ResolveZone(ctx context.Context, zone string) (string, error) {
+	if p.config.ZoneID != "" && zone == p.config.Zone {
+		return strings.TrimPrefix(p.config.ZoneID, "/hostedzone/"), nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id, ok := p.zones[zone]; ok {
+		return id, nil
+	}
+	var res struct {
+		HostedZones []hostedZone `xml:"HostedZones>HostedZone"`
+	}
+	query := url.Values{"dnsname": {fqdn(zone)}, "maxitems": {"100"}}
+	if err := p.call(ctx, "list_zones", http.MethodGet, "/hostedzonesbyname", query, nil, &res); err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	var ids []string
+	for _, z := range res.HostedZones {
+		if sameName(z.Name, zone) && !z.PrivateZone {
+			ids = append(ids, strings.TrimPrefix(z.ID, "/hostedzone/"))
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", provider.ErrZoneNotFound, zone)
+	case 1:
+		p.zones[zone] = ids[0]
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("zone name %s is ambiguous, set its ID", zone)
+	}
+}
+
+func (p *Provider) GetRecord(ctx context.Context, zoneID, name, recordType string) ([]provider.Record, error) {
+	sets, err := p.recordSets(ctx, zoneID, name, recordType)
+	if err != nil {
+		return nil, err
+	}
+	var recs []provider.Record
+	for _, set := range sets {
+		if set.AliasTarget != nil {
+			return nil, fmt.Errorf("%s %s is an alias record, which can't be pointed at an address", name, recordType)
+		}
+		for _, rr := range set.ResourceRecords {
+			recs = append(recs, convertRecord(set, rr.Value))
+		}
+	}
+	return recs, nil
+}
+
+// recordSets returns the record sets with the given name and type, of
+// which there is more than one only if they have routing policies.
+func (p *Provider) recordSets(ctx context.Context, zoneID, name, recordType string) ([]resourceRecordSet, error) {
+	var res struct {
+		ResourceRecordSets []resourceRecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	query := url.Values{"name": {fqdn(name)}, "type": {recordType}, "maxitems": {"100"}}
+	if err := p.call(ctx, "list_records", http.MethodGet, "/hostedzone/"+zoneID+"/rrset", query, nil, &res); err != nil {
+		return nil, p.zoneError(zoneID, err)
+	}
+	// the listing starts at name and type, and carries on past them
+	var sets []resourceRecordSet
+	for _, set := range res.ResourceRecordSets {
+		if sameName(set.Name, name) && set.Type == recordType {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+func (p *Provider) CreateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set := resourceRecordSet{
+		Name:            fqdn(rec.Name),
+		Type:            rec.Type,
+		TTL:             int64(cmp.Or(p.config.TTL, defaultTTL)),
+		ResourceRecords: []resourceRecord{{Value: encodeValue(rec.Type, rec.Content)}},
+	}
+	if err := p.change(ctx, zoneID, "create_record", "CREATE", set, rec.Comment); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(set, set.ResourceRecords[0].Value), nil
+}
+
+// UpdateRecord replaces the record set's values with the record's
+// content. Its TTL and routing policy are kept.
+func (p *Provider) UpdateRecord(ctx context.Context, zoneID string, rec provider.Record) (provider.Record, error) {
+	set, ok := rec.Extra.(resourceRecordSet)
+	if !ok {
+		set = resourceRecordSet{Name: fqdn(rec.Name), Type: rec.Type, TTL: int64(cmp.Or(p.config.TTL, defaultTTL))}
+	}
+	set.ResourceRecords = []resourceRecord{{Value: encodeValue(rec.Type, rec.Content)}}
+	if err := p.change(ctx, zoneID, "update_record", "UPSERT", set, rec.Comment); err != nil {
+		return provider.Record{}, err
+	}
+	return convertRecord(set, set.ResourceRecords[0].Value), nil
+}
+
+// DeleteRecord removes the record's value from its record set, deleting
+// the set if it was the last one. The set is read again first, since other
+// values may have been removed since rec was read.
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, rec provider.Record) error {
+	sets, err := p.recordSets(ctx, zoneID, rec.Name, rec.Type)
+	if err != nil {
+		return err
+	}
+	value := encodeValue(rec.Type, rec.Content)
+	for _, set := range sets {
+		if convertRecord(set, "").ID != rec.ID {
+			continue
+		}
+		var remaining []resourceRecord
+		for _, rr := range set.ResourceRecords {
+			if rr.Value != value {
+				remaining = append(remaining, rr)
+			}
+		}
+		if len(remaining) == len(set.ResourceRecords) {
+			break
+		}
+		if len(remaining) == 0 {
+			return p.change(ctx, zoneID, "delete_record", "DELETE", set, "")
+		}
+		set.ResourceRecords = remaining
+		return p.change(ctx, zoneID, "delete_record", "UPSERT", set, "")
+	}
+	return fmt.Errorf("%w: %s %s %s", provider.ErrRecordNotFound, rec.Name, rec.Type, rec.Content)
+}
+
+// change applies a single change to the zone.
+func (p *Provider) change(ctx context.Context, zoneID, operation, action string, set resourceRecordSet, comment string) error {
+	req := changeRequest{
+		Xmlns:   apiNamespace,
+		Comment: comment,
+		Changes: []change{{Action: action, ResourceRecordSet: set}},
+	}
+	err := p.call(ctx, operation, http.MethodPost, "/hostedzone/"+zoneID+"/rrset/", nil, req, nil)
+	var apiErr *APIError
+	if action == "DELETE" && errors.As(err, &apiErr) && apiErr.Code == "InvalidChangeBatch" && strings.Contains(apiErr.Message, "not found") {
+		err = fmt.Errorf("%w: %w", provider.ErrRecordNotFound, err)
+	}
+	return p.zoneError(zoneID, err)
+}
+
+// zoneError marks err with provider.ErrZoneChanged if the hosted zone we
+// looked up no longer exists, and forgets it so it is looked up again. A
+// configured zone ID is never looked up, so errors using it are returned
+// as they are.
+func (p *Provider) zoneError(zoneID string, err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != "NoSuchHostedZone" || p.config.ZoneID != "" {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for zone, id := range p.zones {
+		if id == zoneID {
+			delete(p.zones, zone)
+		}
+	}
+	return fmt.Errorf("%w: %w", provider.ErrZoneChanged, err)
+}
+
+// convertRecord returns one value of set as a provider.Record, keeping the
+// set in Extra for updates.
+func convertRecord(set resourceRecordSet, value string) provider.Record {
+	name := strings.TrimSuffix(set.Name, ".")
+	id := name + " " + set.Type
+	if set.SetIdentifier != "" {
+		id += " " + set.SetIdentifier
+	}
+	return provider.Record{
+		ID:      id,
+		Name:    name,
+		Type:    set.Type,
+		Content: value,
+		Extra:   set,
+	}
+}
+
+// fqdn adds the trailing dot Route 53 names have.
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// sameName compares a name from Route 53, which is lower case with a
+// trailing dot, with one of ours.
+func sameName(route53Name, name string) bool {
+	return strings.EqualFold(strings.TrimSuffix(route53Name, "."), strings.TrimSuffix(name, "."))
+}
+
+// encodeValue quotes TXT record content, splitting it into strings of at
+// most 255 characters, unless it is already quoted, as Route 53 keeps it.
+// Other types are written as they are.
+func encodeValue(recordType, content string) string {
+	if recordType != "TXT" || strings.HasPrefix(content, `"`) {
+		return content
+	}
+	var parts []string
+	for {
+		n := min(len(content), 255)
+		part := strings.ReplaceAll(content[:n], `\`, `\\`)
+		parts = append(parts, `"`+strings.ReplaceAll(part, `"`, `\"`)+`"`)
+		content = content[n:]
+		if content == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
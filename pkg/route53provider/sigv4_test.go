@@ -0,0 +1,123 @@
+package route53provider
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The signing cases come from AWS's Signature Version 4 test suite, which
+// signs for service "service" in us-east-1 with the example credentials.
+
+var (
+	testSuiteCreds = Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	testSuiteTime = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+const testSuiteToken = "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA=="
+
+func TestSignRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		token       string
+		want        string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			url:    "https://example.amazonaws.com/",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			url:    "https://example.amazonaws.com/",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:   "post-vanilla-query",
+			method: "POST",
+			url:    "https://example.amazonaws.com/?Param1=value1",
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11",
+		},
+		{
+			name:        "post-x-www-form-urlencoded",
+			method:      "POST",
+			url:         "https://example.amazonaws.com/",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "Param1=value1",
+			want:        "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:   "post-sts-header-before",
+			method: "POST",
+			url:    "https://example.amazonaws.com/",
+			token:  testSuiteToken,
+			want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token, Signature=85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			var body []byte
+			if tt.body != "" {
+				body = []byte(tt.body)
+			}
+			creds := testSuiteCreds
+			creds.SessionToken = tt.token
+			signRequest(req, body, creds, "us-east-1", "service", testSuiteTime)
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization is\n%s\nwant\n%s", got, tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Security-Token"); got != tt.token {
+				t.Errorf("X-Amz-Security-Token is %q, want %q", got, tt.token)
+			}
+		})
+	}
+}
+
+// Services other than S3 sign the escaped path after escaping each
+// segment again, so the percent signs themselves are encoded.
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/2013-04-01/hostedzone/Z123/rrset", "/2013-04-01/hostedzone/Z123/rrset"},
+		{"/example space/", "/example%2520space/"},
+		{"/%E1%88%B4", "/%25E1%2588%25B4"},
+		{"/a%2Fb", "/a%252Fb"},
+		{"/-._~", "/-._~"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse("https://example.amazonaws.com" + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalPath(u); got != tt.want {
+			t.Errorf("canonicalPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package updater
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/redact"
+)
+
+// AuditEntry is one line of the audit log: a change we made, or tried to
+// make, to a DNS record.
+type AuditEntry struct {
+	Time     time.Time `json:"@timestamp"`
+	Action   string    `json:"action"`
+	Zone     string    `json:"zone"`
+	Host     string    `json:"host"`
+	Type     string    `json:"type"`
+	RecordID string    `json:"record_id,omitempty"`
+	OldIP    string    `json:"old_ip,omitempty"`
+	NewIP    string    `json:"new_ip,omitempty"`
+	Content  string    `json:"content,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// AuditLog appends an entry for every change to a JSON lines file kept
+// apart from the operational log. A nil AuditLog records nothing.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditLog opens the audit log at path, creating it if necessary.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: file}, nil
+}
+
+// Record writes the entry with the outcome of the change, syncing it to
+// disk so the history survives a crash.
+func (a *AuditLog) Record(entry AuditEntry, err error) {
+	if a == nil {
+		return
+	}
+	entry.Time = time.Now().UTC()
+	entry.Outcome = "success"
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Error = redact.String(err.Error())
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		slog.Error("Failed to encode audit log entry", "error", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		slog.Error("Failed to sync audit log", "error", err)
+	}
+}
@@ -0,0 +1,146 @@
+package updater
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxCanaryDifferences is how many recent differences are kept for the
+// report.
+const maxCanaryDifferences = 20
+
+var canaryDifferenceCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_canary_differences_total",
+	Help: "The number of times an observe-only instance disagreed with the instance doing the updates",
+}, []string{"kind"})
+
+// CanaryDifference describes one disagreement between our decision and
+// what happened to the record.
+type CanaryDifference struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Kind     string    `json:"kind"`
+	Record   string    `json:"record"`
+	Detected string    `json:"detected"`
+	Message  string    `json:"message"`
+}
+
+// CanaryReport summarises how an observe-only instance's decisions
+// compared with the live record.
+type CanaryReport struct {
+	Cycles      int                `json:"cycles"`
+	Agreements  int                `json:"agreements"`
+	Differences []CanaryDifference `json:"differences"`
+	Pending     *time.Time         `json:"pending_since,omitempty"`
+}
+
+// Canary compares what we would have done each cycle with changes made to
+// the records by the instance that is actually in charge.
+type Canary struct {
+	grace time.Duration
+
+	mu     sync.Mutex
+	hosts  map[string]*canaryHost
+	report CanaryReport
+}
+
+// canaryHost is what we know about one record.
+type canaryHost struct {
+	last    string
+	pending string
+	since   time.Time
+}
+
+// NewCanary returns a Canary which allows the instance in charge grace to
+// make an update we decided on.
+func NewCanary(grace time.Duration) *Canary {
+	return &Canary{grace: grace, hosts: map[string]*canaryHost{}}
+}
+
+func (c *Canary) differ(now time.Time, host, kind, record, detected, message string) {
+	canaryDifferenceCount.WithLabelValues(kind).Inc()
+	slog.Warn("Observe-only decision differs from live record", "fqdn", host, "kind", kind, "record", record, "detected", detected, "detail", message)
+	c.report.Differences = append(c.report.Differences, CanaryDifference{
+		Time:     now,
+		Host:     host,
+		Kind:     kind,
+		Record:   record,
+		Detected: detected,
+		Message:  message,
+	})
+	if len(c.report.Differences) > maxCanaryDifferences {
+		c.report.Differences = c.report.Differences[1:]
+	}
+}
+
+// observe records a cycle where the live record for host contained
+// current (empty if it doesn't exist) and we detected ip.
+func (c *Canary) observe(now time.Time, host, current, ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report.Cycles++
+
+	h, seen := c.hosts[host]
+	if !seen {
+		h = &canaryHost{}
+		c.hosts[host] = h
+	}
+	if seen && current != h.last {
+		switch {
+		case h.pending == "":
+			c.differ(now, host, "unexpected_update", current, ip, "record changed although we would not have changed it")
+		case h.pending != current:
+			c.differ(now, host, "different_update", current, h.pending, "record changed to a different address than we would have used")
+		default:
+			c.report.Agreements++
+		}
+		h.pending = ""
+	}
+	h.last = current
+
+	if current == ip {
+		if h.pending == "" {
+			c.report.Agreements++
+		}
+		h.pending = ""
+		return
+	}
+	slog.Info("Would have updated record", "fqdn", host, "record", current, "ip", ip)
+	if h.pending != ip {
+		h.pending = ip
+		h.since = now
+	}
+	if now.Sub(h.since) > c.grace {
+		c.differ(now, host, "missed_update", current, ip, "we would have updated the record but it has not changed")
+		// start the grace period again rather than reporting every cycle
+		h.since = now
+	}
+}
+
+// Report returns a copy of the comparison so far.
+func (c *Canary) Report() CanaryReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := c.report
+	report.Differences = append([]CanaryDifference(nil), c.report.Differences...)
+	for _, h := range c.hosts {
+		if h.pending != "" && (report.Pending == nil || h.since.Before(*report.Pending)) {
+			since := h.since
+			report.Pending = &since
+		}
+	}
+	return report
+}
+
+func (c *Canary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Report()); err != nil {
+		slog.Error("error when responding with canary report", "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/ipsource"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// Stages of an update cycle, for labelling failures.
+const (
+	StageIPLookup   = "ip_lookup"
+	StageZoneLookup = "zone_lookup"
+	StageRecordList = "record_list"
+	StageCreate     = "create"
+	StageUpdate     = "update"
+	StageDelete     = "delete"
+	StageOwnership  = "ownership"
+)
+
+// ErrTooManyRecords is returned when the host has more records than the
+// configured -multiple-records mode can handle.
+var ErrTooManyRecords = errors.New("too many DNS records")
+
+var stageFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_failures_total",
+	Help: "The number of failures, by host, the stage of the update that failed and the class of error",
+}, []string{"zone", "host", "stage", "cause"})
+
+// Failed counts err as a failure of stage for the configured host and
+// returns it unchanged.
+func Failed(config Config, stage string, err error) error {
+	if err != nil {
+		stageFailures.WithLabelValues(config.Zone, config.Host, stage, ErrorClass(err)).Inc()
+	}
+	return err
+}
+
+// ErrorClass sorts errors into broad causes: timeout, auth, rate_limit,
+// validation, not_found, bad_response, network or other.
+func ErrorClass(err error) string {
+	var limited *provider.RateLimitError
+	var cfErr *cloudflare.Error
+	var netErr net.Error
+	switch {
+	case errors.As(err, &limited):
+		return "rate_limit"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, provider.ErrZoneNotFound), errors.Is(err, provider.ErrRecordNotFound):
+		return "not_found"
+	case errors.Is(err, ErrTooManyRecords):
+		return "validation"
+	case errors.Is(err, ipsource.ErrBadIPResponse):
+		return "bad_response"
+	case errors.As(err, &cfErr):
+		switch cfErr.Type {
+		case cloudflare.ErrorTypeAuthentication, cloudflare.ErrorTypeAuthorization:
+			return "auth"
+		case cloudflare.ErrorTypeRateLimit:
+			return "rate_limit"
+		case cloudflare.ErrorTypeNotFound:
+			return "not_found"
+		case cloudflare.ErrorTypeRequest:
+			return "validation"
+		}
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
@@ -0,0 +1,20 @@
+package updater
+
+import (
+	"context"
+)
+
+// Hooks let applications embedding the updater act on what it does. Any of
+// them may be nil. They are called synchronously, so they should return
+// quickly.
+type Hooks struct {
+	// OnIPDetected is called with the address detected at the start of
+	// each cycle.
+	OnIPDetected func(ctx context.Context, ip string)
+	// OnRecordChanged is called after a record is created or pointed at a
+	// new address.
+	OnRecordChanged func(ctx context.Context, change Change)
+	// OnCycleError is called when a cycle fails, whether detecting the IP
+	// or updating the record.
+	OnCycleError func(ctx context.Context, err error)
+}
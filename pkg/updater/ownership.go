@@ -0,0 +1,42 @@
+package updater
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// managedCommentPrefix starts the comment we put on records we manage.
+// Comments starting "managed by" and naming something else belong to
+// another tool.
+const managedCommentPrefix = "managed by cfdnsupdater"
+
+// managedComment returns the comment for a record we are writing now.
+func managedComment(version string, now time.Time) string {
+	return fmt.Sprintf("%s %s (last update %s)", managedCommentPrefix, version, now.UTC().Format(time.RFC3339))
+}
+
+// CheckOwner returns an error if we have been asked to leave records
+// managed by other tools alone and rec's comment says it is one of them.
+func CheckOwner(config Config, rec provider.Record) error {
+	if !config.RespectOwner {
+		return nil
+	}
+	comment := strings.ToLower(rec.Comment)
+	if strings.HasPrefix(comment, "managed by ") && !strings.HasPrefix(comment, managedCommentPrefix) {
+		return fmt.Errorf("record %s for %s is %q, not modifying it", rec.ID, config.Host, rec.Comment)
+	}
+	return nil
+}
+
+// recordComment returns the comment to set when writing a record, or nil
+// to leave it unchanged.
+func recordComment(config Config, now time.Time) *string {
+	if !config.MarkRecords {
+		return nil
+	}
+	comment := managedComment(config.Version, now)
+	return &comment
+}
@@ -0,0 +1,170 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+var duplicatesDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cfdnsupdater_duplicate_records_deleted_total",
+	Help: "The number of duplicate records deleted while consolidating",
+}, []string{"zone", "host"})
+
+// cachedRecord is what we last knew about a managed record.
+type cachedRecord struct {
+	record  provider.Record
+	checked time.Time
+}
+
+// lookupRecord returns the cached record for the host in the zone, unless
+// it was last checked against the provider more than maxAge ago.
+func (u *Updater) lookupRecord(zoneID string, maxAge time.Duration) (provider.Record, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cached, ok := u.records[zoneID]
+	if !ok || u.clock.Now().Sub(cached.checked) >= maxAge {
+		return provider.Record{}, false
+	}
+	return cached.record, true
+}
+
+// rememberRecord caches the record after reading or writing it.
+func (u *Updater) rememberRecord(zoneID string, rec provider.Record) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.clock.Now()
+	u.records[zoneID] = cachedRecord{record: rec, checked: now}
+	if _, ok := u.writes[zoneID]; !ok {
+		u.writes[zoneID] = now
+	}
+}
+
+// recordWritten notes that we have just written the record.
+func (u *Updater) recordWritten(zoneID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.writes[zoneID] = u.clock.Now()
+}
+
+// forceDue reports whether the record should be rewritten even though its
+// address is already correct, because ForceUpdate has passed
+// since we last wrote it.
+func (u *Updater) forceDue(zoneID string) bool {
+	if u.config.ForceUpdate <= 0 {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	written, ok := u.writes[zoneID]
+	return ok && u.clock.Now().Sub(written) >= u.config.ForceUpdate
+}
+
+// forgetRecord drops the cached record so the next cycle lists it again.
+func (u *Updater) forgetRecord(zoneID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.records, zoneID)
+}
+
+// updatedRecord returns rec pointing at ip, with our comment if we mark
+// records.
+func updatedRecord(config Config, rec provider.Record, ip string, now time.Time) provider.Record {
+	rec.Content = ip
+	if comment := recordComment(config, now); comment != nil {
+		rec.Comment = *comment
+	}
+	return rec
+}
+
+// updateAllRecords points every one of the host's records at ip.
+func (u *Updater) updateAllRecords(ctx context.Context, p provider.Provider, zoneID string, records []provider.Record, ip string) (*Change, error) {
+	config := u.config
+	var change *Change
+	for _, rec := range records {
+		if rec.Content == ip {
+			continue
+		}
+		if err := CheckOwner(config, rec); err != nil {
+			return change, Failed(config, StageOwnership, err)
+		}
+		if err := u.claimRecord(ctx, p, zoneID); err != nil {
+			return change, Failed(config, StageOwnership, err)
+		}
+		_, err := p.UpdateRecord(ctx, zoneID, updatedRecord(config, rec, ip, u.clock.Now()))
+		config.Audit.Record(AuditEntry{Action: "update", Zone: config.Zone, Host: config.Host, Type: config.RecordType, RecordID: rec.ID, OldIP: rec.Content, NewIP: ip}, err)
+		if err != nil {
+			return change, Failed(config, StageUpdate, err)
+		}
+		u.logger.InfoContext(ctx, "IP successfully changed",
+			"dns.question.name", config.Host,
+			"source.address", rec.Content,
+			"destination.address", ip,
+			"event.action", "ip_update",
+			"event.dataset", "dns",
+			"dns.id", rec.ID,
+		)
+		updateCount.WithLabelValues(config.Zone, config.Host).Inc()
+		if change == nil {
+			change = &Change{Zone: config.Zone, Host: config.Host, OldIP: rec.Content, NewIP: ip}
+		}
+	}
+	if change == nil {
+		u.logUnchanged(ctx, zoneID, ip, "records", len(records))
+	}
+	return change, nil
+}
+
+// consolidateRecords deletes all but one of the host's records and points
+// that one at ip. A record which already has the right address is kept in
+// preference, otherwise the oldest, so that its ID stays stable.
+func (u *Updater) consolidateRecords(ctx context.Context, p provider.Provider, zoneID string, records []provider.Record, ip string) (*Change, error) {
+	config := u.config
+	deleter, ok := p.(provider.RecordDeleter)
+	if !ok {
+		return nil, Failed(config, StageDelete, fmt.Errorf("%w: %s has %d, and the provider can't delete the duplicates", ErrTooManyRecords, config.Host, len(records)))
+	}
+	keep := 0
+	for i, rec := range records {
+		if rec.Content == ip {
+			keep = i
+			break
+		}
+		if rec.Created.Before(records[keep].Created) {
+			keep = i
+		}
+	}
+	for _, rec := range records {
+		if err := CheckOwner(config, rec); err != nil {
+			return nil, Failed(config, StageOwnership, err)
+		}
+	}
+	if err := u.claimRecord(ctx, p, zoneID); err != nil {
+		return nil, Failed(config, StageOwnership, err)
+	}
+	for i, rec := range records {
+		if i == keep {
+			continue
+		}
+		err := deleter.DeleteRecord(ctx, zoneID, rec)
+		config.Audit.Record(AuditEntry{Action: "delete", Zone: config.Zone, Host: config.Host, Type: config.RecordType, RecordID: rec.ID, OldIP: rec.Content}, err)
+		if err != nil {
+			return nil, Failed(config, StageDelete, err)
+		}
+		duplicatesDeleted.WithLabelValues(config.Zone, config.Host).Inc()
+		u.logger.InfoContext(ctx, "Deleted duplicate record",
+			"dns.question.name", config.Host,
+			"dns.id", rec.ID,
+			"source.address", rec.Content,
+			"event.action", "record_delete",
+			"event.dataset", "dns",
+		)
+	}
+	u.rememberRecord(zoneID, records[keep])
+	return u.updateRecord(ctx, p, zoneID, records[keep], ip)
+}
@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+// registryHeritage marks TXT records as ownership records written by us,
+// in the same style as external-dns.
+const registryHeritage = "heritage=cfdnsupdater"
+
+const registryOwnerKey = "cfdnsupdater/owner="
+
+// RegistryName is the name of the host's ownership TXT record.
+func RegistryName(config Config) string {
+	return config.RegistryPrefix + config.Host
+}
+
+func registryContent(owner string) string {
+	return fmt.Sprintf("%q", registryHeritage+","+registryOwnerKey+owner)
+}
+
+// RegistryOwner returns the owner named in an ownership TXT record, if it
+// is one.
+func RegistryOwner(content string) (string, bool) {
+	fields := strings.Split(strings.Trim(content, `"`), ",")
+	if len(fields) == 0 || fields[0] != registryHeritage {
+		return "", false
+	}
+	for _, f := range fields[1:] {
+		if owner, ok := strings.CutPrefix(f, registryOwnerKey); ok {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// claimRecord checks the ownership TXT record for the host before we
+// modify its records. If another owner has claimed the host, it returns an
+// error; if nobody has, it claims the host for us. It does nothing unless
+// an owner ID is configured.
+func (u *Updater) claimRecord(ctx context.Context, p provider.Provider, zoneID string) error {
+	config := u.config
+	if config.OwnerID == "" {
+		return nil
+	}
+	name := RegistryName(config)
+	txts, err := p.GetRecord(ctx, zoneID, name, "TXT")
+	if err != nil {
+		return err
+	}
+	for _, txt := range txts {
+		owner, ok := RegistryOwner(txt.Content)
+		if !ok {
+			continue
+		}
+		if owner != config.OwnerID {
+			return fmt.Errorf("%s is owned by %s according to %s, not modifying it", config.Host, owner, name)
+		}
+		return nil
+	}
+	created, err := p.CreateRecord(ctx, zoneID, provider.Record{
+		Name:    name,
+		Type:    "TXT",
+		Content: registryContent(config.OwnerID),
+	})
+	config.Audit.Record(AuditEntry{Action: "claim", Zone: config.Zone, Host: name, Type: "TXT", RecordID: created.ID, Content: registryContent(config.OwnerID)}, err)
+	if err != nil {
+		return fmt.Errorf("claiming %s: %w", config.Host, err)
+	}
+	u.logger.InfoContext(ctx, "Claimed ownership of host", "fqdn", config.Host, "owner", config.OwnerID, "registry", name)
+	return nil
+}
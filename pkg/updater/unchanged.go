@@ -0,0 +1,44 @@
+package updater
+
+import (
+	"context"
+	"time"
+)
+
+// unchangedRun is a stretch of cycles in which a record kept the same IP.
+// The first cycle of a run is logged, and after that only a summary every
+// UnchangedLogInterval, so a steady state doesn't fill the debug log with
+// the same line.
+type unchangedRun struct {
+	zoneID string
+	ip     string
+	since  time.Time
+	logged time.Time
+	cycles int
+}
+
+// logUnchanged notes that the record in the zone is already ip, logging
+// args with the message if it is time to.
+func (u *Updater) logUnchanged(ctx context.Context, zoneID string, ip string, args ...any) {
+	args = append([]any{"fqdn", u.config.Host, "ip", ip}, args...)
+	if u.config.UnchangedLogInterval <= 0 {
+		u.logger.DebugContext(ctx, "IP is already correct", args...)
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	now := u.clock.Now()
+	run := u.unchanged
+	if run == nil || run.zoneID != zoneID || run.ip != ip {
+		u.unchanged = &unchangedRun{zoneID: zoneID, ip: ip, since: now, logged: now, cycles: 1}
+		u.logger.DebugContext(ctx, "IP is already correct", args...)
+		return
+	}
+	run.cycles++
+	if now.Sub(run.logged) < u.config.UnchangedLogInterval {
+		return
+	}
+	run.logged = now
+	args = append(args, "cycles", run.cycles, "duration", now.Sub(run.since).Round(time.Second))
+	u.logger.DebugContext(ctx, "IP still unchanged", args...)
+}
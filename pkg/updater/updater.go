@@ -0,0 +1,371 @@
+// Package updater keeps a host's DNS record pointing at an IP address,
+// creating it if it doesn't exist.
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"jamesmcdonald.com/cfdnsupdater/internal/otlp"
+	"jamesmcdonald.com/cfdnsupdater/pkg/cfprovider"
+	"jamesmcdonald.com/cfdnsupdater/pkg/clock"
+	"jamesmcdonald.com/cfdnsupdater/pkg/provider"
+)
+
+var (
+	updateCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cfdnsupdater_update_count",
+		Help: "The number of DNS updates completed",
+	}, []string{"zone", "host"})
+)
+
+// Config describes the record to manage.
+type Config struct {
+	cfprovider.Account
+	Host       string
+	RecordType string
+	// MultipleRecords says what to do when the host has more than one
+	// record: error, update-all or consolidate.
+	MultipleRecords string
+	// MarkRecords sets a comment on records we write saying we manage
+	// them, and RespectOwner stops us modifying records whose comment says
+	// something else manages them. Version is included in the comment.
+	MarkRecords  bool
+	RespectOwner bool
+	Version      string
+	// OwnerID, if set, enables the ownership registry: a TXT record named
+	// RegistryPrefix plus the host records which instance owns the host,
+	// and we only modify hosts we own.
+	OwnerID        string
+	RegistryPrefix string
+	// ForceUpdate rewrites the record when it was last written this long
+	// ago, even if the IP hasn't changed. Zero disables it.
+	ForceUpdate time.Duration
+	// RecordRevalidate is how long a cached record is trusted before it
+	// is read from the provider again. Zero disables the cache.
+	RecordRevalidate time.Duration
+	// UnchangedLogInterval is how often to log a summary while the record
+	// stays correct, after logging that it is once. Zero logs every time.
+	UnchangedLogInterval time.Duration
+	// Canary, if set, puts us in observe-only mode: records are never
+	// changed, and our decisions are compared with what another instance
+	// actually does.
+	Canary *Canary
+	// Audit, if set, records every change made or attempted.
+	Audit *AuditLog
+	// Hooks are called as the updater works.
+	Hooks Hooks
+}
+
+// Change describes a record we created or updated. OldIP is empty if the
+// record was created.
+type Change struct {
+	Zone  string
+	Host  string
+	OldIP string
+	NewIP string
+}
+
+// defaultInterval is how often Run runs a cycle unless WithInterval says
+// otherwise.
+const defaultInterval = 5 * time.Minute
+
+// Updater keeps one host's record pointing at our address. It remembers
+// the record between cycles, so an Updater should be kept for as long as
+// the host is managed. It is safe for concurrent use, and one Updater per
+// host lets several hosts be managed at once.
+type Updater struct {
+	config   Config
+	provider provider.Provider
+	detect   func(context.Context) (string, error)
+	interval time.Duration
+	clock    clock.Clock
+	logger   *slog.Logger
+
+	mu sync.Mutex
+	// records holds what we last knew about the record, and writes when we
+	// last wrote it or first saw it, by zone ID
+	records   map[string]cachedRecord
+	writes    map[string]time.Time
+	unchanged *unchangedRun
+}
+
+// Option configures an Updater.
+type Option func(*Updater)
+
+// WithProvider sets the provider that manages the records. Without it, or
+// if p is nil, Cloudflare is used with the config's Account, making a new
+// client each time so that a replaced token file is picked up.
+func WithProvider(p provider.Provider) Option {
+	return func(u *Updater) {
+		u.provider = p
+	}
+}
+
+// WithDetector sets how Cycle and Run find the current IP, typically with
+// ipsource.Sources.Detect.
+func WithDetector(detect func(context.Context) (string, error)) Option {
+	return func(u *Updater) {
+		u.detect = detect
+	}
+}
+
+// WithInterval sets how often Run runs a cycle, five minutes by default.
+func WithInterval(interval time.Duration) Option {
+	return func(u *Updater) {
+		u.interval = interval
+	}
+}
+
+// WithClock sets the clock used for scheduling and for deciding when cached
+// records and forced updates are due.
+func WithClock(c clock.Clock) Option {
+	return func(u *Updater) {
+		u.clock = c
+	}
+}
+
+// WithLogger sets the logger, slog.Default() by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(u *Updater) {
+		u.logger = logger
+	}
+}
+
+// New returns an Updater managing the record described by config.
+func New(config Config, opts ...Option) *Updater {
+	u := &Updater{
+		config:   config,
+		interval: defaultInterval,
+		clock:    clock.System{},
+		logger:   slog.Default(),
+		records:  map[string]cachedRecord{},
+		writes:   map[string]time.Time{},
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// Config returns the configuration the Updater was made with.
+func (u *Updater) Config() Config {
+	return u.config
+}
+
+// Run runs a cycle every interval until ctx is cancelled, returning its
+// error. Intervals are measured from the start of each cycle, so the
+// schedule doesn't drift. Failed cycles are logged and reported to the
+// OnCycleError hook, and don't stop the loop.
+func (u *Updater) Run(ctx context.Context) error {
+	for {
+		start := u.clock.Now()
+		if _, err := u.Cycle(ctx); err != nil {
+			u.logger.ErrorContext(ctx, "Update failed", "fqdn", u.config.Host, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-u.clock.After(start.Add(u.interval).Sub(u.clock.Now())):
+		}
+	}
+}
+
+// Cycle detects the current IP and points the host's record at it,
+// calling the hooks as it goes.
+func (u *Updater) Cycle(ctx context.Context) (*Change, error) {
+	if u.detect == nil {
+		return nil, errors.New("no IP detector configured, see WithDetector")
+	}
+	ip, err := u.detect(ctx)
+	if err != nil {
+		err = Failed(u.config, StageIPLookup, err)
+		if u.config.Hooks.OnCycleError != nil {
+			u.config.Hooks.OnCycleError(ctx, err)
+		}
+		return nil, err
+	}
+	if u.config.Hooks.OnIPDetected != nil {
+		u.config.Hooks.OnIPDetected(ctx, ip)
+	}
+	change, err := u.UpdateHost(ctx, ip)
+	if err != nil && u.config.Hooks.OnCycleError != nil {
+		u.config.Hooks.OnCycleError(ctx, err)
+	}
+	return change, err
+}
+
+// UpdateHost makes the host's record point at ip, returning the change
+// made, if any.
+func (u *Updater) UpdateHost(ctx context.Context, ip string) (*Change, error) {
+	change, err := u.updateHost(ctx, ip)
+	if change != nil && u.config.Hooks.OnRecordChanged != nil {
+		u.config.Hooks.OnRecordChanged(ctx, *change)
+	}
+	return change, err
+}
+
+func (u *Updater) updateHost(ctx context.Context, ip string) (*Change, error) {
+	config := u.config
+	p := u.provider
+	if p == nil {
+		cf, err := cfprovider.New(config.Account)
+		if err != nil {
+			return nil, err
+		}
+		p = cf
+	}
+
+	zoneID, err := resolveZone(ctx, p, config)
+	if err != nil {
+		return nil, err
+	}
+	change, err := u.updateZoneRecord(ctx, p, zoneID, ip)
+	if errors.Is(err, provider.ErrZoneChanged) {
+		// the zone was probably deleted and recreated or moved between
+		// accounts, so it has a new ID
+		u.logger.WarnContext(ctx, "Zone ID is no longer valid, resolving zone again", "zone", config.Zone, "zone.id", zoneID, "error", err)
+		zoneID, err = resolveZone(ctx, p, config)
+		if err != nil {
+			return nil, err
+		}
+		change, err = u.updateZoneRecord(ctx, p, zoneID, ip)
+	}
+	return change, err
+}
+
+// resolveZone returns the ID of the configured zone.
+func resolveZone(ctx context.Context, p provider.Provider, config Config) (string, error) {
+	spanCtx, span := otlp.StartSpan(ctx, "zone_lookup", "zone", config.Zone)
+	zoneID, err := p.ResolveZone(spanCtx, config.Zone)
+	span.End(err)
+	if err != nil {
+		return "", Failed(config, StageZoneLookup, err)
+	}
+	return zoneID, nil
+}
+
+// updateZoneRecord makes the host's record in zone point at ip.
+func (u *Updater) updateZoneRecord(ctx context.Context, p provider.Provider, zoneID string, ip string) (*Change, error) {
+	config := u.config
+	// a forced refresh also catches changes made behind our back, so it
+	// always reads the record afresh
+	if config.Canary == nil && config.RecordRevalidate > 0 && !u.forceDue(zoneID) {
+		if rec, ok := u.lookupRecord(zoneID, config.RecordRevalidate); ok {
+			change, err := u.updateRecord(ctx, p, zoneID, rec, ip)
+			if !errors.Is(err, provider.ErrRecordNotFound) {
+				return change, err
+			}
+			u.logger.WarnContext(ctx, "Cached record no longer exists, listing records again", "fqdn", config.Host, "error", err)
+		}
+	}
+
+	spanCtx, span := otlp.StartSpan(ctx, "record_list", "dns.question.name", config.Host)
+	records, err := p.GetRecord(spanCtx, zoneID, config.Host, config.RecordType)
+	span.End(err)
+	if err != nil {
+		return nil, Failed(config, StageRecordList, err)
+	}
+
+	if config.Canary != nil && len(records) > 1 {
+		return nil, Failed(config, StageRecordList, fmt.Errorf("%w: %s has %d, which observe-only mode doesn't support", ErrTooManyRecords, config.Host, len(records)))
+	}
+	if config.Canary != nil {
+		current := ""
+		if len(records) == 1 {
+			current = records[0].Content
+		}
+		config.Canary.observe(u.clock.Now(), config.Host, current, ip)
+		return nil, nil
+	}
+
+	switch len(records) {
+	case 0:
+		rec := provider.Record{
+			Name:    config.Host,
+			Type:    config.RecordType,
+			Content: ip,
+		}
+		if comment := recordComment(config, u.clock.Now()); comment != nil {
+			rec.Comment = *comment
+		}
+		if err := u.claimRecord(ctx, p, zoneID); err != nil {
+			return nil, Failed(config, StageOwnership, err)
+		}
+		spanCtx, span := otlp.StartSpan(ctx, "create", "dns.question.name", config.Host, "destination.address", ip)
+		created, err := p.CreateRecord(spanCtx, zoneID, rec)
+		span.End(err)
+		config.Audit.Record(AuditEntry{Action: "create", Zone: config.Zone, Host: config.Host, Type: config.RecordType, RecordID: created.ID, NewIP: ip}, err)
+		if err != nil {
+			u.logger.ErrorContext(ctx, "Failed to create DNS record", "error", err)
+			return nil, Failed(config, StageCreate, err)
+		}
+		u.rememberRecord(zoneID, created)
+		u.recordWritten(zoneID)
+		u.logger.InfoContext(ctx, "Created a new record", "fqdn", config.Host, "type", config.RecordType, "ip", ip)
+		updateCount.WithLabelValues(config.Zone, config.Host).Inc()
+		return &Change{Zone: config.Zone, Host: config.Host, NewIP: ip}, nil
+	case 1:
+		u.rememberRecord(zoneID, records[0])
+		return u.updateRecord(ctx, p, zoneID, records[0], ip)
+	default:
+		switch config.MultipleRecords {
+		case "update-all":
+			return u.updateAllRecords(ctx, p, zoneID, records, ip)
+		case "consolidate":
+			return u.consolidateRecords(ctx, p, zoneID, records, ip)
+		default:
+			return nil, Failed(config, StageRecordList, fmt.Errorf("%w: %s has %d, only a single record is supported unless -multiple-records is set", ErrTooManyRecords, config.Host, len(records)))
+		}
+	}
+}
+
+// updateRecord points a record we have already read at ip, which takes no
+// API calls at all if it is already correct.
+func (u *Updater) updateRecord(ctx context.Context, p provider.Provider, zoneID string, rec provider.Record, ip string) (*Change, error) {
+	config := u.config
+	force := u.forceDue(zoneID)
+	if rec.Content == ip && !force {
+		u.logUnchanged(ctx, zoneID, ip)
+		return nil, nil
+	}
+
+	if err := CheckOwner(config, rec); err != nil {
+		return nil, Failed(config, StageOwnership, err)
+	}
+	if err := u.claimRecord(ctx, p, zoneID); err != nil {
+		return nil, Failed(config, StageOwnership, err)
+	}
+
+	oldip := rec.Content
+	spanCtx, span := otlp.StartSpan(ctx, "update", "dns.question.name", config.Host, "source.address", oldip, "destination.address", ip)
+	updated, err := p.UpdateRecord(spanCtx, zoneID, updatedRecord(config, rec, ip, u.clock.Now()))
+	span.End(err)
+	config.Audit.Record(AuditEntry{Action: "update", Zone: config.Zone, Host: config.Host, Type: config.RecordType, RecordID: rec.ID, OldIP: oldip, NewIP: ip}, err)
+	if err != nil {
+		u.forgetRecord(zoneID)
+		return nil, Failed(config, StageUpdate, err)
+	}
+	u.rememberRecord(zoneID, updated)
+	u.recordWritten(zoneID)
+	if oldip == ip {
+		u.logger.InfoContext(ctx, "Refreshed record", "dns.question.name", config.Host, "ip", ip, "event.action", "record_refresh", "event.dataset", "dns")
+		return nil, nil
+	}
+	u.logger.InfoContext(ctx, "IP successfully changed",
+		"dns.question.name", config.Host,
+		"source.address", oldip,
+		"destination.address", ip,
+		"event.action", "ip_update",
+		"event.dataset", "dns",
+	)
+	updateCount.WithLabelValues(config.Zone, config.Host).Inc()
+	return &Change{Zone: config.Zone, Host: config.Host, OldIP: oldip, NewIP: ip}, nil
+}